@@ -0,0 +1,130 @@
+package main
+
+import (
+  "database/sql"
+  "fmt"
+  "time"
+)
+
+// type to contain a period a user is out of office
+type OutOfOffice struct {
+  StartsOn time.Time
+  EndsOn time.Time
+}
+
+// creates the out of office table and the skipped column of participants if they do not exist yet
+func AttendanceSetup() {
+  stmts := []string{
+    "ALTER TABLE participants ADD COLUMN IF NOT EXISTS skipped BOOLEAN NOT NULL DEFAULT false;",
+    `CREATE TABLE IF NOT EXISTS out_of_office (
+      standup TEXT NOT NULL,
+      user_id TEXT NOT NULL,
+      starts_on DATE NOT NULL,
+      ends_on DATE NOT NULL,
+      PRIMARY KEY (standup, user_id)
+    );`,
+  }
+  for _, stmt := range stmts {
+    if _, err := DB.Exec(stmt); err != nil {
+      LOG.Error("Error creating attendance tables", "err", err)
+      return
+    }
+  }
+}
+
+// skips the current session for the given user, so they are no longer awaited
+// and the session does not count against them in reports
+// returns false if there is no open session or the user already answered it
+func SkipSession(cfg *Config, userId string) bool {
+  sessionId := GetCurrentSessionId(cfg)
+  if sessionId == 0 {
+    return false
+  }
+  res, err := DB.ExecContext(cfg.Context(),
+    "UPDATE participants SET skipped = true WHERE session_id = $1 AND user_id = $2 AND responded_at IS NULL;",
+    sessionId, userId)
+  if err != nil {
+    cfg.Log().Error("Error skipping session", "err", err)
+    return false
+  }
+  rowsAff, _ := res.RowsAffected()
+  if rowsAff == 0 {
+    return false
+  }
+  UpdateUser(userId)
+  return true
+}
+
+// sets the given user as out of office between the two dates (inclusive)
+func SetOutOfOffice(cfg *Config, userId string, startsOn, endsOn time.Time) error {
+  if endsOn.Before(startsOn) {
+    return fmt.Errorf("the end date must not be before the start date")
+  }
+  _, err := DB.ExecContext(cfg.Context(), `INSERT INTO out_of_office (standup, user_id, starts_on, ends_on) VALUES ($1, $2, $3, $4)
+    ON CONFLICT (standup, user_id) DO UPDATE SET starts_on = $3, ends_on = $4;`,
+    cfg.Standup.Name, userId, startsOn.Format(DATE_FORMAT), endsOn.Format(DATE_FORMAT))
+  return err
+}
+
+// clears the out of office period of the given user
+func ClearOutOfOffice(cfg *Config, userId string) error {
+  _, err := DB.ExecContext(cfg.Context(), "DELETE FROM out_of_office WHERE standup = $1 AND user_id = $2;", cfg.Standup.Name, userId)
+  return err
+}
+
+// gets the out of office period of the given user, if one is set and has not ended
+func GetOutOfOffice(cfg *Config, userId string) (ooo OutOfOffice, ok bool) {
+  var startsOn, endsOn string
+  err := DB.QueryRowContext(cfg.Context(), `SELECT to_char(starts_on, 'YYYY-MM-DD'), to_char(ends_on, 'YYYY-MM-DD') FROM out_of_office
+    WHERE standup = $1 AND user_id = $2 AND ends_on >= $3;`,
+    cfg.Standup.Name, userId, time.Now().In(GetLocation()).Format(DATE_FORMAT)).Scan(&startsOn, &endsOn)
+  if err != nil {
+    if err != sql.ErrNoRows {
+      cfg.Log().Error("Error getting out of office", "err", err)
+    }
+    return ooo, false
+  }
+  loc := GetLocation()
+  ooo.StartsOn, _ = time.ParseInLocation(DATE_FORMAT, startsOn, loc)
+  ooo.EndsOn, _ = time.ParseInLocation(DATE_FORMAT, endsOn, loc)
+  return ooo, true
+}
+
+// gets the set of users out of office today
+func GetOutOfOfficeUsers(cfg *Config) map[string]bool {
+  users := make(map[string]bool)
+  today := time.Now().In(GetLocation()).Format(DATE_FORMAT)
+  rows, err := DB.QueryContext(cfg.Context(), "SELECT user_id FROM out_of_office WHERE standup = $1 AND starts_on <= $2 AND ends_on >= $2;",
+    cfg.Standup.Name, today)
+  if err != nil {
+    cfg.Log().Error("Error getting out of office users", "err", err)
+    return users
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var user string
+    if err = rows.Scan(&user); err != nil {
+      cfg.Log().Error("Error converting user id to string", "err", err)
+      continue
+    }
+    users[user] = true
+  }
+  return users
+}
+
+// removes the users out of office today from the given list and from the awaited users
+func RemoveOutOfOfficeUsers(cfg *Config, users []string) []string {
+  ooo := GetOutOfOfficeUsers(cfg)
+  if len(ooo) == 0 {
+    return users
+  }
+  present := make([]string, 0, len(users))
+  for _, user := range users {
+    if ooo[user] {
+      UpdateUser(user)
+      continue
+    }
+    present = append(present, user)
+  }
+  return present
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const SOURCE_SLASH = "slash"
+const SOURCE_MENTION = "mention"
+const SOURCE_SCHEDULE = "schedule"
+const SOURCE_API = "api"
+const SOURCE_HOME = "home"
+
+// the actor recorded for actions taken by the scheduler
+const SCHEDULER_ACTOR = "scheduler"
+
+const DEFAULT_AUDIT_PAGE_SIZE = 20
+const MAX_AUDIT_PAGE_SIZE = 200
+
+// type to contain an entry of the audit log
+type AuditEntry struct {
+  Id int64 `json:"id"`
+  Standup string `json:"standup"`
+  Actor string `json:"actor"`
+  Action string `json:"action"`
+  Source string `json:"source"`
+  Detail string `json:"detail"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// type to contain a page of the audit log
+// NextBefore is passed as before to get the next page, and is 0 on the last page
+type AuditPage struct {
+  Entries []AuditEntry `json:"entries"`
+  NextBefore int64 `json:"next_before"`
+}
+
+// creates the audit log table if it does not exist yet
+// the table is append only, so updates and deletes are rejected by a trigger
+func AuditSetup() {
+  stmts := []string{
+    `CREATE TABLE IF NOT EXISTS audit_log (
+      id SERIAL PRIMARY KEY,
+      standup TEXT NOT NULL,
+      actor TEXT NOT NULL,
+      action TEXT NOT NULL,
+      detail TEXT NOT NULL,
+      created_at TIMESTAMPTZ NOT NULL
+    );`,
+    "ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';",
+    `CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+    BEGIN
+      RAISE EXCEPTION 'audit_log is append only';
+    END;
+    $$ LANGUAGE plpgsql;`,
+    "DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;",
+    `CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+      FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();`,
+  }
+  for _, stmt := range stmts {
+    if _, err := DB.Exec(stmt); err != nil {
+      LOG.Error("Error creating audit log table", "err", err)
+      return
+    }
+  }
+}
+
+// records an action taken by the actor in the audit log
+func RecordAudit(cfg *Config, actor, action, source, detail string) {
+  if _, err := DB.ExecContext(cfg.Context(),
+    "INSERT INTO audit_log (standup, actor, action, source, detail, created_at) VALUES ($1, $2, $3, $4, $5, $6);",
+    cfg.Standup.Name, actor, action, source, detail, time.Now()); err != nil {
+    cfg.Log().Error("Error recording audit log", "err", err)
+  }
+}
+
+// gets a page of the audit log of the standup, newest first
+// only entries with an id lower than before are included, unless before is 0
+func GetAuditPage(cfg *Config, before int64, limit int) (page AuditPage, err error) {
+  if limit <= 0 || limit > MAX_AUDIT_PAGE_SIZE {
+    limit = DEFAULT_AUDIT_PAGE_SIZE
+  }
+  // fetch one extra entry to know if there is another page
+  rows, err := DB.QueryContext(cfg.Context(), `SELECT id, standup, actor, action, source, detail, created_at FROM audit_log
+    WHERE standup = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3;`, cfg.Standup.Name, before, limit+1)
+  if err != nil {
+    return page, err
+  }
+  defer rows.Close()
+  page.Entries = make([]AuditEntry, 0, limit)
+  for rows.Next() {
+    var entry AuditEntry
+    if err = rows.Scan(&entry.Id, &entry.Standup, &entry.Actor, &entry.Action, &entry.Source,
+      &entry.Detail, &entry.CreatedAt); err != nil {
+      return page, err
+    }
+    page.Entries = append(page.Entries, entry)
+  }
+  if err = rows.Err(); err != nil {
+    return page, err
+  }
+  if len(page.Entries) > limit {
+    page.Entries = page.Entries[:limit]
+    page.NextBefore = page.Entries[limit-1].Id
+  }
+  return page, nil
+}
+
+// formats an audit entry as a line of a Slack message
+func FormatAuditEntry(entry AuditEntry) string {
+  actor := entry.Actor
+  if strings.HasPrefix(actor, "U") || strings.HasPrefix(actor, "W") {
+    actor = fmt.Sprintf("<@%s>", actor)
+  }
+  line := fmt.Sprintf("%s %s `%s` via %s", entry.CreatedAt.In(GetLocation()).Format("Jan 2 3:04pm"), actor, entry.Action, entry.Source)
+  if entry.Detail != "" {
+    line = fmt.Sprintf("%s: %s", line, entry.Detail)
+  }
+  return line
+}
+
+// runs the audit command, showing a page of the audit log
+// the optional arg is the id to show entries before, as given at the end of the previous page
+func RunAuditCommand(cfg *Config, req SlashRequest, args []string) string {
+  var before int64
+  if len(args) > 0 {
+    parsed, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+      return "Usage: `audit [before]`"
+    }
+    before = parsed
+  }
+  page, err := GetAuditPage(cfg, before, DEFAULT_AUDIT_PAGE_SIZE)
+  if err != nil {
+    cfg.Log().Error("Error getting audit log", "err", err)
+    return "Could not get the audit log, try again later"
+  }
+  if len(page.Entries) == 0 {
+    return "The audit log is empty."
+  }
+  builder := strings.Builder{}
+  for _, entry := range page.Entries {
+    builder.WriteString(FormatAuditEntry(entry))
+    builder.WriteString("\n")
+  }
+  if page.NextBefore != 0 {
+    fmt.Fprintf(&builder, "For older entries use `%s audit %d`", req.Command, page.NextBefore)
+  }
+  return builder.String()
+}
+
+// the handler for the /api/audit endpoint
+// responds with a page of the audit log as JSON, using the before and limit query params
+func AuditApiHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  if !IsApiAuthorized(cfg, r) {
+    http.Error(w, "unauthorized", http.StatusUnauthorized)
+    return
+  }
+
+  query := r.URL.Query()
+  var before int64
+  var limit int
+  var err error
+  if value := query.Get("before"); value != "" {
+    if before, err = strconv.ParseInt(value, 10, 64); err != nil {
+      http.Error(w, "before must be an audit entry id", http.StatusBadRequest)
+      return
+    }
+  }
+  if value := query.Get("limit"); value != "" {
+    if limit, err = strconv.Atoi(value); err != nil {
+      http.Error(w, "limit must be a number", http.StatusBadRequest)
+      return
+    }
+  }
+
+  page, err := GetAuditPage(cfg, before, limit)
+  if err != nil {
+    cfg.Log().Error("Error getting audit log", "err", err)
+    http.Error(w, "could not get audit log", http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestFormatAuditEntry(t *testing.T) {
+  createdAt := time.Date(2024, 5, 6, 13, 4, 0, 0, GetLocation())
+  tests := []struct {
+    entry AuditEntry
+    want string
+  }{
+    {AuditEntry{Actor: "U1", Action: "open", Source: SOURCE_SLASH, CreatedAt: createdAt}, "May 6 1:04pm <@U1> `open` via slash"},
+    {AuditEntry{Actor: SCHEDULER_ACTOR, Action: "digest", Source: SOURCE_SCHEDULE, Detail: "eng", CreatedAt: createdAt},
+      "May 6 1:04pm scheduler `digest` via schedule: eng"},
+  }
+  for _, test := range tests {
+    if got := FormatAuditEntry(test.entry); got != test.want {
+      t.Errorf("FormatAuditEntry(%+v) = %q, want %q", test.entry, got, test.want)
+    }
+  }
+}
+
+func TestRunAuditCommandUsage(t *testing.T) {
+  // the page to show is checked before the db is read
+  if got := RunAuditCommand(&Config{}, SlashRequest{Command: "/standup"}, []string{"latest"}); got != "Usage: `audit [before]`" {
+    t.Errorf("RunAuditCommand(latest) = %q, want the usage", got)
+  }
+}
+
+// gets the ids of the entries of an audit page
+func auditIds(page AuditPage) []int64 {
+  ids := make([]int64, 0, len(page.Entries))
+  for _, entry := range page.Entries {
+    ids = append(ids, entry.Id)
+  }
+  return ids
+}
+
+func TestGetAuditPage(t *testing.T) {
+  requireDB(t)
+  cfg := sessionConfig()
+  other := sessionConfig()
+  other.Standup.Name = "other"
+  for i := 1; i <= 5; i++ {
+    RecordAudit(cfg, "U1", "open", SOURCE_SLASH, fmt.Sprint(i))
+    // entries of other standups are interleaved, and left out of every page
+    RecordAudit(other, "U2", "open", SOURCE_SLASH, fmt.Sprint(i))
+  }
+
+  tests := []struct {
+    before int64
+    limit int
+    ids []int64
+    nextBefore int64
+  }{
+    {0, 2, []int64{9, 7}, 7},
+    {7, 2, []int64{5, 3}, 3},
+    {3, 2, []int64{1}, 0},
+    {0, 5, []int64{9, 7, 5, 3, 1}, 0},
+    {1, 2, []int64{}, 0},
+    // out of range limits fall back to the default page size
+    {0, MAX_AUDIT_PAGE_SIZE + 1, []int64{9, 7, 5, 3, 1}, 0},
+  }
+  for _, test := range tests {
+    page, err := GetAuditPage(cfg, test.before, test.limit)
+    if err != nil {
+      t.Fatalf("GetAuditPage(%d, %d) = %v", test.before, test.limit, err)
+    }
+    if ids := auditIds(page); fmt.Sprint(ids) != fmt.Sprint(test.ids) || page.NextBefore != test.nextBefore {
+      t.Errorf("GetAuditPage(%d, %d) = %v next %d, want %v next %d", test.before, test.limit, ids, page.NextBefore,
+        test.ids, test.nextBefore)
+    }
+  }
+}
+
+func TestRunAuditCommandPages(t *testing.T) {
+  requireDB(t)
+  cfg := sessionConfig()
+  req := SlashRequest{UserId: "U1", Command: "/standup"}
+  if got := RunAuditCommand(cfg, req, nil); got != "The audit log is empty." {
+    t.Errorf("RunAuditCommand() of an empty log = %q", got)
+  }
+  for i := 1; i <= DEFAULT_AUDIT_PAGE_SIZE+1; i++ {
+    RecordAudit(cfg, "U1", "remind", SOURCE_SLASH, fmt.Sprintf("entry %d", i))
+  }
+
+  first := RunAuditCommand(cfg, req, nil)
+  if lines := strings.Count(first, "`remind`"); lines != DEFAULT_AUDIT_PAGE_SIZE {
+    t.Errorf("first page shows %d entries, want %d", lines, DEFAULT_AUDIT_PAGE_SIZE)
+  }
+  if !strings.HasSuffix(first, "For older entries use `/standup audit 2`") {
+    t.Errorf("first page = %q, want it to end with how to get the next page", first)
+  }
+  last := RunAuditCommand(cfg, req, []string{"2"})
+  if !strings.Contains(last, "entry 1\n") || strings.Count(last, "`remind`") != 1 || strings.Contains(last, "older entries") {
+    t.Errorf("last page = %q, want only the oldest entry", last)
+  }
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// answers to the blockers question that mean there are no blockers
+var NO_BLOCKER_ANSWERS = []string{"", "none", "no", "nope", "n/a", "na", "nothing", "-"}
+
+// type to detect blockers in checkin responses
+// if Prefix is set and a line starts with it, that line is treated as the answer to a dedicated
+// blockers question and is the only thing checked, otherwise every line is checked against
+// the keywords and patterns
+// keywords match whole words, so "unblocked" doesn't match "blocked", and are ignored when negated, as in
+// "no blockers", or when they label an answer meaning there are none, as in "Blockers: none"
+type BlockerDetector struct {
+  Prefix string
+  Keywords []string
+  Patterns []*regexp.Regexp
+  keywordPatterns []*regexp.Regexp
+}
+
+// type to contain a blocker tracked across sessions
+type Blocker struct {
+  Id int64
+  UserId string
+  Name string
+  Text string
+  OpenedAt time.Time
+  LastReportedAt time.Time
+  TimesReported int
+}
+
+// the keywords used when none are configured
+var DEFAULT_BLOCKER_KEYWORDS = []string{"blocked", "blocker", "blocking", "stuck"}
+
+// words that, just before a keyword, mean the line says there is no blocker
+var BLOCKER_NEGATIONS = map[string]bool{"no": true, "not": true, "nothing": true, "none": true, "never": true,
+  "without": true, "zero": true}
+
+// creates a blocker detector from the blocker config
+// the returned detector is always usable, an invalid pattern is left out and returned as the error
+func NewBlockerDetector(cfg BlockerConfig) (*BlockerDetector, error) {
+  detector := &BlockerDetector{Prefix: strings.ToLower(strings.TrimSpace(cfg.Prefix)), Keywords: DEFAULT_BLOCKER_KEYWORDS}
+  if len(cfg.Keywords) > 0 {
+    detector.Keywords = nil
+    for _, keyword := range cfg.Keywords {
+      if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+        detector.Keywords = append(detector.Keywords, keyword)
+      }
+    }
+  }
+  for _, keyword := range detector.Keywords {
+    // a keyword also matches its plural, so "blocker" matches "2 blockers"
+    detector.keywordPatterns = append(detector.keywordPatterns,
+      regexp.MustCompile(fmt.Sprintf(`(?i)\b%ss?\b`, regexp.QuoteMeta(keyword))))
+  }
+  if cfg.Pattern != "" {
+    compiled, err := regexp.Compile(cfg.Pattern)
+    if err != nil {
+      return detector, fmt.Errorf("invalid blocker pattern %q: %s", cfg.Pattern, err)
+    }
+    detector.Patterns = append(detector.Patterns, compiled)
+  }
+  return detector, nil
+}
+
+// gets the answer to the blockers question in the given line, if the line is one
+func (d *BlockerDetector) prefixAnswer(line string) (answer string, ok bool) {
+  trimmed := strings.TrimSpace(line)
+  if d.Prefix == "" || !strings.HasPrefix(strings.ToLower(trimmed), d.Prefix) {
+    return "", false
+  }
+  return strings.TrimSpace(trimmed[len(d.Prefix):]), true
+}
+
+// determines if the answer to the blockers question reports a blocker
+func isBlockerAnswer(answer string) bool {
+  lower := strings.ToLower(strings.Trim(answer, " .!"))
+  for _, none := range NO_BLOCKER_ANSWERS {
+    if lower == none {
+      return false
+    }
+  }
+  return true
+}
+
+// determines if a keyword found between the given text is negated, by a word like "no" or "not" just before it,
+// or by being the label of an answer meaning there are no blockers, like "Blockers: none"
+func isNegatedKeyword(before, after string) bool {
+  trimmed := strings.TrimSpace(after)
+  if strings.HasPrefix(trimmed, ":") || strings.HasPrefix(trimmed, "-") {
+    return !isBlockerAnswer(strings.TrimSpace(trimmed[1:]))
+  }
+  words := strings.Fields(strings.ToLower(before))
+  if len(words) == 0 {
+    return false
+  }
+  last := strings.Trim(words[len(words)-1], ".,;:!?()\"'")
+  if BLOCKER_NEGATIONS[last] || strings.HasSuffix(last, "n't") || strings.HasSuffix(last, "n’t") {
+    return true
+  }
+  // "no longer blocked"
+  return len(words) > 1 && last == "longer" && words[len(words)-2] == "no"
+}
+
+// determines if a single line of a response mentions a blocker
+func (d *BlockerDetector) IsBlockerLine(line string) bool {
+  if answer, ok := d.prefixAnswer(line); ok {
+    return isBlockerAnswer(answer)
+  }
+  for _, keyword := range d.keywordPatterns {
+    for _, match := range keyword.FindAllStringIndex(line, -1) {
+      if !isNegatedKeyword(line[:match[0]], line[match[1]:]) {
+        return true
+      }
+    }
+  }
+  for _, pattern := range d.Patterns {
+    if pattern.MatchString(line) {
+      return true
+    }
+  }
+  return false
+}
+
+// gets the blockers reported in a response
+func (d *BlockerDetector) Detect(text string) (blockers []string) {
+  lines := strings.Split(text, "\n")
+  for _, line := range lines {
+    if answer, ok := d.prefixAnswer(line); ok {
+      if isBlockerAnswer(answer) {
+        return []string{answer}
+      }
+      return nil
+    }
+  }
+  for _, line := range lines {
+    if line = strings.TrimSpace(line); line != "" && d.IsBlockerLine(line) {
+      blockers = append(blockers, line)
+    }
+  }
+  return blockers
+}
+
+// highlights the lines of a response that mention a blocker
+func HighlightBlockers(cfg *Config, text string) string {
+  lines := strings.Split(text, "\n")
+  for pos, line := range lines {
+    if strings.TrimSpace(line) != "" && cfg.BlockerDetector().IsBlockerLine(line) {
+      lines[pos] = fmt.Sprintf(":warning: *%s*", strings.TrimSpace(line))
+    }
+  }
+  return strings.Join(lines, "\n")
+}
+
+// creates the blockers table if it does not exist yet
+func BlockerSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS blockers (
+      id SERIAL PRIMARY KEY,
+      standup TEXT NOT NULL,
+      user_id TEXT NOT NULL,
+      user_name TEXT NOT NULL,
+      text TEXT NOT NULL,
+      response_id INTEGER REFERENCES responses(id),
+      opened_at TIMESTAMPTZ NOT NULL,
+      last_reported_at TIMESTAMPTZ NOT NULL,
+      times_reported INTEGER NOT NULL DEFAULT 1,
+      resolved_at TIMESTAMPTZ,
+      resolved_by TEXT
+    );`); err != nil {
+    LOG.Error("Error creating blockers table", "err", err)
+  }
+}
+
+// gets the open blockers of the standup, or of a single user if userId is not empty
+func GetOpenBlockers(cfg *Config, userId string) (blockers []Blocker, err error) {
+  rows, err := DB.QueryContext(cfg.Context(), `SELECT id, user_id, user_name, text, opened_at, last_reported_at, times_reported
+    FROM blockers WHERE standup = $1 AND resolved_at IS NULL AND ($2 = '' OR user_id = $2)
+    ORDER BY opened_at, id;`, cfg.Standup.Name, userId)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var blocker Blocker
+    if err = rows.Scan(&blocker.Id, &blocker.UserId, &blocker.Name, &blocker.Text,
+      &blocker.OpenedAt, &blocker.LastReportedAt, &blocker.TimesReported); err != nil {
+      return nil, err
+    }
+    blockers = append(blockers, blocker)
+  }
+  return blockers, rows.Err()
+}
+
+// records the blockers from a user's response, returning the blockers that are still open
+// a blocker matching one the user already has open is carried over instead of opened again,
+// and the user's open blockers that were not reported again are resolved
+func TrackBlockers(cfg *Config, userId, name string, responseId int64, texts []string) (tracked []Blocker) {
+  open, err := GetOpenBlockers(cfg, userId)
+  if err != nil {
+    cfg.Log().Error("Error getting open blockers", "err", err)
+    return nil
+  }
+
+  now := time.Now()
+  reported := make(map[int64]bool)
+  for _, text := range texts {
+    var blocker *Blocker
+    for pos := range open {
+      if strings.EqualFold(open[pos].Text, text) {
+        blocker = &open[pos]
+      }
+    }
+
+    if blocker != nil {
+      if _, err := DB.ExecContext(cfg.Context(),
+        "UPDATE blockers SET last_reported_at = $1, times_reported = times_reported + 1 WHERE id = $2;",
+        now, blocker.Id); err != nil {
+        cfg.Log().Error("Error updating blocker", "err", err)
+      }
+      blocker.LastReportedAt = now
+      blocker.TimesReported++
+      reported[blocker.Id] = true
+      tracked = append(tracked, *blocker)
+      continue
+    }
+
+    created := Blocker{UserId: userId, Name: name, Text: text, OpenedAt: now, LastReportedAt: now, TimesReported: 1}
+    if err := DB.QueryRowContext(cfg.Context(), `INSERT INTO blockers (standup, user_id, user_name, text, response_id, opened_at, last_reported_at)
+      VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $6) RETURNING id;`,
+      cfg.Standup.Name, userId, name, text, responseId, now).Scan(&created.Id); err != nil {
+      cfg.Log().Error("Error inserting blocker", "err", err)
+      continue
+    }
+    tracked = append(tracked, created)
+  }
+
+  for _, blocker := range open {
+    if !reported[blocker.Id] {
+      ResolveBlocker(cfg, blocker.Id, userId)
+    }
+  }
+  return tracked
+}
+
+// marks a blocker as resolved by the given user, returning false if it was not open
+func ResolveBlocker(cfg *Config, id int64, resolvedBy string) bool {
+  res, err := DB.ExecContext(cfg.Context(),
+    "UPDATE blockers SET resolved_at = $1, resolved_by = $2 WHERE id = $3 AND standup = $4 AND resolved_at IS NULL;",
+    time.Now(), resolvedBy, id, cfg.Standup.Name)
+  if err != nil {
+    cfg.Log().Error("Error resolving blocker", "err", err)
+    return false
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff != 0
+}
+
+// formats a blocker as a line of a Slack message
+func FormatBlocker(blocker Blocker) string {
+  line := fmt.Sprintf("#%d <@%s>: %s", blocker.Id, blocker.UserId, blocker.Text)
+  if blocker.TimesReported > 1 {
+    line = fmt.Sprintf("%s _(reported %d times since %s)_", line, blocker.TimesReported,
+      blocker.OpenedAt.In(GetLocation()).Format("Jan 2"))
+  }
+  return line
+}
+
+// sends the newly opened blockers to the blocker channel and DMs them to the blocker owner, when set
+// blockers carried over from earlier sessions have already been escalated
+func EscalateBlockers(cfg *Config, blockers []Blocker, threadId string) {
+  if cfg.Blockers.ChannelId == "" && cfg.Blockers.Owner == "" {
+    return
+  }
+  builder := strings.Builder{}
+  builder.WriteString(":warning: New blockers reported in standup:")
+  count := 0
+  for _, blocker := range blockers {
+    if blocker.TimesReported > 1 {
+      continue
+    }
+    count++
+    builder.WriteString("\n")
+    builder.WriteString(FormatBlocker(blocker))
+  }
+  if count == 0 {
+    return
+  }
+  if threadId != "" && cfg.Standup.ChannelId != "" {
+    fmt.Fprintf(&builder, "\nSee the checkin thread in <#%s>.", cfg.Standup.ChannelId)
+  }
+  message := builder.String()
+
+  if cfg.Blockers.ChannelId != "" {
+    SendMessage(cfg, message, cfg.Blockers.ChannelId, "")
+  }
+  if cfg.Blockers.Owner != "" {
+    MessageUser(cfg, cfg.Blockers.Owner, message)
+  }
+}
+
+// runs the blockers command, listing the open blockers or resolving one when the args are "resolve <id>"
+// anyone can resolve their own blockers, but only admins can resolve other users' blockers
+func RunBlockersCommand(cfg *Config, userId string, args []string) string {
+  if len(args) == 0 {
+    blockers, err := GetOpenBlockers(cfg, "")
+    if err != nil {
+      cfg.Log().Error("Error getting open blockers", "err", err)
+      return "Could not get the open blockers, try again later"
+    }
+    if len(blockers) == 0 {
+      return "There are no open blockers :tada:"
+    }
+    lines := make([]string, 0, len(blockers))
+    for _, blocker := range blockers {
+      lines = append(lines, FormatBlocker(blocker))
+    }
+    return fmt.Sprintf("Open blockers:\n%s", strings.Join(lines, "\n"))
+  }
+
+  if len(args) != 2 || args[0] != "resolve" {
+    return "Usage: `blockers` to list open blockers, `blockers resolve <id>` to resolve one"
+  }
+  id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+  if err != nil {
+    return fmt.Sprintf("%s is not a blocker id", args[1])
+  }
+
+  if !HasPermission(cfg, userId, PERM_MANAGE_BLOCKERS) {
+    own, err := GetOpenBlockers(cfg, userId)
+    if err != nil {
+      cfg.Log().Error("Error getting open blockers", "err", err)
+    }
+    isOwn := false
+    for _, blocker := range own {
+      isOwn = isOwn || blocker.Id == id
+    }
+    if !isOwn {
+      return "You can only resolve your own blockers"
+    }
+  }
+
+  if !ResolveBlocker(cfg, id, userId) {
+    return fmt.Sprintf("There is no open blocker #%d", id)
+  }
+  RecordAudit(cfg, userId, "resolve_blocker", SOURCE_SLASH, fmt.Sprintf("#%d", id))
+  return fmt.Sprintf("Blocker #%d resolved", id)
+}
+
+// the handler for the /blockers endpoint
+func BlockersHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if GetRole(cfg, req.UserId) == "" {
+    w.Write([]byte("You don't have permission to view blockers"))
+    return
+  }
+  w.Write([]byte(RunBlockersCommand(cfg, req.UserId, strings.Fields(req.Text))))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestIsBlockerLine(t *testing.T) {
+  defaults, _ := NewBlockerDetector(BlockerConfig{})
+  prefixed, _ := NewBlockerDetector(BlockerConfig{Prefix: "Blockers:"})
+  custom, _ := NewBlockerDetector(BlockerConfig{Keywords: []string{" Waiting on ", "help"}, Pattern: `(?i)\bETA\b`})
+  tests := []struct {
+    detector *BlockerDetector
+    line string
+    want bool
+  }{
+    {defaults, "Blocked by the deploy freeze", true},
+    {defaults, "still stuck on the migration", true},
+    {defaults, "Two blockers today", true},
+    {defaults, "Blocker: waiting on review", true},
+    {defaults, "blocked - no idea why", true},
+    {defaults, "Not blocked, but stuck on the tests", true},
+    {defaults, "This is BLOCKING the release", true},
+    {defaults, "No blockers", false},
+    {defaults, "no blockers today!", false},
+    {defaults, "Blockers: none", false},
+    {defaults, "Blockers: n/a.", false},
+    {defaults, "blockers - nope", false},
+    {defaults, "got unblocked yesterday", false},
+    {defaults, "I'm not blocked", false},
+    {defaults, "isn't blocking anyone", false},
+    {defaults, "no longer stuck", false},
+    {defaults, "nothing blocking me", false},
+    {defaults, "Finished the unstuckable parser", false},
+    {defaults, "Reviewed the blockchain docs", false},
+    {defaults, "Wrote tests", false},
+    {prefixed, "Blockers: waiting on design", true},
+    {prefixed, "blockers: None", false},
+    {prefixed, "Blockers:", false},
+    {prefixed, "stuck on CI", true},
+    {prefixed, "No blockers", false},
+    {custom, "waiting on the API keys", true},
+    {custom, "need help with the schema", true},
+    {custom, "no help needed", false},
+    {custom, "blocked by infra", false},
+    {custom, "no ETA for the fix", true},
+  }
+  for _, test := range tests {
+    if got := test.detector.IsBlockerLine(test.line); got != test.want {
+      t.Errorf("IsBlockerLine(%q) with keywords %v and prefix %q = %t, want %t", test.line, test.detector.Keywords,
+        test.detector.Prefix, got, test.want)
+    }
+  }
+}
+
+func TestDetect(t *testing.T) {
+  defaults, _ := NewBlockerDetector(BlockerConfig{})
+  prefixed, _ := NewBlockerDetector(BlockerConfig{Prefix: "Blockers:"})
+  tests := []struct {
+    name string
+    detector *BlockerDetector
+    text string
+    want []string
+  }{
+    {"nothing to report", defaults, "Wrote tests\nReviewed PRs", nil},
+    {"keyword lines", defaults, "Wrote tests\n  stuck on CI  \n\nblocked by design", []string{"stuck on CI", "blocked by design"}},
+    {"no blockers", defaults, "Wrote tests\nNo blockers", nil},
+    {"blockers answered none", defaults, "Wrote tests\nBlockers: none", nil},
+    {"unblocked", defaults, "Got unblocked and shipped it", nil},
+    {"prefixed answer", prefixed, "Stuck on CI\nBlockers: waiting on review", []string{"waiting on review"}},
+    {"prefixed answer of none", prefixed, "Stuck on CI\nBlockers: none", nil},
+    {"no prefixed answer", prefixed, "Stuck on CI\nshipped it", []string{"Stuck on CI"}},
+  }
+  for _, test := range tests {
+    if got := test.detector.Detect(test.text); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: Detect(%q) = %q, want %q", test.name, test.text, got, test.want)
+    }
+  }
+}
+
+func TestNewBlockerDetectorInvalidPattern(t *testing.T) {
+  detector, err := NewBlockerDetector(BlockerConfig{Pattern: "("})
+  if err == nil {
+    t.Errorf("NewBlockerDetector() with an invalid pattern returned no error")
+  }
+  if detector == nil || !detector.IsBlockerLine("stuck") || len(detector.Patterns) != 0 {
+    t.Errorf("NewBlockerDetector() with an invalid pattern = %+v, want a usable detector without the pattern", detector)
+  }
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+  "unicode"
+)
+
+// type to contain the fields of a Slack slash command request
+type SlashRequest struct {
+  UserId string
+  UserName string
+  ChannelId string
+  Command string
+  Text string
+  ResponseUrl string
+}
+
+// type to contain a subcommand of the /standup slash command
+// an empty Permission allows anyone with a role in the standup
+// Async subcommands are run by the job queue and reply through the response url
+type Subcommand struct {
+  Name string
+  Usage string
+  Description string
+  Permission string
+  Async bool
+  Run func(cfg *Config, req SlashRequest, args []string) string
+}
+
+// type to marshal slash command responses into
+type SlashResponse struct {
+  ResponseType string `json:"response_type"`
+  Text string `json:"text"`
+}
+
+// type to contain the response of a queued slash command
+type SlashReply struct {
+  ResponseUrl string
+  Text string
+}
+
+// where the response urls of slash commands are, replies to other urls are refused
+const SLACK_RESPONSE_URL_PREFIX = "https://hooks.slack.com/"
+
+var SUBCOMMANDS []Subcommand
+
+func init() {
+  SUBCOMMANDS = []Subcommand{
+    {Name: "open", Usage: "open", Description: "open a checkin session and DM everyone in the channel", Permission: PERM_RUN_SESSIONS, Async: true, Run: RunOpenCommand},
+    {Name: "close", Usage: "close", Description: "close the checkin session", Permission: PERM_RUN_SESSIONS, Async: true, Run: RunCloseCommand},
+    {Name: "remind", Usage: "remind", Description: "remind everyone who hasn't checked in yet", Permission: PERM_RUN_SESSIONS, Async: true, Run: RunRemindCommand},
+    {Name: "status", Usage: "status", Description: "show the state of the current checkin session", Permission: PERM_CHECKIN, Run: RunStatusCommand},
+    {Name: "skip", Usage: "skip", Description: "skip the current checkin session", Permission: PERM_CHECKIN, Run: RunSkipCommand},
+    {Name: "ooo", Usage: "ooo [from] [to] | ooo clear", Description: "set yourself out of office (YYYY-MM-DD, inclusive) so you aren't asked to check in", Permission: PERM_CHECKIN, Run: RunOutOfOfficeCommand},
+    {Name: "report", Usage: "report [from] [to]", Description: "show participation stats (YYYY-MM-DD)", Permission: PERM_VIEW_REPORTS, Run: func(cfg *Config, req SlashRequest, args []string) string {
+      return RunReportCommand(cfg, args)
+    }},
+    {Name: "digest", Usage: "digest [@user]", Description: "preview this week's digest", Permission: PERM_VIEW_REPORTS, Run: func(cfg *Config, req SlashRequest, args []string) string {
+      return RunDigestCommand(cfg, args)
+    }},
+    {Name: "blockers", Usage: "blockers [resolve <id>]", Description: "list or resolve open blockers", Run: func(cfg *Config, req SlashRequest, args []string) string {
+      return RunBlockersCommand(cfg, req.UserId, args)
+    }},
+    {Name: "followups", Usage: "followups [done <id>]", Description: "list or close your followups", Permission: PERM_CHECKIN, Run: func(cfg *Config, req SlashRequest, args []string) string {
+      return RunFollowupsCommand(cfg, req.UserId, args)
+    }},
+    {Name: "config", Usage: "config", Description: "show the bot configuration", Permission: PERM_VIEW_CONFIG, Run: RunConfigCommand},
+    {Name: "roles", Usage: "roles", Description: "list the roles in this standup", Permission: PERM_VIEW_CONFIG, Run: RunRolesCommand},
+    {Name: "role", Usage: "role @user <owner|admin|participant|viewer>", Description: "set someone's role", Permission: PERM_MANAGE_ROLES, Run: RunRoleCommand},
+    {Name: "audit", Usage: "audit [before]", Description: "show the audit log of administrative actions", Permission: PERM_VIEW_AUDIT, Run: RunAuditCommand},
+    {Name: "deliveries", Usage: "deliveries", Description: "show the checkin DMs of the latest session that weren't delivered", Permission: PERM_RUN_SESSIONS, Run: RunDeliveriesCommand},
+    {Name: "queue", Usage: "queue [retry]", Description: "show the job queue, or retry the dead lettered jobs", Permission: PERM_VIEW_AUDIT, Run: RunQueueCommand},
+    {Name: "help", Usage: "help", Description: "show this message", Run: RunHelpCommand},
+  }
+}
+
+// parses a slash command request from the url encoded form body
+// a response url that isn't Slack's is rejected, as the reply to the command is posted to it
+func ParseSlashRequest(r *http.Request) (SlashRequest, error) {
+  if err := r.ParseForm(); err != nil {
+    return SlashRequest{}, fmt.Errorf("invalid slash command: %s", err)
+  }
+  req := SlashRequest{
+    UserId: r.PostForm.Get("user_id"),
+    UserName: r.PostForm.Get("user_name"),
+    ChannelId: r.PostForm.Get("channel_id"),
+    Command: r.PostForm.Get("command"),
+    Text: strings.TrimSpace(r.PostForm.Get("text")),
+    ResponseUrl: r.PostForm.Get("response_url"),
+  }
+  if req.ResponseUrl != "" && !IsSlackResponseUrl(req.ResponseUrl) {
+    return SlashRequest{}, fmt.Errorf("invalid slash command: response url %q is not a Slack url", req.ResponseUrl)
+  }
+  return req, nil
+}
+
+// determines if the url is one Slack sends to reply to slash commands, on https://hooks.slack.com/
+func IsSlackResponseUrl(responseUrl string) bool {
+  parsed, err := url.Parse(responseUrl)
+  return err == nil && strings.HasPrefix(responseUrl, SLACK_RESPONSE_URL_PREFIX) &&
+    parsed.Scheme == "https" && parsed.Host == "hooks.slack.com" && parsed.User == nil
+}
+
+// splits slash command text into a lowercased subcommand name and its args
+// an empty text is treated as the help subcommand
+func ParseSubcommand(text string) (name string, args []string) {
+  fields := SplitArgs(text)
+  if len(fields) == 0 {
+    return "help", nil
+  }
+  return strings.ToLower(fields[0]), fields[1:]
+}
+
+// splits slash command text on whitespace
+// words in double quotes, straight or curly as Slack sends them, are kept together as one arg without the quotes
+func SplitArgs(text string) []string {
+  var args []string
+  var arg strings.Builder
+  inArg, inQuotes := false, false
+  for _, r := range text {
+    switch {
+    case r == '"' || r == '\u201c' || r == '\u201d':
+      inArg, inQuotes = true, !inQuotes
+    case unicode.IsSpace(r) && !inQuotes:
+      if inArg {
+        args = append(args, arg.String())
+        arg.Reset()
+        inArg = false
+      }
+    default:
+      arg.WriteRune(r)
+      inArg = true
+    }
+  }
+  if inArg {
+    args = append(args, arg.String())
+  }
+  return args
+}
+
+// gets the subcommand with the given name
+func FindSubcommand(name string) (Subcommand, bool) {
+  for _, subcommand := range SUBCOMMANDS {
+    if subcommand.Name == name {
+      return subcommand, true
+    }
+  }
+  return Subcommand{}, false
+}
+
+// responds to a slash command with a message only visible to the user who sent it
+func RespondEphemeral(w http.ResponseWriter, text string) {
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(SlashResponse{ResponseType: "ephemeral", Text: text})
+}
+
+// determines if the given user is allowed to run the subcommand
+func CanRunSubcommand(cfg *Config, userId string, subcommand Subcommand) bool {
+  if subcommand.Permission == "" {
+    return GetRole(cfg, userId) != ""
+  }
+  return HasPermission(cfg, userId, subcommand.Permission)
+}
+
+// runs the subcommand in the text of a slash command request and returns the response text
+// async subcommands are queued instead, and reply through the response url when done
+func RunSlashCommand(cfg *Config, req SlashRequest) string {
+  return runSubcommand(cfg, req, true)
+}
+
+func runSubcommand(cfg *Config, req SlashRequest, queue bool) string {
+  name, args := ParseSubcommand(req.Text)
+  subcommand, ok := FindSubcommand(name)
+  if !ok {
+    return fmt.Sprintf("Unknown command `%s`, try `%s help`", name, req.Command)
+  }
+  if !CanRunSubcommand(cfg, req.UserId, subcommand) {
+    return fmt.Sprintf("You don't have permission to run `%s`", name)
+  }
+  if queue && subcommand.Async && req.ResponseUrl != "" {
+    err := Enqueue(cfg, JOB_SLASH_COMMAND, req)
+    if err == nil {
+      return fmt.Sprintf("Working on `%s`, I'll let you know when it's done", name)
+    }
+    cfg.Log().Error("Error queueing slash command, running it now", "err", err)
+  }
+  return subcommand.Run(cfg, req, args)
+}
+
+// runs a slash command queued by RunSlashCommand
+// the response is queued separately, so failing to send it does not run the command again
+func RunSlashCommandJob(cfg *Config, payload []byte) error {
+  var req SlashRequest
+  if err := json.Unmarshal(payload, &req); err != nil {
+    return err
+  }
+  text := runSubcommand(cfg, req, false)
+  if err := Enqueue(cfg, JOB_SLASH_RESPONSE, SlashReply{ResponseUrl: req.ResponseUrl, Text: text}); err != nil {
+    cfg.Log().Error("Error queueing slash command response", "err", err)
+  }
+  return nil
+}
+
+// sends the response of a queued slash command to its response url, only visible to the user who ran it
+func RunSlashResponseJob(cfg *Config, payload []byte) error {
+  var reply SlashReply
+  if err := json.Unmarshal(payload, &reply); err != nil {
+    return err
+  }
+  if !IsSlackResponseUrl(reply.ResponseUrl) {
+    return fmt.Errorf("response url %q is not a Slack url", reply.ResponseUrl)
+  }
+  body, err := json.Marshal(SlashResponse{ResponseType: "ephemeral", Text: reply.Text})
+  if err != nil {
+    return err
+  }
+  req, err := http.NewRequestWithContext(cfg.Context(), "POST", reply.ResponseUrl, bytes.NewReader(body))
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  client := http.Client{Timeout: SLACK_REQUEST_TIMEOUT}
+  res, err := client.Do(req)
+  if err != nil {
+    return err
+  }
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusOK {
+    return fmt.Errorf("response url returned %s", res.Status)
+  }
+  return nil
+}
+
+// the handler for the /standup endpoint
+// routes the first word of the command text to the matching subcommand
+func StandupCommandHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if req.Command == "" {
+    req.Command = "/standup"
+  }
+  cfg.Log().Info("Slash command", "command", req.Command, "text", req.Text, "user", req.UserId)
+  RespondEphemeral(w, RunSlashCommand(cfg, req))
+}
+
+// the response when the standup could not be locked to open, close or remind a session
+const STANDUP_BUSY_MESSAGE = "The standup is busy with another change, please try again in a minute."
+
+// opens a checkin session
+func RunOpenCommand(cfg *Config, req SlashRequest, args []string) string {
+  opened, err := OpenCheckin(cfg)
+  if err != nil {
+    cfg.Log().Error("Error opening checkin", "err", err)
+    return STANDUP_BUSY_MESSAGE
+  }
+  if !opened {
+    return "A checkin session is already open."
+  }
+  RecordAudit(cfg, req.UserId, "open", SOURCE_SLASH, "")
+  return fmt.Sprintf("Checkin Sent%s%s", undeliveredNote(cfg), cfg.CustomAdminAppendix)
+}
+
+// closes the checkin session
+func RunCloseCommand(cfg *Config, req SlashRequest, args []string) string {
+  closed, err := CloseCheckin(cfg)
+  if err != nil {
+    cfg.Log().Error("Error closing checkin", "err", err)
+    return STANDUP_BUSY_MESSAGE
+  }
+  if !closed {
+    return "There is currently no open checkin session."
+  }
+  RecordAudit(cfg, req.UserId, "close", SOURCE_SLASH, "")
+  return fmt.Sprintf("Checkin Closed%s", cfg.CustomAdminAppendix)
+}
+
+// reminds the users who have not completed the open checkin session
+func RunRemindCommand(cfg *Config, req SlashRequest, args []string) string {
+  reminded, err := RemindCheckin(cfg)
+  if err != nil {
+    cfg.Log().Error("Error reminding checkin", "err", err)
+    return STANDUP_BUSY_MESSAGE
+  }
+  if !reminded {
+    return "There is currently no open checkin session, try again later ;)"
+  }
+  RecordAudit(cfg, req.UserId, "remind", SOURCE_SLASH, "")
+  return fmt.Sprintf("Users have been notified%s%s", undeliveredNote(cfg), cfg.CustomAdminAppendix)
+}
+
+// tells how many DMs of the open session could not be delivered, or nothing if they all were
+func undeliveredNote(cfg *Config) string {
+  deliveries, err := GetDeliveries(cfg, GetCurrentSessionId(cfg))
+  if err != nil {
+    cfg.Log().Error("Error getting deliveries", "err", err)
+    return ""
+  }
+  undelivered := CountUndelivered(deliveries)
+  if undelivered == 0 {
+    return ""
+  }
+  return fmt.Sprintf(" (%d DMs weren't delivered, see `/standup deliveries`)", undelivered)
+}
+
+// shows whether a session is open and whether the user has checked in,
+// and to admins who is still awaited
+func RunStatusCommand(cfg *Config, req SlashRequest, args []string) string {
+  if GetThreadId() == "" {
+    return "There is currently no open checkin session."
+  }
+  awaiting := GetUsers(cfg, "", false)
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "A checkin session is open in <#%s>.", cfg.Standup.ChannelId)
+
+  stillAwaited := false
+  for _, userId := range awaiting {
+    stillAwaited = stillAwaited || userId == req.UserId
+  }
+  if stillAwaited {
+    builder.WriteString(" You haven't checked in yet, DM me your checkin or use `skip`.")
+  } else {
+    builder.WriteString(" You're all set for this one.")
+  }
+
+  if HasPermission(cfg, req.UserId, PERM_VIEW_REPORTS) {
+    names := FlattenList(MapIdsToNames(cfg, awaiting))
+    if names == "" {
+      builder.WriteString("\nEveryone has checked in.")
+    } else {
+      fmt.Fprintf(&builder, "\nStill waiting on: %s", names)
+    }
+  }
+  return builder.String()
+}
+
+// skips the open checkin session for the user
+func RunSkipCommand(cfg *Config, req SlashRequest, args []string) string {
+  if GetThreadId() == "" {
+    return "There is currently no open checkin session."
+  }
+  if !SkipSession(cfg, req.UserId) {
+    return "You've already checked in or skipped this session."
+  }
+  return "You've skipped this checkin session, see you next time!"
+}
+
+// shows, sets or clears the out of office period of the user
+// with no dates the user is out of office today, and with one date only on that day
+func RunOutOfOfficeCommand(cfg *Config, req SlashRequest, args []string) string {
+  if len(args) == 1 && strings.ToLower(args[0]) == "clear" {
+    if err := ClearOutOfOffice(cfg, req.UserId); err != nil {
+      cfg.Log().Error("Error clearing out of office", "err", err)
+      return "Could not clear your out of office, try again later"
+    }
+    return "Welcome back! You'll be asked to check in again."
+  }
+  if len(args) > 2 {
+    return "Usage: `ooo [from] [to]` with dates as YYYY-MM-DD, or `ooo clear`"
+  }
+
+  startsOn, endsOn, err := ParseOutOfOfficeDates(args, time.Now().In(GetLocation()))
+  if err != nil {
+    return err.Error()
+  }
+  if err = SetOutOfOffice(cfg, req.UserId, startsOn, endsOn); err != nil {
+    return err.Error()
+  }
+  return fmt.Sprintf("You're out of office from %s to %s, you won't be asked to check in.",
+    startsOn.Format("Jan 2"), endsOn.Format("Jan 2"))
+}
+
+// parses the dates of the ooo subcommand in the location of now
+// with no dates the period is the day of now, and with one date only that day
+func ParseOutOfOfficeDates(args []string, now time.Time) (startsOn, endsOn time.Time, err error) {
+  loc := now.Location()
+  startsOn = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+  if len(args) > 0 {
+    if startsOn, err = time.ParseInLocation(DATE_FORMAT, args[0], loc); err != nil {
+      return startsOn, endsOn, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", args[0])
+    }
+  }
+  endsOn = startsOn
+  if len(args) > 1 {
+    if endsOn, err = time.ParseInLocation(DATE_FORMAT, args[1], loc); err != nil {
+      return startsOn, endsOn, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", args[1])
+    }
+  }
+  return startsOn, endsOn, nil
+}
+
+// shows the configuration of the bot, leaving out secrets
+func RunConfigCommand(cfg *Config, req SlashRequest, args []string) string {
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "Standup: %s\n", cfg.Standup.Name)
+  fmt.Fprintf(&builder, "Main channel: %s (%s)\n", cfg.Standup.ChannelName, cfg.Standup.ChannelId)
+  fmt.Fprintf(&builder, "Open/close/remind mentions: `%s` / `%s` / `%s`\n", cfg.Standup.OpenMention, cfg.Standup.CloseMention, cfg.Standup.RemindMention)
+  fmt.Fprintf(&builder, "Reminder triggers allowed: %t\n", cfg.AllowReminderTriggers)
+  if cfg.Digest.Schedule != "" {
+    fmt.Fprintf(&builder, "Weekly digest: %s to %s\n", cfg.Digest.Schedule, cfg.Digest.Target)
+  } else {
+    builder.WriteString("Weekly digest: not scheduled\n")
+  }
+  fmt.Fprintf(&builder, "Blocker keywords: %s\n", strings.Join(cfg.BlockerDetector().Keywords, ", "))
+  if cfg.BlockerDetector().Prefix != "" {
+    fmt.Fprintf(&builder, "Blockers question prefix: `%s`\n", cfg.BlockerDetector().Prefix)
+  }
+  return builder.String()
+}
+
+// shows the number of jobs in the queue by status, or queues the dead lettered jobs again
+func RunQueueCommand(cfg *Config, req SlashRequest, args []string) string {
+  if len(args) == 1 && strings.ToLower(args[0]) == "retry" {
+    if !HasPermission(cfg, req.UserId, PERM_MANAGE_STANDUP) {
+      return "You don't have permission to retry jobs"
+    }
+    retried := RetryDeadJobs()
+    RecordAudit(cfg, req.UserId, "retry_jobs", SOURCE_SLASH, fmt.Sprintf("%d jobs", retried))
+    return fmt.Sprintf("Queued %d dead lettered jobs to run again", retried)
+  }
+  if len(args) != 0 {
+    return "Usage: `queue [retry]`"
+  }
+  counts := GetQueueCounts()
+  return fmt.Sprintf("Jobs pending: %d, running: %d, done: %d, dead lettered: %d",
+    counts[JOB_PENDING], counts[JOB_RUNNING], counts[JOB_DONE], counts[JOB_DEAD])
+}
+
+// lists the subcommands the user can run
+func RunHelpCommand(cfg *Config, req SlashRequest, args []string) string {
+  command := req.Command
+  if command == "" {
+    command = "/standup"
+  }
+  builder := strings.Builder{}
+  builder.WriteString("Available commands:")
+  for _, subcommand := range SUBCOMMANDS {
+    if !CanRunSubcommand(cfg, req.UserId, subcommand) {
+      continue
+    }
+    fmt.Fprintf(&builder, "\n`%s %s` - %s", command, subcommand.Usage, subcommand.Description)
+  }
+  return builder.String()
+}
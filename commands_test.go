@@ -0,0 +1,202 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestParseSlashRequest(t *testing.T) {
+  tests := []struct {
+    name string
+    body string
+    want SlashRequest
+  }{
+    {
+      name: "plain",
+      body: "user_id=U1&user_name=ana&channel_id=C1&command=%2Fstandup&text=status&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1%2F1%2Fabc",
+      want: SlashRequest{UserId: "U1", UserName: "ana", ChannelId: "C1", Command: "/standup", Text: "status",
+        ResponseUrl: "https://hooks.slack.com/commands/T1/1/abc"},
+    },
+    {
+      name: "plus and percent encoded spaces",
+      body: "user_id=U1&command=%2Fstandup&text=ooo+2020-03-02%202020-03-04",
+      want: SlashRequest{UserId: "U1", Command: "/standup", Text: "ooo 2020-03-02 2020-03-04"},
+    },
+    {
+      name: "encoded mention and unicode",
+      body: "user_id=U1&user_name=j%C3%BCrgen&text=role+%3C%40U2%7Cbob%3E+admin",
+      want: SlashRequest{UserId: "U1", UserName: "jürgen", Text: "role <@U2|bob> admin"},
+    },
+    {
+      name: "surrounding whitespace trimmed",
+      body: "user_id=U1&text=%20%20%09remind%0A",
+      want: SlashRequest{UserId: "U1", Text: "remind"},
+    },
+    {
+      name: "empty text",
+      body: "user_id=U1&text=",
+      want: SlashRequest{UserId: "U1"},
+    },
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      r := httptest.NewRequest("POST", "/standup", strings.NewReader(test.body))
+      r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+      got, err := ParseSlashRequest(r)
+      if err != nil {
+        t.Fatalf("ParseSlashRequest() error = %v", err)
+      }
+      if got != test.want {
+        t.Errorf("ParseSlashRequest() = %+v, want %+v", got, test.want)
+      }
+    })
+  }
+}
+
+func TestParseSlashRequestRejectsResponseUrls(t *testing.T) {
+  for _, responseUrl := range []string{
+    "http://hooks.slack.com/commands/T1/1/abc",
+    "https://hooks.slack.com.example.com/commands/T1/1/abc",
+    "https://hooks.slack.com:8443/commands/T1/1/abc",
+    "https://hooks.slack.com@169.254.169.254/latest/meta-data",
+    "https://169.254.169.254/latest/meta-data",
+    "http://localhost:8080/api/status",
+    "file:///etc/passwd",
+    "hooks.slack.com/commands/T1/1/abc",
+  } {
+    body := "user_id=U1&text=open&response_url=" + url.QueryEscape(responseUrl)
+    r := httptest.NewRequest("POST", "/standup", strings.NewReader(body))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    if req, err := ParseSlashRequest(r); err == nil {
+      t.Errorf("ParseSlashRequest() with response url %q = %+v, want an error", responseUrl, req)
+    }
+  }
+}
+
+func TestSlashResponseJobRefusesOtherUrls(t *testing.T) {
+  hit := false
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    hit = true
+  }))
+  defer server.Close()
+  payload, _ := json.Marshal(SlashReply{ResponseUrl: server.URL + "/internal", Text: "done"})
+  if err := RunSlashResponseJob(&Config{}, payload); err == nil {
+    t.Errorf("RunSlashResponseJob() to %s = nil, want an error", server.URL)
+  }
+  if hit {
+    t.Errorf("RunSlashResponseJob() posted to a url that isn't Slack's")
+  }
+}
+
+func TestParseSubcommand(t *testing.T) {
+  tests := []struct {
+    text string
+    name string
+    args []string
+  }{
+    {"", "help", nil},
+    {"   ", "help", nil},
+    {"help", "help", nil},
+    {"HELP", "help", nil},
+    {"status", "status", nil},
+    {"Open", "open", nil},
+    {"  report \t 2020-03-01   2020-03-31 ", "report", []string{"2020-03-01", "2020-03-31"}},
+    {"role <@U2|bob>\nadmin", "role", []string{"<@U2|bob>", "admin"}},
+    {`blockers resolve "12"`, "blockers", []string{"resolve", "12"}},
+    {`ooo "2020-03-02" 2020-03-04`, "ooo", []string{"2020-03-02", "2020-03-04"}},
+    {`role "<@U2|bob smith>" admin`, "role", []string{"<@U2|bob smith>", "admin"}},
+    {"role “<@U2|bob smith>” admin", "role", []string{"<@U2|bob smith>", "admin"}},
+    {`"open"`, "open", nil},
+    {`audit ""`, "audit", []string{""}},
+    {`role "<@U2|bob smith> admin`, "role", []string{"<@U2|bob smith> admin"}},
+  }
+  for _, test := range tests {
+    name, args := ParseSubcommand(test.text)
+    if name != test.name || len(args) != len(test.args) || len(args) > 0 && !reflect.DeepEqual(args, test.args) {
+      t.Errorf("ParseSubcommand(%q) = %q, %q, want %q, %q", test.text, name, args, test.name, test.args)
+    }
+  }
+}
+
+func TestFindSubcommand(t *testing.T) {
+  for _, name := range []string{"open", "close", "remind", "status", "skip", "ooo", "report", "config", "help"} {
+    if subcommand, ok := FindSubcommand(name); !ok || subcommand.Name != name {
+      t.Errorf("FindSubcommand(%q) = %q, %t, want the %q subcommand", name, subcommand.Name, ok, name)
+    }
+  }
+  for _, name := range []string{"", "Open", "opn", "standup", "/standup"} {
+    if _, ok := FindSubcommand(name); ok {
+      t.Errorf("FindSubcommand(%q) found a subcommand, want none", name)
+    }
+  }
+}
+
+func TestRunSlashCommandUnknown(t *testing.T) {
+  tests := []struct {
+    req SlashRequest
+    want string
+  }{
+    {SlashRequest{UserId: "U1", Command: "/standup", Text: "frobnicate now"}, "Unknown command `frobnicate`, try `/standup help`"},
+    {SlashRequest{UserId: "U1", Command: "/checkin", Text: "  OPN "}, "Unknown command `opn`, try `/checkin help`"},
+  }
+  for _, test := range tests {
+    if got := RunSlashCommand(&Config{}, test.req); got != test.want {
+      t.Errorf("RunSlashCommand(%q) = %q, want %q", test.req.Text, got, test.want)
+    }
+  }
+}
+
+func TestParseOutOfOfficeDates(t *testing.T) {
+  loc, err := time.LoadLocation("America/New_York")
+  if err != nil {
+    loc = time.FixedZone("EST", -5*60*60)
+  }
+  now := time.Date(2020, 3, 5, 23, 30, 0, 0, loc)
+  day := func(month time.Month, d int) time.Time {
+    return time.Date(2020, month, d, 0, 0, 0, 0, loc)
+  }
+  tests := []struct {
+    args []string
+    startsOn time.Time
+    endsOn time.Time
+    err string
+  }{
+    {nil, day(3, 5), day(3, 5), ""},
+    {[]string{"2020-03-09"}, day(3, 9), day(3, 9), ""},
+    {[]string{"2020-03-09", "2020-03-13"}, day(3, 9), day(3, 13), ""},
+    {[]string{"2020-02-28", "2020-03-02"}, day(2, 28), day(3, 2), ""},
+    {[]string{"03/09/2020"}, time.Time{}, time.Time{}, `invalid date "03/09/2020", expected YYYY-MM-DD`},
+    {[]string{"2020-03-09", "friday"}, time.Time{}, time.Time{}, `invalid date "friday", expected YYYY-MM-DD`},
+    {[]string{"2020-02-30"}, time.Time{}, time.Time{}, `invalid date "2020-02-30", expected YYYY-MM-DD`},
+  }
+  for _, test := range tests {
+    startsOn, endsOn, err := ParseOutOfOfficeDates(test.args, now)
+    if test.err != "" {
+      if err == nil || err.Error() != test.err {
+        t.Errorf("ParseOutOfOfficeDates(%q) error = %v, want %q", test.args, err, test.err)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("ParseOutOfOfficeDates(%q) error = %v", test.args, err)
+      continue
+    }
+    if !startsOn.Equal(test.startsOn) || !endsOn.Equal(test.endsOn) || startsOn.Location() != loc {
+      t.Errorf("ParseOutOfOfficeDates(%q) = %s, %s, want %s, %s", test.args, startsOn, endsOn, test.startsOn, test.endsOn)
+    }
+  }
+}
+
+func TestOutOfOfficeUsage(t *testing.T) {
+  req := SlashRequest{UserId: "U1", Command: "/standup"}
+  want := "Usage: `ooo [from] [to]` with dates as YYYY-MM-DD, or `ooo clear`"
+  if got := RunOutOfOfficeCommand(&Config{}, req, []string{"2020-03-02", "2020-03-03", "2020-03-04"}); got != want {
+    t.Errorf("RunOutOfOfficeCommand() = %q, want %q", got, want)
+  }
+}
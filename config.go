@@ -0,0 +1,335 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v2"
+)
+
+const DEFAULT_CHECKIN_PROMPT = "Hey! It's time for your checkin. Let me know what you're gonna do, how long you think it will take, and when you plan on working on this -- *in one message please*. Thanks :)"
+const DEFAULT_CHECKIN_REMINDER = "Don't forget to complete the checkin session!"
+
+// type to contain the configuration of the bot
+// loaded from an optional YAML or TOML file, then overridden by environment variables
+type Config struct {
+  Port string `yaml:"port" toml:"port"`
+  Environment string `yaml:"environment" toml:"environment"`
+  ApiToken string `yaml:"api_token" toml:"api_token"`
+  ApiAuthToken string `yaml:"api_auth_token" toml:"api_auth_token"`
+  AppToken string `yaml:"app_token" toml:"app_token"`
+  SigningSecret string `yaml:"signing_secret" toml:"signing_secret"`
+  SocketMode bool `yaml:"socket_mode" toml:"socket_mode"`
+  DatabaseUrl string `yaml:"database_url" toml:"database_url"`
+  AdminUsers []string `yaml:"admin_users" toml:"admin_users"`
+  AllowReminderTriggers bool `yaml:"allow_reminder_triggers" toml:"allow_reminder_triggers"`
+  CustomAdminAppendix string `yaml:"custom_admin_appendix" toml:"custom_admin_appendix"`
+  Standup StandupConfig `yaml:"standup" toml:"standup"`
+  Digest DigestConfig `yaml:"digest" toml:"digest"`
+  Blockers BlockerConfig `yaml:"blockers" toml:"blockers"`
+  Logging LogConfig `yaml:"logging" toml:"logging"`
+  Tracing TraceConfig `yaml:"tracing" toml:"tracing"`
+
+  detector *BlockerDetector
+  requestId string
+  logger *Logger
+  spanContext SpanContext
+  ctx context.Context
+}
+
+// type to contain the configuration of the standup
+type StandupConfig struct {
+  Name string `yaml:"name" toml:"name"`
+  ChannelName string `yaml:"channel_name" toml:"channel_name"`
+  ChannelId string `yaml:"channel_id" toml:"channel_id"`
+  OpenMention string `yaml:"open_mention" toml:"open_mention"`
+  CloseMention string `yaml:"close_mention" toml:"close_mention"`
+  RemindMention string `yaml:"remind_mention" toml:"remind_mention"`
+  Prompt string `yaml:"prompt" toml:"prompt"`
+  Reminder string `yaml:"reminder" toml:"reminder"`
+  PromptNewMembers bool `yaml:"prompt_new_members" toml:"prompt_new_members"`
+}
+
+// type to contain the configuration of the weekly digest
+type DigestConfig struct {
+  Schedule string `yaml:"schedule" toml:"schedule"`
+  Target string `yaml:"target" toml:"target"`
+  Managers []string `yaml:"managers" toml:"managers"`
+}
+
+// type to contain the configuration of blocker detection and escalation
+type BlockerConfig struct {
+  Prefix string `yaml:"prefix" toml:"prefix"`
+  Keywords []string `yaml:"keywords" toml:"keywords"`
+  Pattern string `yaml:"pattern" toml:"pattern"`
+  ChannelId string `yaml:"channel_id" toml:"channel_id"`
+  Owner string `yaml:"owner" toml:"owner"`
+}
+
+// type to contain the configuration of logging
+type LogConfig struct {
+  Level string `yaml:"level" toml:"level"`
+  Format string `yaml:"format" toml:"format"`
+  RedactText bool `yaml:"redact_text" toml:"redact_text"`
+}
+
+// type to contain the configuration of tracing
+type TraceConfig struct {
+  Endpoint string `yaml:"endpoint" toml:"endpoint"`
+  ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+// type to contain every problem found when validating a config
+type ConfigError struct {
+  Problems []string
+}
+
+func (e *ConfigError) Error() string {
+  return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// loads the config from the given YAML (.yaml, .yml) or TOML (.toml) file, if any,
+// then applies the environment variable overrides
+// the config is not validated
+func LoadConfig(path string) (*Config, error) {
+  cfg := &Config{}
+  if path != "" {
+    if err := cfg.loadFile(path); err != nil {
+      return nil, err
+    }
+  }
+  cfg.applyEnv()
+  return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return fmt.Errorf("could not read config file: %s", err)
+  }
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".yaml", ".yml":
+    err = yaml.UnmarshalStrict(data, cfg)
+  case ".toml":
+    var meta toml.MetaData
+    meta, err = toml.Decode(string(data), cfg)
+    if err == nil && len(meta.Undecoded()) > 0 {
+      err = fmt.Errorf("unknown keys %v", meta.Undecoded())
+    }
+  default:
+    return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+  }
+  if err != nil {
+    return fmt.Errorf("could not parse config file %s: %s", path, err)
+  }
+  return nil
+}
+
+// overrides the string with the environment variable, if it is set
+func envString(target *string, name string) {
+  if value, ok := os.LookupEnv(name); ok && value != "" {
+    *target = value
+  }
+}
+
+// overrides the list with the comma separated environment variable, if it is set
+func envList(target *[]string, name string) {
+  if value, ok := os.LookupEnv(name); ok && value != "" {
+    *target = SplitList(value)
+  }
+}
+
+// splits a comma separated list, dropping empty items
+func SplitList(value string) (items []string) {
+  for _, item := range strings.Split(value, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      items = append(items, item)
+    }
+  }
+  return items
+}
+
+func (cfg *Config) applyEnv() {
+  envString(&cfg.Port, "PORT")
+  envString(&cfg.Environment, "ENVIRONMENT")
+  envString(&cfg.ApiToken, "API_TOKEN")
+  envString(&cfg.ApiAuthToken, "API_AUTH_TOKEN")
+  envString(&cfg.AppToken, "APP_TOKEN")
+  envString(&cfg.SigningSecret, "SIGNING_SECRET")
+  if value := os.Getenv("SOCKET_MODE"); value != "" {
+    cfg.SocketMode = value == "true"
+  }
+  envString(&cfg.DatabaseUrl, "DATABASE_URL")
+  envList(&cfg.AdminUsers, "ADMIN_USERS")
+  if value := os.Getenv("ALLOW_REMINDER_TRIGGERS"); value != "" {
+    cfg.AllowReminderTriggers = value == "true"
+  }
+  envString(&cfg.CustomAdminAppendix, "CUSTOM_ADMIN_APPENDIX")
+
+  envString(&cfg.Standup.Name, "STANDUP_NAME")
+  envString(&cfg.Standup.ChannelName, "MAIN_CHANNEL_NAME")
+  envString(&cfg.Standup.ChannelId, "MAIN_CHANNEL_ID")
+  envString(&cfg.Standup.OpenMention, "OPEN_CHECKIN_STR")
+  envString(&cfg.Standup.CloseMention, "CLOSE_CHECKIN_STR")
+  envString(&cfg.Standup.RemindMention, "REMIND_CHECKIN_STR")
+  envString(&cfg.Standup.Prompt, "CHECKIN_PROMPT")
+  envString(&cfg.Standup.Reminder, "CHECKIN_REMINDER")
+  if value := os.Getenv("PROMPT_NEW_MEMBERS"); value != "" {
+    cfg.Standup.PromptNewMembers = value == "true"
+  }
+
+  envString(&cfg.Digest.Schedule, "DIGEST_SCHEDULE")
+  envString(&cfg.Digest.Target, "DIGEST_TARGET")
+  envList(&cfg.Digest.Managers, "DIGEST_MANAGERS")
+
+  envString(&cfg.Blockers.Prefix, "BLOCKER_PREFIX")
+  envList(&cfg.Blockers.Keywords, "BLOCKER_KEYWORDS")
+  envString(&cfg.Blockers.Pattern, "BLOCKER_PATTERN")
+  envString(&cfg.Blockers.ChannelId, "BLOCKER_CHANNEL_ID")
+  envString(&cfg.Blockers.Owner, "BLOCKER_OWNER")
+
+  envString(&cfg.Logging.Level, "LOG_LEVEL")
+  envString(&cfg.Logging.Format, "LOG_FORMAT")
+  if value := os.Getenv("LOG_REDACT_TEXT"); value != "" {
+    cfg.Logging.RedactText = value == "true"
+  }
+
+  envString(&cfg.Tracing.Endpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+  envString(&cfg.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+}
+
+// fills in defaults and checks the config, returning a ConfigError listing every problem found
+func (cfg *Config) Validate() error {
+  problems := make([]string, 0)
+
+  if cfg.Port == "" || cfg.Port == ":" {
+    problems = append(problems, "port (PORT) must be set")
+  }
+  if cfg.ApiToken == "" {
+    problems = append(problems, "api_token (API_TOKEN) must be set")
+  }
+  // requests to the Slack endpoints are rejected without it, which only Socket Mode can do without
+  if !cfg.SocketMode && cfg.SigningSecret == "" {
+    problems = append(problems, "signing_secret (SIGNING_SECRET) must be set unless socket_mode (SOCKET_MODE) is on")
+  }
+  if cfg.SocketMode && cfg.AppToken == "" {
+    problems = append(problems, "app_token (APP_TOKEN) must be set when socket_mode (SOCKET_MODE) is on")
+  }
+  if cfg.Standup.ChannelName == "" && cfg.Standup.ChannelId == "" {
+    problems = append(problems, "standup.channel_name (MAIN_CHANNEL_NAME) or standup.channel_id (MAIN_CHANNEL_ID) must be set")
+  }
+  if cfg.Standup.Name == "" {
+    cfg.Standup.Name = cfg.Standup.ChannelName
+  }
+  if cfg.Standup.Name == "" {
+    problems = append(problems, "standup.name (STANDUP_NAME) must be set when only the channel id is given")
+  }
+
+  if cfg.Standup.Prompt == "" {
+    cfg.Standup.Prompt = DEFAULT_CHECKIN_PROMPT
+  }
+  if cfg.Standup.Reminder == "" {
+    cfg.Standup.Reminder = DEFAULT_CHECKIN_REMINDER
+  }
+
+  mentions := []struct {
+    name string
+    mention string
+  }{
+    {"open_mention (OPEN_CHECKIN_STR)", cfg.Standup.OpenMention},
+    {"close_mention (CLOSE_CHECKIN_STR)", cfg.Standup.CloseMention},
+    {"remind_mention (REMIND_CHECKIN_STR)", cfg.Standup.RemindMention},
+  }
+  seen := make(map[string]string)
+  for _, m := range mentions {
+    if m.mention == "" {
+      // an empty mention would match every message that mentions the bot
+      problems = append(problems, fmt.Sprintf("standup.%s must be set", m.name))
+      continue
+    }
+    if other, ok := seen[m.mention]; ok {
+      problems = append(problems, fmt.Sprintf("standup.%s and standup.%s are the same, so a mention could not tell them apart", other, m.name))
+    }
+    seen[m.mention] = m.name
+  }
+
+  if cfg.Digest.Target == "" {
+    cfg.Digest.Target = DIGEST_TARGET_CHANNEL
+  }
+  if cfg.Digest.Target != DIGEST_TARGET_CHANNEL && cfg.Digest.Target != DIGEST_TARGET_MANAGERS {
+    problems = append(problems, fmt.Sprintf("digest.target (DIGEST_TARGET) must be %q or %q, not %q",
+      DIGEST_TARGET_CHANNEL, DIGEST_TARGET_MANAGERS, cfg.Digest.Target))
+  }
+  if cfg.Digest.Target == DIGEST_TARGET_MANAGERS && len(cfg.Digest.Managers) == 0 {
+    problems = append(problems, "digest.managers (DIGEST_MANAGERS) must be set when the digest is sent to managers")
+  }
+  if cfg.Digest.Schedule != "" {
+    if _, err := ParseWeeklySchedule(cfg.Digest.Schedule); err != nil {
+      problems = append(problems, fmt.Sprintf("digest.schedule (DIGEST_SCHEDULE): %s", err))
+    }
+  }
+
+  if cfg.Logging.Level == "" {
+    cfg.Logging.Level = "info"
+  }
+  if _, ok := LEVEL_NAMES[strings.ToLower(cfg.Logging.Level)]; !ok {
+    problems = append(problems, fmt.Sprintf("logging.level (LOG_LEVEL) must be debug, info, warn or error, not %q", cfg.Logging.Level))
+  }
+  if cfg.Logging.Format == "" {
+    cfg.Logging.Format = LOG_FORMAT_JSON
+  }
+  if cfg.Logging.Format != LOG_FORMAT_JSON && cfg.Logging.Format != LOG_FORMAT_TEXT {
+    problems = append(problems, fmt.Sprintf("logging.format (LOG_FORMAT) must be %q or %q, not %q",
+      LOG_FORMAT_JSON, LOG_FORMAT_TEXT, cfg.Logging.Format))
+  }
+
+  if cfg.Tracing.ServiceName == "" {
+    cfg.Tracing.ServiceName = TRACE_SERVICE_NAME
+  }
+  if cfg.Tracing.Endpoint != "" && !strings.HasPrefix(cfg.Tracing.Endpoint, "http://") && !strings.HasPrefix(cfg.Tracing.Endpoint, "https://") {
+    problems = append(problems, fmt.Sprintf("tracing.endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) must be an http or https url, not %q", cfg.Tracing.Endpoint))
+  }
+
+  detector, err := NewBlockerDetector(cfg.Blockers)
+  if err != nil {
+    problems = append(problems, fmt.Sprintf("blockers.pattern (BLOCKER_PATTERN): %s", err))
+  }
+  cfg.detector = detector
+
+  if len(problems) > 0 {
+    return &ConfigError{Problems: problems}
+  }
+  return nil
+}
+
+// gets the address to listen on
+// outside of development, the port is given without the leading ':'
+func (cfg *Config) ListenAddr() string {
+  if cfg.Environment == "development" || strings.HasPrefix(cfg.Port, ":") {
+    return cfg.Port
+  }
+  return fmt.Sprintf(":%s", cfg.Port)
+}
+
+// gets the blocker detector built from the config when it was validated
+func (cfg *Config) BlockerDetector() *BlockerDetector {
+  if cfg.detector == nil {
+    cfg.detector, _ = NewBlockerDetector(cfg.Blockers)
+  }
+  return cfg.detector
+}
+
+// determines if the given user is listed in the config as an owner
+func (cfg *Config) IsConfiguredOwner(userId string) bool {
+  for _, id := range cfg.AdminUsers {
+    if userId == id {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+  "os"
+  "testing"
+)
+
+func TestAllowReminderTriggersDefault(t *testing.T) {
+  defer os.Setenv("ALLOW_REMINDER_TRIGGERS", os.Getenv("ALLOW_REMINDER_TRIGGERS"))
+  tests := []struct {
+    env string
+    want bool
+  }{
+    {"", false},
+    {"true", true},
+    {"false", false},
+  }
+  for _, test := range tests {
+    os.Setenv("ALLOW_REMINDER_TRIGGERS", test.env)
+    cfg, err := LoadConfig("")
+    if err != nil {
+      t.Fatalf("LoadConfig() error = %v", err)
+    }
+    if cfg.AllowReminderTriggers != test.want {
+      t.Errorf("ALLOW_REMINDER_TRIGGERS=%q: AllowReminderTriggers = %t, want %t", test.env, cfg.AllowReminderTriggers, test.want)
+    }
+  }
+}
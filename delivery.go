@@ -0,0 +1,406 @@
+package main
+
+import (
+  "database/sql"
+  "errors"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+const DELIVERY_PROMPT = "prompt"
+const DELIVERY_REMINDER = "reminder"
+
+const DELIVERY_SENT = "sent"
+const DELIVERY_FAILED = "failed"
+const DELIVERY_DEACTIVATED = "deactivated"
+
+// how many DMs are sent at the same time when opening or reminding a checkin
+const FANOUT_WORKERS = 8
+
+// how many times a DM is tried before it is recorded as failed, not counting tries Slack rate limited
+const DELIVERY_MAX_ATTEMPTS = 4
+
+// the delay before retrying a DM that failed before it was sent, doubled on every attempt after
+const DELIVERY_RETRY_DELAY = time.Second
+
+// the longest a DM waits on rate limits in total before it is recorded as failed, so one user can't hold up the fan-out
+var DELIVERY_MAX_RATE_LIMIT_WAIT = 2 * time.Minute
+
+// how often the progress of a fan-out is logged
+const FANOUT_PROGRESS_INTERVAL = 10 * time.Second
+
+// the Slack errors meaning the user cannot be DM'd anymore, which are not retried
+var DEACTIVATED_ERRORS = map[string]bool{
+  "user_not_found": true,
+  "user_disabled": true,
+  "user_not_visible": true,
+  "account_inactive": true,
+  "cannot_dm_bot": true,
+}
+
+// the Slack errors meaning a cached DM channel is no longer usable, so it is opened again
+var STALE_DM_ERRORS = map[string]bool{"channel_not_found": true, "is_archived": true}
+
+// type to contain the outcome of DMing a user
+type Delivery struct {
+  UserId string `json:"user_id"`
+  Kind string `json:"kind"`
+  Status string `json:"status"`
+  Error string `json:"error"`
+  Attempts int `json:"attempts"`
+  UpdatedAt time.Time `json:"updated_at"`
+}
+
+// type to pause every worker of a fan-out while Slack rate limits it
+type fanOutLimiter struct {
+  mtx sync.Mutex
+  until time.Time
+}
+
+var DM_CHANNELS_MTX = sync.Mutex{}
+var DM_CHANNELS = make(map[string]string)
+
+// creates the DM channel cache and delivery tables if they do not exist yet
+func DeliverySetup() {
+  stmts := []string{
+    `CREATE TABLE IF NOT EXISTS dm_channels (
+      user_id TEXT PRIMARY KEY,
+      channel_id TEXT NOT NULL,
+      opened_at TIMESTAMPTZ NOT NULL
+    );`,
+    `CREATE TABLE IF NOT EXISTS deliveries (
+      session_id INTEGER NOT NULL REFERENCES sessions(id),
+      user_id TEXT NOT NULL,
+      kind TEXT NOT NULL,
+      status TEXT NOT NULL,
+      error TEXT NOT NULL DEFAULT '',
+      attempts INTEGER NOT NULL,
+      updated_at TIMESTAMPTZ NOT NULL,
+      PRIMARY KEY (session_id, user_id, kind)
+    );`,
+  }
+  for _, stmt := range stmts {
+    if _, err := DB.Exec(stmt); err != nil {
+      LOG.Error("Error creating delivery tables", "err", err)
+      return
+    }
+  }
+}
+
+// gets the id of the DM channel with the given user, from memory, then the db, and only then conversations.open
+func GetDMChannel(cfg *Config, userId string) (string, error) {
+  DM_CHANNELS_MTX.Lock()
+  channelId, ok := DM_CHANNELS[userId]
+  DM_CHANNELS_MTX.Unlock()
+  if ok {
+    return channelId, nil
+  }
+
+  err := DB.QueryRowContext(cfg.Context(), "SELECT channel_id FROM dm_channels WHERE user_id = $1;", userId).Scan(&channelId)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting DM channel", "user", userId, "err", err)
+  }
+  if channelId == "" {
+    params := make(map[string]string)
+    params["users"] = userId
+    res, err := PerformPost(cfg, "conversations.open", nil, params, true)
+    body, err := HandleResponse(cfg, res, err, false)
+    if err != nil {
+      return "", err
+    }
+    if !body.Ok {
+      return "", &SlackApiError{Method: "conversations.open", Code: body.Error}
+    }
+    channelId = body.Channel["id"]
+    if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO dm_channels (user_id, channel_id, opened_at) VALUES ($1, $2, $3)
+      ON CONFLICT (user_id) DO UPDATE SET channel_id = $2, opened_at = $3;`, userId, channelId, time.Now()); err != nil {
+      cfg.Log().Error("Error saving DM channel", "user", userId, "err", err)
+    }
+  }
+
+  DM_CHANNELS_MTX.Lock()
+  DM_CHANNELS[userId] = channelId
+  DM_CHANNELS_MTX.Unlock()
+  return channelId, nil
+}
+
+// drops the cached DM channel with the given user, so it is opened again next time
+func ForgetDMChannel(cfg *Config, userId string) {
+  DM_CHANNELS_MTX.Lock()
+  delete(DM_CHANNELS, userId)
+  DM_CHANNELS_MTX.Unlock()
+  if _, err := DB.ExecContext(cfg.Context(), "DELETE FROM dm_channels WHERE user_id = $1;", userId); err != nil {
+    cfg.Log().Error("Error forgetting DM channel", "user", userId, "err", err)
+  }
+}
+
+// determines if the error means the user cannot be DM'd anymore
+func IsDeactivatedError(err error) bool {
+  var apiErr *SlackApiError
+  return errors.As(err, &apiErr) && DEACTIVATED_ERRORS[apiErr.Code]
+}
+
+// waits until Slack lifts the rate limit hit by any worker, returning how long it waited
+// returns an error without waiting if the context ended, or if the wait would be longer than maxWait
+func (l *fanOutLimiter) wait(cfg *Config, maxWait time.Duration) (time.Duration, error) {
+  l.mtx.Lock()
+  delay := time.Until(l.until)
+  l.mtx.Unlock()
+  if cfg.Context().Err() != nil {
+    return 0, fmt.Errorf("not sent before shutdown")
+  }
+  if delay <= 0 {
+    return 0, nil
+  }
+  if delay > maxWait {
+    return 0, fmt.Errorf("still rate limited after waiting %s", DELIVERY_MAX_RATE_LIMIT_WAIT-maxWait)
+  }
+  select {
+  case <-cfg.Context().Done():
+    return 0, fmt.Errorf("not sent before shutdown")
+  case <-time.After(delay):
+    return delay, nil
+  }
+}
+
+// pauses every worker for the time Slack asked to wait
+func (l *fanOutLimiter) pause(retryAfter time.Duration) {
+  l.mtx.Lock()
+  defer l.mtx.Unlock()
+  if until := time.Now().Add(retryAfter); until.After(l.until) {
+    l.until = until
+  }
+}
+
+// DMs the message to the user, retrying when rate limited or when it failed before the message was sent
+// rate limited calls are not posted by Slack, so they are retried without counting toward DELIVERY_MAX_ATTEMPTS,
+// until the DM has waited DELIVERY_MAX_RATE_LIMIT_WAIT on rate limits
+func deliver(cfg *Config, limiter *fanOutLimiter, kind, userId, message string) Delivery {
+  delivery := Delivery{UserId: userId, Kind: kind, Status: DELIVERY_FAILED}
+  var err error
+  var waited time.Duration
+  for failures := 0; failures < DELIVERY_MAX_ATTEMPTS; {
+    var delay time.Duration
+    if delay, err = limiter.wait(cfg, DELIVERY_MAX_RATE_LIMIT_WAIT-waited); err != nil {
+      break
+    }
+    waited += delay
+    delivery.Attempts++
+    err = MessageUser(cfg, userId, message)
+    if err == nil {
+      delivery.Status = DELIVERY_SENT
+      break
+    }
+    if IsDeactivatedError(err) {
+      delivery.Status = DELIVERY_DEACTIVATED
+      break
+    }
+    var rateLimitErr *RateLimitError
+    if errors.As(err, &rateLimitErr) {
+      limiter.pause(rateLimitErr.RetryAfter)
+      continue
+    }
+    failures++
+    var apiErr *SlackApiError
+    if errors.As(err, &apiErr) {
+      // Slack gave a reason, which won't change by trying again
+      break
+    }
+    var unconfirmedErr *UnconfirmedMessageError
+    if errors.As(err, &unconfirmedErr) {
+      // chat.postMessage isn't idempotent, so a message Slack may have posted is not sent again
+      break
+    }
+    select {
+    case <-cfg.Context().Done():
+    case <-time.After(DELIVERY_RETRY_DELAY << uint(failures-1)):
+    }
+  }
+  if err != nil {
+    delivery.Error = err.Error()
+  }
+  delivery.UpdatedAt = time.Now()
+  return delivery
+}
+
+// DMs the message to every user with FANOUT_WORKERS workers, recording how each delivery went for the session
+// progress is logged every FANOUT_PROGRESS_INTERVAL, and every worker backs off when Slack rate limits one of them
+func FanOutMessage(cfg *Config, sessionId int64, kind string, userIds []string, message string) []Delivery {
+  cfg, span := cfg.StartSpan("FanOutMessage", SPAN_KIND_INTERNAL, "delivery.kind", kind, "delivery.users", len(userIds))
+  defer span.End()
+  start := time.Now()
+  limiter := &fanOutLimiter{}
+  pending := make(chan string)
+  results := make(chan Delivery)
+
+  workers := FANOUT_WORKERS
+  if len(userIds) < workers {
+    workers = len(userIds)
+  }
+  wg := sync.WaitGroup{}
+  for i := 0; i < workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for userId := range pending {
+        results <- deliver(cfg, limiter, kind, userId, message)
+      }
+    }()
+  }
+  go func() {
+    for _, userId := range userIds {
+      pending <- userId
+    }
+    close(pending)
+    wg.Wait()
+    close(results)
+  }()
+
+  ticker := time.NewTicker(FANOUT_PROGRESS_INTERVAL)
+  defer ticker.Stop()
+  deliveries := make([]Delivery, 0, len(userIds))
+  counts := make(map[string]int)
+  for {
+    select {
+    case delivery, ok := <-results:
+      if !ok {
+        cfg.Log().Info("Sent DMs", "kind", kind, "sent", counts[DELIVERY_SENT], "failed", counts[DELIVERY_FAILED],
+          "deactivated", counts[DELIVERY_DEACTIVATED], "duration", time.Since(start))
+        span.SetAttributes("delivery.sent", counts[DELIVERY_SENT], "delivery.failed", counts[DELIVERY_FAILED],
+          "delivery.deactivated", counts[DELIVERY_DEACTIVATED])
+        return deliveries
+      }
+      if delivery.Status != DELIVERY_SENT {
+        cfg.Log().Warn("DM not delivered", "kind", kind, "user", delivery.UserId, "status", delivery.Status,
+          "attempts", delivery.Attempts, "err", delivery.Error)
+      }
+      RecordDelivery(cfg, sessionId, delivery)
+      DELIVERIES.WithLabelValues(kind, delivery.Status).Inc()
+      counts[delivery.Status]++
+      deliveries = append(deliveries, delivery)
+    case <-ticker.C:
+      cfg.Log().Info("Sending DMs", "kind", kind, "done", len(deliveries), "total", len(userIds),
+        "failed", counts[DELIVERY_FAILED]+counts[DELIVERY_DEACTIVATED])
+    }
+  }
+}
+
+// records how a DM of the session went, replacing an earlier attempt at the same DM
+func RecordDelivery(cfg *Config, sessionId int64, delivery Delivery) {
+  if sessionId == 0 {
+    return
+  }
+  if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO deliveries (session_id, user_id, kind, status, error, attempts, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    ON CONFLICT (session_id, user_id, kind) DO UPDATE SET status = $4, error = $5, attempts = $6, updated_at = $7;`,
+    sessionId, delivery.UserId, delivery.Kind, delivery.Status, delivery.Error, delivery.Attempts, delivery.UpdatedAt); err != nil {
+    cfg.Log().Error("Error recording delivery", "err", err)
+  }
+}
+
+// gets the DMs of the session, the ones not delivered first
+func GetDeliveries(cfg *Config, sessionId int64) (deliveries []Delivery, err error) {
+  rows, err := DB.QueryContext(cfg.Context(), `SELECT user_id, kind, status, error, attempts, updated_at FROM deliveries
+    WHERE session_id = $1 ORDER BY status = $2, kind, user_id;`, sessionId, DELIVERY_SENT)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var delivery Delivery
+    if err = rows.Scan(&delivery.UserId, &delivery.Kind, &delivery.Status, &delivery.Error, &delivery.Attempts,
+      &delivery.UpdatedAt); err != nil {
+      return nil, err
+    }
+    deliveries = append(deliveries, delivery)
+  }
+  return deliveries, rows.Err()
+}
+
+// gets the users a reminder of the session was delivered to, or that can't be DM'd
+// reminders that failed are left out so they are tried again
+func GetRemindedUsers(cfg *Config, sessionId int64) map[string]bool {
+  reminded := make(map[string]bool)
+  rows, err := DB.QueryContext(cfg.Context(), "SELECT user_id FROM deliveries WHERE session_id = $1 AND kind = $2 AND status <> $3;",
+    sessionId, DELIVERY_REMINDER, DELIVERY_FAILED)
+  if err != nil {
+    cfg.Log().Error("Error getting reminded users", "err", err)
+    return reminded
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var userId string
+    if err = rows.Scan(&userId); err != nil {
+      cfg.Log().Error("Error getting reminded users", "err", err)
+      return reminded
+    }
+    reminded[userId] = true
+  }
+  return reminded
+}
+
+// gets the id of the open session of the standup, or of the last one if none is open
+func GetLatestSessionId(cfg *Config) (id int64) {
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT id FROM sessions WHERE standup = $1 ORDER BY opened_at DESC LIMIT 1;", cfg.Standup.Name).Scan(&id)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting latest session", "err", err)
+  }
+  return id
+}
+
+// counts the DMs that were not delivered
+func CountUndelivered(deliveries []Delivery) (undelivered int) {
+  for _, delivery := range deliveries {
+    if delivery.Status != DELIVERY_SENT {
+      undelivered++
+    }
+  }
+  return undelivered
+}
+
+// lists the DMs of the latest session that were not delivered
+func RunDeliveriesCommand(cfg *Config, req SlashRequest, args []string) string {
+  if len(args) != 0 {
+    return "Usage: `deliveries`"
+  }
+  sessionId := GetLatestSessionId(cfg)
+  if sessionId == 0 {
+    return "There hasn't been a checkin session yet."
+  }
+  deliveries, err := GetDeliveries(cfg, sessionId)
+  if err != nil {
+    cfg.Log().Error("Error getting deliveries", "err", err)
+    return "Couldn't get the deliveries, try again later."
+  }
+
+  counts := make(map[string]int)
+  for _, delivery := range deliveries {
+    counts[delivery.Kind+" "+delivery.Status]++
+  }
+  builder := strings.Builder{}
+  for _, kind := range []string{DELIVERY_PROMPT, DELIVERY_REMINDER} {
+    total := counts[kind+" "+DELIVERY_SENT] + counts[kind+" "+DELIVERY_FAILED] + counts[kind+" "+DELIVERY_DEACTIVATED]
+    if total == 0 {
+      continue
+    }
+    if builder.Len() > 0 {
+      builder.WriteString("\n")
+    }
+    fmt.Fprintf(&builder, "%ss: %d of %d sent, %d failed, %d to deactivated users", strings.ToUpper(kind[:1])+kind[1:],
+      counts[kind+" "+DELIVERY_SENT], total, counts[kind+" "+DELIVERY_FAILED], counts[kind+" "+DELIVERY_DEACTIVATED])
+  }
+  if builder.Len() == 0 {
+    return "No DMs were sent for the latest checkin session."
+  }
+  for _, delivery := range deliveries {
+    if delivery.Status == DELIVERY_SENT {
+      continue
+    }
+    fmt.Fprintf(&builder, "\n<@%s> - %s %s after %d attempts: %s", delivery.UserId, delivery.Kind, delivery.Status,
+      delivery.Attempts, delivery.Error)
+  }
+  return builder.String()
+}
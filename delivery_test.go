@@ -0,0 +1,118 @@
+package main
+
+import (
+  "errors"
+  "io"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "strings"
+  "testing"
+  "time"
+)
+
+// sends the Slack API calls made from here on to the given function until restore is called
+// it is given the number of the call and its request, and returns the status and body of the response or an error
+func fakeSlackCalls(respond func(call int, r *http.Request) (int, string, error)) (calls *int, restore func()) {
+  calls = new(int)
+  transport := http.DefaultTransport
+  http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+    *calls++
+    status, body, err := respond(*calls, r)
+    if err != nil {
+      return nil, err
+    }
+    return &http.Response{StatusCode: status, Header: make(http.Header), Request: r,
+      Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+  })
+  return calls, func() {
+    http.DefaultTransport = transport
+  }
+}
+
+func TestDeliverRateLimitedNotCounted(t *testing.T) {
+  DM_CHANNELS = map[string]string{"U1": "D1"}
+  // every attempt DELIVERY_MAX_ATTEMPTS allows is rate limited before the DM goes through
+  calls, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    if call <= DELIVERY_MAX_ATTEMPTS {
+      return http.StatusTooManyRequests, "", nil
+    }
+    return http.StatusOK, `{"ok":true}`, nil
+  })
+  defer restore()
+
+  delivery := deliver(&Config{ApiToken: "xoxb-test"}, &fanOutLimiter{}, DELIVERY_PROMPT, "U1", "hi")
+  if delivery.Status != DELIVERY_SENT || delivery.Attempts != DELIVERY_MAX_ATTEMPTS+1 || *calls != DELIVERY_MAX_ATTEMPTS+1 {
+    t.Errorf("delivery = %+v after %d calls, want it sent on call %d", delivery, *calls, DELIVERY_MAX_ATTEMPTS+1)
+  }
+}
+
+func TestDeliverGivesUpWhenRateLimitedTooLong(t *testing.T) {
+  DM_CHANNELS = map[string]string{"U1": "D1"}
+  maxWait := DELIVERY_MAX_RATE_LIMIT_WAIT
+  defer func() { DELIVERY_MAX_RATE_LIMIT_WAIT = maxWait }()
+  DELIVERY_MAX_RATE_LIMIT_WAIT = 1500 * time.Millisecond
+  // Slack asks to wait a second every time
+  calls, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    return http.StatusTooManyRequests, "", nil
+  })
+  defer restore()
+
+  delivery := deliver(&Config{ApiToken: "xoxb-test"}, &fanOutLimiter{}, DELIVERY_PROMPT, "U1", "hi")
+  if delivery.Status != DELIVERY_FAILED || *calls != 2 || !strings.Contains(delivery.Error, "rate limited") {
+    t.Errorf("delivery = %+v after %d calls, want it failed as rate limited after 2 calls", delivery, *calls)
+  }
+}
+
+func TestDeliverRetriesUnsentMessages(t *testing.T) {
+  DM_CHANNELS = map[string]string{"U1": "D1"}
+  calls, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    if call == 1 {
+      return 0, "", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+    }
+    return http.StatusOK, `{"ok":true}`, nil
+  })
+  defer restore()
+
+  delivery := deliver(&Config{ApiToken: "xoxb-test"}, &fanOutLimiter{}, DELIVERY_PROMPT, "U1", "hi")
+  if delivery.Status != DELIVERY_SENT || delivery.Attempts != 2 || *calls != 2 {
+    t.Errorf("delivery = %+v after %d calls, want it sent on the second call", delivery, *calls)
+  }
+}
+
+func TestDeliverDoesNotResendUnconfirmedMessages(t *testing.T) {
+  DM_CHANNELS = map[string]string{"U1": "D1"}
+  // the connection drops after the request was written, so Slack may have posted the message
+  calls, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    if call == 1 {
+      return 0, "", io.ErrUnexpectedEOF
+    }
+    return http.StatusOK, `{"ok":true}`, nil
+  })
+  defer restore()
+
+  delivery := deliver(&Config{ApiToken: "xoxb-test"}, &fanOutLimiter{}, DELIVERY_PROMPT, "U1", "hi")
+  if delivery.Status != DELIVERY_FAILED || delivery.Attempts != 1 || *calls != 1 {
+    t.Errorf("delivery = %+v after %d calls, want it failed without posting again", delivery, *calls)
+  }
+  if !strings.Contains(delivery.Error, "may have been sent") {
+    t.Errorf("delivery error = %q, want it to say the message may have been sent", delivery.Error)
+  }
+}
+
+func TestIsUnsentError(t *testing.T) {
+  tests := []struct {
+    err error
+    want bool
+  }{
+    {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+    {&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, false},
+    {io.ErrUnexpectedEOF, false},
+    {&RateLimitError{Method: "chat.postMessage"}, false},
+  }
+  for _, test := range tests {
+    if got := IsUnsentError(test.err); got != test.want {
+      t.Errorf("IsUnsentError(%v) = %t, want %t", test.err, got, test.want)
+    }
+  }
+}
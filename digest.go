@@ -0,0 +1,214 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "regexp"
+  "sort"
+  "strings"
+  "time"
+)
+
+const DIGEST_TARGET_CHANNEL = "channel"
+const DIGEST_TARGET_MANAGERS = "managers"
+const DIGEST_JOB_NAME = "weekly digest"
+
+// type to contain a single day of a user's checkins in a digest
+type DigestDay struct {
+  Date time.Time
+  Text string
+}
+
+// type to contain all of a user's checkins in a digest
+type UserDigest struct {
+  UserId string
+  Name string
+  Days []DigestDay
+}
+
+// type to contain a weekly digest of a standup
+type Digest struct {
+  Standup string
+  From, To time.Time
+  Sessions int
+  Expected int
+  Answered int
+  Users []UserDigest
+}
+
+// matches user mentions, which slash commands escape as <@U123|name>
+var MENTION_REGEX = regexp.MustCompile(`<@([UW][A-Z0-9]+)(\|[^>]*)?>`)
+
+// gets the ids of the users mentioned in the given text, in order of first mention
+func ParseMentions(text string) (userIds []string) {
+  seen := make(map[string]bool)
+  for _, match := range MENTION_REGEX.FindAllStringSubmatch(text, -1) {
+    if !seen[match[1]] {
+      seen[match[1]] = true
+      userIds = append(userIds, match[1])
+    }
+  }
+  return userIds
+}
+
+// builds the digest of the given standup between from (inclusive) and to (exclusive)
+// all of a user's responses on the same day are concatenated
+func GenerateDigest(cfg *Config, standup string, from, to time.Time) (digest Digest, err error) {
+  digest = Digest{Standup: standup, From: from, To: to}
+  records, err := GetExportRecords(cfg, standup, from, to)
+  if err != nil {
+    return digest, err
+  }
+
+  loc := GetLocation()
+  users := make(map[string]*UserDigest)
+  var sessionId int64
+  for _, record := range records {
+    if record.SessionId != sessionId {
+      sessionId = record.SessionId
+      digest.Sessions++
+    }
+    digest.Expected++
+    if record.RespondedAt == nil {
+      continue
+    }
+    digest.Answered++
+
+    user, ok := users[record.UserId]
+    if !ok {
+      user = &UserDigest{UserId: record.UserId, Name: record.Name}
+      users[record.UserId] = user
+    }
+    local := record.OpenedAt.In(loc)
+    day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+    last := len(user.Days) - 1
+    if last >= 0 && user.Days[last].Date.Equal(day) {
+      user.Days[last].Text = fmt.Sprintf("%s\n%s", user.Days[last].Text, record.Response)
+    } else {
+      user.Days = append(user.Days, DigestDay{Date: day, Text: record.Response})
+    }
+  }
+
+  for _, user := range users {
+    digest.Users = append(digest.Users, *user)
+  }
+  sort.Slice(digest.Users, func(i, j int) bool {
+    return digest.Users[i].Name < digest.Users[j].Name
+  })
+  return digest, nil
+}
+
+// formats a single user's section of a digest
+func FormatUserDigest(cfg *Config, user UserDigest) string {
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "*%s*\n", user.Name)
+  for _, day := range user.Days {
+    text := strings.Replace(HighlightBlockers(cfg, day.Text), "\n", "\n>", -1)
+    fmt.Fprintf(&builder, "_%s_\n>%s\n", day.Date.Format("Mon Jan 2"), text)
+  }
+  return builder.String()
+}
+
+// formats a digest as a Slack message
+// if userId is not empty, only that user's checkins are included
+func FormatDigest(cfg *Config, digest Digest, userId string) string {
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "Weekly digest for %s, %s to %s\n", digest.Standup,
+    digest.From.Format("Jan 2"), digest.To.AddDate(0, 0, -1).Format("Jan 2"))
+  if userId == "" {
+    fmt.Fprintf(&builder, "%d sessions, %d of %d checkins completed\n", digest.Sessions, digest.Answered, digest.Expected)
+  }
+
+  found := false
+  for _, user := range digest.Users {
+    if userId != "" && user.UserId != userId {
+      continue
+    }
+    found = true
+    builder.WriteString("\n")
+    builder.WriteString(FormatUserDigest(cfg, user))
+  }
+  if !found {
+    builder.WriteString("\nNo checkins were completed this week.")
+  }
+  return builder.String()
+}
+
+// gets the digest range for the week ending at the given time
+func DigestRange(end time.Time) (from, to time.Time) {
+  local := end.In(GetLocation())
+  to = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+  return to.AddDate(0, 0, -7), to
+}
+
+// generates the digest for the past week and posts it to the main channel,
+// or DMs it to the digest managers when the digest target is "managers"
+func SendWeeklyDigest(cfg *Config) {
+  from, to := DigestRange(time.Now())
+  digest, err := GenerateDigest(cfg, cfg.Standup.Name, from, to)
+  if err != nil {
+    cfg.Log().Error("Error generating weekly digest", "err", err)
+    return
+  }
+  message := FormatDigest(cfg, digest, "")
+  RecordAudit(cfg, SCHEDULER_ACTOR, "send_digest", SOURCE_SCHEDULE, cfg.Digest.Target)
+
+  if cfg.Digest.Target == DIGEST_TARGET_MANAGERS {
+    for _, userId := range cfg.Digest.Managers {
+      if userId != "" {
+        MessageUser(cfg, userId, message)
+      }
+    }
+    return
+  }
+
+  cfg = CONFIG.WithChannelId(cfg)
+  SendMessage(cfg, message, cfg.Standup.ChannelId, "")
+}
+
+// schedules the weekly digest if the digest schedule is set
+func ScheduleWeeklyDigest(cfg *Config) {
+  if cfg.Digest.Schedule == "" {
+    return
+  }
+  next, err := ParseWeeklySchedule(cfg.Digest.Schedule)
+  if err != nil {
+    cfg.Log().Info("Weekly digest not scheduled", "reason", err)
+    return
+  }
+  SCHEDULER.Add(&ScheduledJob{Name: DIGEST_JOB_NAME, Next: next, Run: func() {
+    SendWeeklyDigest(cfg)
+  }})
+}
+
+// runs the digest command, previewing the digest of the past week without posting it,
+// optionally for a single mentioned user
+func RunDigestCommand(cfg *Config, args []string) string {
+  var previewUser string
+  if mentions := ParseMentions(strings.Join(args, " ")); len(mentions) > 0 {
+    previewUser = mentions[0]
+  }
+
+  from, to := DigestRange(time.Now())
+  digest, err := GenerateDigest(cfg, cfg.Standup.Name, from, to)
+  if err != nil {
+    cfg.Log().Error("Error generating digest preview", "err", err)
+    return "Could not generate the digest, try again later"
+  }
+  return FormatDigest(cfg, digest, previewUser)
+}
+
+// the handler for the /digest endpoint
+// if the given user_id is not allowed to view reports, then the function does not proceed
+func DigestPreviewHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if !HasPermission(cfg, req.UserId, PERM_VIEW_REPORTS) {
+    w.Write([]byte("You are not allowed to view reports"))
+    return
+  }
+  w.Write([]byte(RunDigestCommand(cfg, strings.Fields(req.Text))))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+func TestParseMentions(t *testing.T) {
+  tests := []struct {
+    text string
+    want []string
+  }{
+    {"", nil},
+    {"no mentions here", nil},
+    {"<@U123>", []string{"U123"}},
+    {"<@U123|ada> and <@W456|grace>", []string{"U123", "W456"}},
+    {"<@U123> then <@U123|ada> again", []string{"U123"}},
+    {"<@u123> <@C123> <#C123|general> @U123", nil},
+    {"ask <@U9ABC>, then <@U123>", []string{"U9ABC", "U123"}},
+  }
+  for _, test := range tests {
+    if got := ParseMentions(test.text); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("ParseMentions(%q) = %v, want %v", test.text, got, test.want)
+    }
+  }
+}
+
+func TestDigestRange(t *testing.T) {
+  loc := GetLocation()
+  tests := []struct {
+    name string
+    end time.Time
+    from, to time.Time
+  }{
+    {"friday afternoon", time.Date(2024, 5, 10, 16, 0, 0, 0, loc),
+      time.Date(2024, 5, 4, 0, 0, 0, 0, loc), time.Date(2024, 5, 11, 0, 0, 0, 0, loc)},
+    // late on thursday in New York is already friday in UTC, and the week still ends with thursday
+    {"evening before midnight UTC", time.Date(2024, 5, 10, 2, 0, 0, 0, time.UTC),
+      time.Date(2024, 5, 3, 0, 0, 0, 0, loc), time.Date(2024, 5, 10, 0, 0, 0, 0, loc)},
+    {"just after midnight", time.Date(2024, 5, 10, 0, 0, 0, 0, loc),
+      time.Date(2024, 5, 4, 0, 0, 0, 0, loc), time.Date(2024, 5, 11, 0, 0, 0, 0, loc)},
+    // a week the clocks go forward in is an hour short, but still starts and ends at midnight
+    {"across the start of daylight saving time", time.Date(2024, 3, 15, 16, 0, 0, 0, loc),
+      time.Date(2024, 3, 9, 0, 0, 0, 0, loc), time.Date(2024, 3, 16, 0, 0, 0, 0, loc)},
+  }
+  for _, test := range tests {
+    from, to := DigestRange(test.end)
+    if !from.Equal(test.from) || !to.Equal(test.to) {
+      t.Errorf("%s: DigestRange(%s) = %s, %s, want %s, %s", test.name, test.end, from, to, test.from, test.to)
+    }
+  }
+}
+
+func digestConfig() *Config {
+  return &Config{Blockers: BlockerConfig{Prefix: "Blockers:"}}
+}
+
+func TestFormatUserDigest(t *testing.T) {
+  loc := GetLocation()
+  user := UserDigest{UserId: "U1", Name: "Ada", Days: []DigestDay{
+    {Date: time.Date(2024, 5, 6, 0, 0, 0, 0, loc), Text: "Wrote the parser\nBlockers: waiting on review"},
+    {Date: time.Date(2024, 5, 7, 0, 0, 0, 0, loc), Text: "Shipped it"},
+  }}
+  want := "*Ada*\n" +
+    "_Mon May 6_\n>Wrote the parser\n>:warning: *Blockers: waiting on review*\n" +
+    "_Tue May 7_\n>Shipped it\n"
+  if got := FormatUserDigest(digestConfig(), user); got != want {
+    t.Errorf("FormatUserDigest() = %q, want %q", got, want)
+  }
+}
+
+func TestFormatDigest(t *testing.T) {
+  loc := GetLocation()
+  from, to := time.Date(2024, 5, 4, 0, 0, 0, 0, loc), time.Date(2024, 5, 11, 0, 0, 0, 0, loc)
+  digest := Digest{Standup: "eng", From: from, To: to, Sessions: 2, Expected: 4, Answered: 3, Users: []UserDigest{
+    {UserId: "U1", Name: "Ada", Days: []DigestDay{{Date: time.Date(2024, 5, 6, 0, 0, 0, 0, loc), Text: "Parser"}}},
+    {UserId: "U2", Name: "Grace", Days: []DigestDay{{Date: time.Date(2024, 5, 7, 0, 0, 0, 0, loc), Text: "Compiler"}}},
+  }}
+  tests := []struct {
+    name string
+    digest Digest
+    userId string
+    want string
+  }{
+    {"everyone", digest, "", "Weekly digest for eng, May 4 to May 10\n2 sessions, 3 of 4 checkins completed\n" +
+      "\n*Ada*\n_Mon May 6_\n>Parser\n" +
+      "\n*Grace*\n_Tue May 7_\n>Compiler\n"},
+    {"one user", digest, "U2", "Weekly digest for eng, May 4 to May 10\n" +
+      "\n*Grace*\n_Tue May 7_\n>Compiler\n"},
+    {"user without checkins", digest, "U3", "Weekly digest for eng, May 4 to May 10\n" +
+      "\nNo checkins were completed this week."},
+    {"nobody checked in", Digest{Standup: "eng", From: from, To: to, Sessions: 1, Expected: 2},
+      "", "Weekly digest for eng, May 4 to May 10\n1 sessions, 0 of 2 checkins completed\n" +
+      "\nNo checkins were completed this week."},
+  }
+  for _, test := range tests {
+    if got := FormatDigest(digestConfig(), test.digest, test.userId); got != test.want {
+      t.Errorf("%s: FormatDigest() = %q, want %q", test.name, got, test.want)
+    }
+  }
+}
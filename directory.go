@@ -0,0 +1,126 @@
+package main
+
+import (
+  "database/sql"
+  "time"
+)
+
+// how long a user fetched from Slack is used before fetching them again
+// user_change events update users in the meantime
+const USER_DIRECTORY_TTL = 24 * time.Hour
+
+// type to contain a user of the directory, as last fetched from Slack or sent in a user_change event
+type DirectoryUser struct {
+  Id string `json:"id"`
+  RealName string `json:"real_name"`
+  DisplayName string `json:"display_name"`
+  Tz string `json:"tz"`
+  IsBot bool `json:"is_bot"`
+  Deleted bool `json:"deleted"`
+  Avatar string `json:"avatar"`
+  FetchedAt time.Time `json:"fetched_at"`
+}
+
+// creates the user directory table if it does not exist yet
+func UserDirectorySetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS user_directory (
+      user_id TEXT PRIMARY KEY,
+      real_name TEXT NOT NULL,
+      display_name TEXT NOT NULL,
+      tz TEXT NOT NULL,
+      is_bot BOOLEAN NOT NULL,
+      deleted BOOLEAN NOT NULL,
+      avatar TEXT NOT NULL,
+      fetched_at TIMESTAMPTZ NOT NULL
+    );`); err != nil {
+    LOG.Error("Error creating user directory table", "err", err)
+  }
+}
+
+// converts a user as sent by Slack to a user of the directory
+func NewDirectoryUser(info UserInfo) DirectoryUser {
+  realName := info.Real_name
+  if realName == "" {
+    realName = info.Profile.Real_name
+  }
+  return DirectoryUser{
+    Id: info.Id,
+    RealName: realName,
+    DisplayName: info.Profile.Display_name,
+    Tz: info.Tz,
+    IsBot: info.Is_bot,
+    Deleted: info.Deleted,
+    Avatar: info.Profile.Image_72,
+    FetchedAt: time.Now(),
+  }
+}
+
+// gets the user from the directory, fetching them with users.info when they are missing or older than USER_DIRECTORY_TTL
+// if they cannot be fetched, a stale copy is used rather than failing
+func LookupUser(cfg *Config, userId string) (user DirectoryUser, err error) {
+  err = DB.QueryRowContext(cfg.Context(), `SELECT user_id, real_name, display_name, tz, is_bot, deleted, avatar, fetched_at
+    FROM user_directory WHERE user_id = $1;`, userId).Scan(&user.Id, &user.RealName, &user.DisplayName, &user.Tz,
+    &user.IsBot, &user.Deleted, &user.Avatar, &user.FetchedAt)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting user from the directory", "user", userId, "err", err)
+  }
+  cached := err == nil
+  if cached && time.Since(user.FetchedAt) < USER_DIRECTORY_TTL {
+    return user, nil
+  }
+
+  fetched, err := FetchUser(cfg, userId)
+  if err != nil {
+    if cached {
+      cfg.Log().Warn("Using stale user from the directory", "user", userId, "fetched_at", user.FetchedAt, "err", err)
+      return user, nil
+    }
+    return DirectoryUser{}, err
+  }
+  SaveDirectoryUser(cfg, fetched)
+  return fetched, nil
+}
+
+// fetches the user from Slack with users.info
+func FetchUser(cfg *Config, userId string) (DirectoryUser, error) {
+  params := make(map[string]string)
+  params["user"] = userId
+  res, err := PerformGet(cfg, "users.info", nil, params, true)
+  body, err := HandleResponse(cfg, res, err, false)
+  if err != nil {
+    return DirectoryUser{}, err
+  }
+  if !body.Ok {
+    return DirectoryUser{}, &SlackApiError{Method: "users.info", Code: body.Error}
+  }
+  return NewDirectoryUser(body.User), nil
+}
+
+// adds the user to the directory, replacing what was stored for them
+func SaveDirectoryUser(cfg *Config, user DirectoryUser) {
+  if user.Id == "" {
+    return
+  }
+  if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO user_directory
+    (user_id, real_name, display_name, tz, is_bot, deleted, avatar, fetched_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    ON CONFLICT (user_id) DO UPDATE SET real_name = $2, display_name = $3, tz = $4, is_bot = $5, deleted = $6,
+    avatar = $7, fetched_at = $8;`,
+    user.Id, user.RealName, user.DisplayName, user.Tz, user.IsBot, user.Deleted, user.Avatar, user.FetchedAt); err != nil {
+    cfg.Log().Error("Error saving user to the directory", "user", user.Id, "err", err)
+  }
+}
+
+// updates the directory with the user sent in a user_change event
+func HandleUserChange(cfg *Config, info *UserInfo) {
+  if info == nil || info.Id == "" {
+    cfg.Log().Warn("user_change event without a user")
+    return
+  }
+  SaveDirectoryUser(cfg, NewDirectoryUser(*info))
+  cfg.Log().Info("Updated user in the directory", "user", info.Id)
+}
+
+// determines if the user is a bot, including this one, so their messages are not taken as checkins
+func (user DirectoryUser) IsBotUser() bool {
+  return user.IsBot || user.RealName == BOT_NAME
+}
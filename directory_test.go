@@ -0,0 +1,115 @@
+package main
+
+import (
+  "net/http"
+  "testing"
+  "time"
+)
+
+// answers users.info with the user of the given name, counting the calls, or with user_not_found once failing is set
+type fakeUsersInfo struct {
+  calls int
+  names map[string]string
+  failing bool
+}
+
+func (f *fakeUsersInfo) respond(method string, r *http.Request) interface{} {
+  if method != "users.info" {
+    return map[string]interface{}{"ok": true}
+  }
+  f.calls++
+  userId := slackParams(r)["user"]
+  if f.failing {
+    return map[string]interface{}{"ok": false, "error": "user_not_found"}
+  }
+  return map[string]interface{}{"ok": true, "user": map[string]interface{}{
+    "id": userId, "real_name": f.names[userId], "is_bot": userId == "UBOT",
+  }}
+}
+
+// ages the user in the directory past USER_DIRECTORY_TTL
+func expireDirectoryUser(t *testing.T, userId string) {
+  t.Helper()
+  if _, err := DB.Exec("UPDATE user_directory SET fetched_at = $1 WHERE user_id = $2;",
+    time.Now().Add(-USER_DIRECTORY_TTL-time.Minute), userId); err != nil {
+    t.Fatalf("expiring the user: %v", err)
+  }
+}
+
+func TestLookupUser(t *testing.T) {
+  requireDB(t)
+  slack := &fakeUsersInfo{names: map[string]string{"U1": "Ada"}}
+  defer fakeSlack(slack.respond)()
+  cfg := &Config{ApiToken: "xoxb-test"}
+
+  // fetched from Slack the first time, then read from the directory while it's fresh
+  for i := 0; i < 2; i++ {
+    user, err := LookupUser(cfg, "U1")
+    if err != nil || user.RealName != "Ada" || slack.calls != 1 {
+      t.Errorf("LookupUser() #%d = %+v, %v after %d calls, want Ada fetched once", i+1, user, err, slack.calls)
+    }
+  }
+
+  // fetched again once it has expired
+  slack.names["U1"] = "Ada Lovelace"
+  expireDirectoryUser(t, "U1")
+  user, err := LookupUser(cfg, "U1")
+  if err != nil || user.RealName != "Ada Lovelace" || slack.calls != 2 {
+    t.Errorf("LookupUser() of an expired user = %+v, %v after %d calls, want them fetched again", user, err, slack.calls)
+  }
+
+  // the stale copy is used when they can't be fetched again
+  slack.failing = true
+  expireDirectoryUser(t, "U1")
+  user, err = LookupUser(cfg, "U1")
+  if err != nil || user.RealName != "Ada Lovelace" || slack.calls != 3 {
+    t.Errorf("LookupUser() failing to fetch = %+v, %v after %d calls, want the stale user", user, err, slack.calls)
+  }
+
+  // without a copy, the error is returned
+  if user, err = LookupUser(cfg, "U2"); err == nil {
+    t.Errorf("LookupUser() of an unknown user failing to fetch = %+v, want an error", user)
+  }
+}
+
+func TestHandleUserChange(t *testing.T) {
+  requireDB(t)
+  slack := &fakeUsersInfo{names: map[string]string{"U1": "Ada"}}
+  defer fakeSlack(slack.respond)()
+  cfg := &Config{ApiToken: "xoxb-test"}
+  if _, err := LookupUser(cfg, "U1"); err != nil {
+    t.Fatalf("LookupUser() = %v", err)
+  }
+
+  HandleUserChange(cfg, &UserInfo{Id: "U1", Real_name: "Ada Lovelace", Profile: UserProfile{Display_name: "ada"}})
+  user, err := LookupUser(cfg, "U1")
+  if err != nil || user.RealName != "Ada Lovelace" || user.DisplayName != "ada" || slack.calls != 1 {
+    t.Errorf("LookupUser() after user_change = %+v, %v after %d calls, want the changed user without fetching them",
+      user, err, slack.calls)
+  }
+
+  // a user_change event without a user changes nothing
+  HandleUserChange(cfg, nil)
+  HandleUserChange(cfg, &UserInfo{})
+  if user, _ = LookupUser(cfg, "U1"); user.RealName != "Ada Lovelace" {
+    t.Errorf("LookupUser() after an empty user_change = %+v, want the user unchanged", user)
+  }
+}
+
+func TestMapIdsToNames(t *testing.T) {
+  defer useFakeDB(t)()
+  slack := &fakeUsersInfo{names: map[string]string{"U1": "Ada", "UBOT": "Standup bot"}}
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    slack.failing = slackParams(r)["user"] == "UGONE"
+    return slack.respond(method, r)
+  })()
+
+  names := MapIdsToNames(&Config{ApiToken: "xoxb-test"}, []string{"U1", "UBOT", "UGONE", "UNONAME", ""})
+  want := []string{"Ada", "", "<@UGONE>", "<@UNONAME>", ""}
+  for i := range want {
+    if names[i] != want[i] {
+      t.Errorf("MapIdsToNames() = %q, want %q", names, want)
+      break
+    }
+  }
+}
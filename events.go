@@ -0,0 +1,82 @@
+package main
+
+import (
+  "encoding/json"
+  "time"
+)
+
+// how long handled event ids are kept, well past the last retry Slack makes
+const EVENT_TTL = 24 * time.Hour
+
+const EVENT_PRUNE_JOB_NAME = "prune events"
+
+// creates the table of handled Slack events if it does not exist yet
+func EventSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS processed_events (
+      event_id TEXT PRIMARY KEY,
+      received_at TIMESTAMPTZ NOT NULL
+    );`); err != nil {
+    LOG.Error("Error creating processed events table", "err", err)
+  }
+}
+
+// claims the Slack event with the given id and queues it to be handled, in one transaction,
+// so an event is never claimed without being queued
+// returns false if the event was already claimed, which happens when Slack retries a delivery
+// events without an id are always queued
+func QueueEvent(cfg *Config, eventId string, payload json.RawMessage) (queued bool, err error) {
+  tx, err := DB.BeginTx(cfg.Context(), nil)
+  if err != nil {
+    return false, err
+  }
+  defer tx.Rollback()
+  if eventId != "" {
+    res, err := tx.ExecContext(cfg.Context(),
+      "INSERT INTO processed_events (event_id, received_at) VALUES ($1, $2) ON CONFLICT (event_id) DO NOTHING;",
+      eventId, time.Now())
+    if err != nil {
+      return false, err
+    }
+    if rowsAff, _ := res.RowsAffected(); rowsAff == 0 {
+      return false, nil
+    }
+  }
+  if err = enqueue(cfg, tx, JOB_SLACK_EVENT, payload); err != nil {
+    return false, err
+  }
+  if err = tx.Commit(); err != nil {
+    return false, err
+  }
+  return true, nil
+}
+
+// removes the handled events older than EVENT_TTL
+func PruneEvents() {
+  res, err := DB.Exec("DELETE FROM processed_events WHERE received_at < $1;", time.Now().Add(-EVENT_TTL))
+  if err != nil {
+    LOG.Error("Error pruning events", "err", err)
+    return
+  }
+  rowsAff, _ := res.RowsAffected()
+  LOG.Info("Pruned handled events", "count", rowsAff)
+}
+
+// schedules pruning the handled events every hour
+func ScheduleEventPruning() {
+  SCHEDULER.Add(&ScheduledJob{
+    Name: EVENT_PRUNE_JOB_NAME,
+    Next: func(after time.Time) time.Time {
+      return after.Add(time.Hour)
+    },
+    Run: PruneEvents,
+  })
+}
+
+// handles a Slack event queued by HandleCallback
+func RunEventJob(cfg *Config, payload []byte) error {
+  var body SlackResponse
+  if err := json.Unmarshal(payload, &body); err != nil {
+    return err
+  }
+  return ProcessEvent(cfg, body)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// posts a signed event callback with the given event id, as the given delivery attempt (0 for the first)
+func postEvent(router http.Handler, eventId string, retryNum int) *httptest.ResponseRecorder {
+  body := fmt.Sprintf(`{"type":"event_callback","event_id":%q,"event":{"type":"app_mention","user":"U1","channel":"C1","text":"hi"}}`, eventId)
+  r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+  r.Header = signedHeader("secret", time.Now(), body)
+  r.Header.Set("Content-Type", "application/json")
+  if retryNum > 0 {
+    r.Header.Set("X-Slack-Retry-Num", fmt.Sprint(retryNum))
+    r.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+  }
+  w := httptest.NewRecorder()
+  router.ServeHTTP(w, r)
+  return w
+}
+
+func countEventJobs(t *testing.T) int {
+  t.Helper()
+  var count int
+  if err := DB.QueryRow("SELECT COUNT(*) FROM jobs WHERE kind = $1;", JOB_SLACK_EVENT).Scan(&count); err != nil {
+    t.Fatalf("counting event jobs: %v", err)
+  }
+  return count
+}
+
+func TestRetriedEventsQueuedOnce(t *testing.T) {
+  requireDB(t)
+  CONFIG = NewConfigStore("", &Config{SigningSecret: "secret", Standup: StandupConfig{Name: "standup"}})
+  router := NewRouter()
+
+  tests := []struct {
+    name string
+    eventId string
+    retries []int
+  }{
+    {"retried after a timeout", "Ev1", []int{0, 1, 2}},
+    {"first delivery lost", "Ev2", []int{1, 2}},
+    {"delivered twice without a retry header", "Ev3", []int{0, 0}},
+  }
+  for _, test := range tests {
+    before := countEventJobs(t)
+    for i, retryNum := range test.retries {
+      w := postEvent(router, test.eventId, retryNum)
+      if w.Code != http.StatusOK {
+        t.Fatalf("%s: delivery %d got status %d", test.name, i+1, w.Code)
+      }
+      if i > 0 && w.Body.String() != "Duplicate event" {
+        t.Errorf("%s: delivery %d answered %q, want it skipped as a duplicate", test.name, i+1, w.Body.String())
+      }
+    }
+    if queued := countEventJobs(t) - before; queued != 1 {
+      t.Errorf("%s: %d jobs queued, want 1", test.name, queued)
+    }
+  }
+}
+
+func TestEventsClaimedAgainAfterTTL(t *testing.T) {
+  requireDB(t)
+  CONFIG = NewConfigStore("", &Config{SigningSecret: "secret", Standup: StandupConfig{Name: "standup"}})
+  router := NewRouter()
+
+  postEvent(router, "EvOld", 0)
+  postEvent(router, "EvRecent", 0)
+  if _, err := DB.Exec("UPDATE processed_events SET received_at = $1 WHERE event_id = 'EvOld';",
+    time.Now().Add(-EVENT_TTL-time.Minute)); err != nil {
+    t.Fatalf("aging the event: %v", err)
+  }
+  if _, err := DB.Exec("UPDATE processed_events SET received_at = $1 WHERE event_id = 'EvRecent';",
+    time.Now().Add(-EVENT_TTL+time.Minute)); err != nil {
+    t.Fatalf("aging the event: %v", err)
+  }
+  PruneEvents()
+
+  var ids []string
+  rows, err := DB.Query("SELECT event_id FROM processed_events ORDER BY event_id;")
+  if err != nil {
+    t.Fatalf("listing events: %v", err)
+  }
+  for rows.Next() {
+    var id string
+    rows.Scan(&id)
+    ids = append(ids, id)
+  }
+  rows.Close()
+  if len(ids) != 1 || ids[0] != "EvRecent" {
+    t.Fatalf("events kept after pruning = %v, want only EvRecent", ids)
+  }
+
+  // an id seen longer ago than the TTL is taken as a new event, and one seen within it is still a duplicate
+  if w := postEvent(router, "EvOld", 1); w.Body.String() == "Duplicate event" {
+    t.Errorf("delivery of a pruned event was skipped as a duplicate")
+  }
+  if w := postEvent(router, "EvRecent", 1); w.Body.String() != "Duplicate event" {
+    t.Errorf("delivery of an event within the TTL answered %q, want it skipped as a duplicate", w.Body.String())
+  }
+  if count := countEventJobs(t); count != 3 {
+    t.Errorf("%d jobs queued, want 3", count)
+  }
+}
+
+func TestQueueEventWithoutId(t *testing.T) {
+  requireDB(t)
+  cfg := &Config{Standup: StandupConfig{Name: "standup"}}
+  for i := 0; i < 2; i++ {
+    if queued, err := QueueEvent(cfg, "", json.RawMessage(`{}`)); !queued || err != nil {
+      t.Errorf("QueueEvent() of an event without an id = %t, %v, want it always queued", queued, err)
+    }
+  }
+  if count := countEventJobs(t); count != 2 {
+    t.Errorf("%d jobs queued, want 2", count)
+  }
+}
+
+func TestQueueEventClaimsNothingWhenQueueingFails(t *testing.T) {
+  requireDB(t)
+  cfg := &Config{Standup: StandupConfig{Name: "standup"}}
+  // the job can't be stored, so the claim made in the same transaction is rolled back
+  if _, err := DB.Exec("ALTER TABLE jobs ADD CONSTRAINT no_events CHECK (kind <> 'slack_event');"); err != nil {
+    t.Fatalf("making event jobs fail: %v", err)
+  }
+  _, err := QueueEvent(cfg, "Ev1", json.RawMessage(`{}`))
+  DB.Exec("ALTER TABLE jobs DROP CONSTRAINT no_events;")
+  if err == nil {
+    t.Fatalf("QueueEvent() = nil, want the error queueing the job")
+  }
+  if queued, err := QueueEvent(cfg, "Ev1", json.RawMessage(`{}`)); !queued || err != nil {
+    t.Errorf("QueueEvent() after a failed attempt = %t, %v, want the event queued", queued, err)
+  }
+}
+
+func TestProcessEventIgnoresMessagesWithoutACheckin(t *testing.T) {
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    t.Errorf("unexpected Slack call %s", method)
+    return map[string]interface{}{"ok": false, "error": "unexpected"}
+  })()
+  tests := []struct {
+    name string
+    event SlackEvent
+  }{
+    {"edited", SlackEvent{Type: "message", Subtype: "message_changed"}},
+    {"deleted", SlackEvent{Type: "message", Subtype: "message_deleted"}},
+    {"posted by a bot", SlackEvent{Type: "message", Subtype: "bot_message", Text: "hi"}},
+    {"joined the channel", SlackEvent{Type: "message", Subtype: "channel_join", User: "U1", Text: "joined"}},
+    {"without a user", SlackEvent{Type: "message", Text: "hi"}},
+  }
+  for _, test := range tests {
+    // nothing is looked up or stored for these, so no db is needed
+    if err := ProcessEvent(&Config{ApiToken: "xoxb-test"}, SlackResponse{Event_id: "Ev1", Event: test.event}); err != nil {
+      t.Errorf("%s: ProcessEvent() = %v, want nil", test.name, err)
+    }
+  }
+}
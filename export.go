@@ -0,0 +1,283 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/csv"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io"
+  "net/http"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const EXPORT_CSV = "csv"
+const EXPORT_JSONL = "jsonl"
+const EXPORT_MARKDOWN = "markdown"
+
+// type to contain a single participant of an exported session
+type ExportRecord struct {
+  SessionId int64 `json:"session_id"`
+  Standup string `json:"standup"`
+  OpenedAt time.Time `json:"opened_at"`
+  ClosedAt *time.Time `json:"closed_at"`
+  UserId string `json:"user_id"`
+  Name string `json:"name"`
+  RespondedAt *time.Time `json:"responded_at"`
+  Response string `json:"response"`
+}
+
+// gets the content type used when serving the given export format, or an empty string if it is unknown
+func ExportContentType(format string) string {
+  switch format {
+  case EXPORT_CSV:
+    return "text/csv"
+  case EXPORT_JSONL:
+    return "application/x-ndjson"
+  case EXPORT_MARKDOWN:
+    return "text/markdown"
+  }
+  return ""
+}
+
+// gets every participant of the sessions of the given standup opened between from (inclusive) and to (exclusive),
+// along with their response if they answered
+func GetExportRecords(cfg *Config, standup string, from, to time.Time) (records []ExportRecord, err error) {
+  rows, err := DB.QueryContext(cfg.Context(), `SELECT s.id, s.opened_at, s.closed_at, p.user_id, p.responded_at,
+      COALESCE(r.user_name, ''), COALESCE(r.text, '')
+    FROM sessions s JOIN participants p ON p.session_id = s.id
+    LEFT JOIN responses r ON r.session_id = s.id AND r.user_id = p.user_id
+    WHERE s.standup = $1 AND s.opened_at >= $2 AND s.opened_at < $3 AND NOT p.skipped
+    ORDER BY s.opened_at, s.id, p.user_id;`, standup, from, to)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    record := ExportRecord{Standup: standup}
+    var closedAt, respondedAt sql.NullTime
+    if err = rows.Scan(&record.SessionId, &record.OpenedAt, &closedAt, &record.UserId, &respondedAt,
+      &record.Name, &record.Response); err != nil {
+      return nil, err
+    }
+    if closedAt.Valid {
+      record.ClosedAt = &closedAt.Time
+    }
+    if respondedAt.Valid {
+      record.RespondedAt = &respondedAt.Time
+    }
+    records = append(records, record)
+  }
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  // users who did not answer have no stored name, so look them up once each
+  names := make(map[string]string)
+  for pos, record := range records {
+    if record.Name != "" {
+      continue
+    }
+    name, ok := names[record.UserId]
+    if !ok {
+      name = MapIdsToNames(cfg, []string{record.UserId})[0]
+      names[record.UserId] = name
+    }
+    records[pos].Name = name
+  }
+
+  // the bot is mapped to an empty name and left out of the export
+  filtered := records[:0]
+  for _, record := range records {
+    if record.Name != "" {
+      filtered = append(filtered, record)
+    }
+  }
+  return filtered, nil
+}
+
+// formats an optional time for the CSV export
+func formatExportTime(t *time.Time) string {
+  if t == nil {
+    return ""
+  }
+  return t.In(GetLocation()).Format(time.RFC3339)
+}
+
+// writes the records as CSV with a header row
+func WriteExportCSV(w io.Writer, records []ExportRecord) error {
+  writer := csv.NewWriter(w)
+  writer.Write([]string{"session_id", "standup", "opened_at", "closed_at", "user_id", "name", "responded_at", "response"})
+  for _, record := range records {
+    writer.Write([]string{
+      strconv.FormatInt(record.SessionId, 10),
+      record.Standup,
+      formatExportTime(&record.OpenedAt),
+      formatExportTime(record.ClosedAt),
+      record.UserId,
+      record.Name,
+      formatExportTime(record.RespondedAt),
+      record.Response,
+    })
+  }
+  writer.Flush()
+  return writer.Error()
+}
+
+// writes the records as JSON Lines, one record per line
+func WriteExportJSONL(w io.Writer, records []ExportRecord) error {
+  encoder := json.NewEncoder(w)
+  for _, record := range records {
+    if err := encoder.Encode(record); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// escapes the characters Markdown would read as formatting, or as table cells and code, in text from users
+var MARKDOWN_ESCAPER = strings.NewReplacer(`\`, `\\`, "`", "\\`", "|", `\|`, "*", `\*`, "_", `\_`)
+
+// writes the records as a Markdown digest with a section per session
+// names and responses are escaped, so they show as they were written
+func WriteExportMarkdown(w io.Writer, standup string, from, to time.Time, records []ExportRecord) error {
+  loc := GetLocation()
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "# %s checkins from %s to %s\n", standup, from.Format(DATE_FORMAT), to.AddDate(0, 0, -1).Format(DATE_FORMAT))
+  if len(records) == 0 {
+    builder.WriteString("\nNo checkins were recorded in this range.\n")
+  }
+
+  missing := make([]string, 0)
+  flushMissing := func() {
+    if len(missing) > 0 {
+      fmt.Fprintf(&builder, "\n_Did not check in: %s_\n", strings.Join(missing, ", "))
+    }
+    missing = missing[:0]
+  }
+  var sessionId int64
+  for _, record := range records {
+    if record.SessionId != sessionId {
+      flushMissing()
+      sessionId = record.SessionId
+      fmt.Fprintf(&builder, "\n## %s\n\n", record.OpenedAt.In(loc).Format("Jan 2, 2006 at 3:04pm"))
+    }
+    if record.RespondedAt == nil {
+      missing = append(missing, MARKDOWN_ESCAPER.Replace(record.Name))
+      continue
+    }
+    // keep multi-line responses inside their list item
+    response := strings.Replace(MARKDOWN_ESCAPER.Replace(record.Response), "\n", "\n  ", -1)
+    fmt.Fprintf(&builder, "- **%s** (%s): %s\n", MARKDOWN_ESCAPER.Replace(record.Name), record.RespondedAt.In(loc).Format("3:04pm"), response)
+  }
+  flushMissing()
+
+  _, err := io.WriteString(w, builder.String())
+  return err
+}
+
+// writes the records of the given standup and range in the given format
+func WriteExport(w io.Writer, format, standup string, from, to time.Time, records []ExportRecord) error {
+  switch format {
+  case EXPORT_CSV:
+    return WriteExportCSV(w, records)
+  case EXPORT_JSONL:
+    return WriteExportJSONL(w, records)
+  default:
+    return WriteExportMarkdown(w, standup, from, to, records)
+  }
+}
+
+// the handler for the /api/export endpoint
+// uses the format (default csv), standup, from and to query params
+func ExportApiHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  if !IsApiAuthorized(cfg, r) {
+    http.Error(w, "unauthorized", http.StatusUnauthorized)
+    return
+  }
+
+  query := r.URL.Query()
+  format := query.Get("format")
+  if format == "" {
+    format = EXPORT_CSV
+  }
+  contentType := ExportContentType(format)
+  if contentType == "" {
+    http.Error(w, fmt.Sprintf("unknown export format %q", format), http.StatusBadRequest)
+    return
+  }
+  standup := query.Get("standup")
+  if standup == "" {
+    standup = cfg.Standup.Name
+  }
+  from, to, err := ParseReportRange(query.Get("from"), query.Get("to"))
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  records, err := GetExportRecords(cfg, standup, from, to)
+  if err != nil {
+    cfg.Log().Error("Error building export", "err", err)
+    http.Error(w, "could not build export", http.StatusInternalServerError)
+    return
+  }
+
+  RecordAudit(cfg, "api", "export", SOURCE_API, fmt.Sprintf("%s %s %s to %s", standup, format,
+    from.Format(DATE_FORMAT), to.AddDate(0, 0, -1).Format(DATE_FORMAT)))
+  w.Header().Set("Content-Type", fmt.Sprintf("%s; charset=utf-8", contentType))
+  if err = WriteExport(w, format, standup, from, to, records); err != nil {
+    cfg.Log().Error("Error writing export", "err", err)
+  }
+}
+
+// runs the export CLI subcommand with the given args and returns the exit code
+// ex: ./main export -format markdown -from 2020-03-01 -to 2020-03-31 -out march.md
+func RunExportCommand(cfg *Config, args []string) int {
+  flags := flag.NewFlagSet("export", flag.ContinueOnError)
+  format := flags.String("format", EXPORT_CSV, "export format: csv, jsonl or markdown")
+  standup := flags.String("standup", cfg.Standup.Name, "standup to export")
+  fromStr := flags.String("from", "", "first day to export (YYYY-MM-DD), defaults to 30 days before to")
+  toStr := flags.String("to", "", "last day to export (YYYY-MM-DD), defaults to today")
+  out := flags.String("out", "", "file to write to, defaults to stdout")
+  if err := flags.Parse(args); err != nil {
+    return 2
+  }
+
+  if ExportContentType(*format) == "" {
+    fmt.Fprintf(os.Stderr, "unknown export format %q, expected one of csv, jsonl, markdown\n", *format)
+    return 2
+  }
+  from, to, err := ParseReportRange(*fromStr, *toStr)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return 2
+  }
+
+  records, err := GetExportRecords(cfg, *standup, from, to)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return 1
+  }
+
+  var w io.Writer = os.Stdout
+  if *out != "" {
+    file, err := os.Create(*out)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, err)
+      return 1
+    }
+    defer file.Close()
+    w = file
+  }
+
+  if err = WriteExport(w, *format, *standup, from, to, records); err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return 1
+  }
+  return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+  "bytes"
+  "flag"
+  "io/ioutil"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+var UPDATE_GOLDEN = flag.Bool("update", false, "rewrite the golden files in testdata with the current output")
+
+// compares the output with the golden file of the given name in testdata
+func checkGolden(t *testing.T, name string, got []byte) {
+  t.Helper()
+  path := filepath.Join("testdata", name)
+  if *UPDATE_GOLDEN {
+    if err := ioutil.WriteFile(path, got, 0644); err != nil {
+      t.Fatalf("writing %s: %v", path, err)
+    }
+  }
+  want, err := ioutil.ReadFile(path)
+  if err != nil {
+    t.Fatalf("reading %s: %v", path, err)
+  }
+  if !bytes.Equal(got, want) {
+    t.Errorf("%s differs from the output:\n%s", path, got)
+  }
+}
+
+// gets records for two sessions, with responses needing to be escaped or quoted
+func exportRecords() []ExportRecord {
+  loc := GetLocation()
+  at := func(day, hour, minute int) *time.Time {
+    t := time.Date(2024, 5, day, hour, minute, 0, 0, loc)
+    return &t
+  }
+  return []ExportRecord{
+    {SessionId: 1, Standup: "standup", OpenedAt: *at(6, 9, 0), ClosedAt: at(6, 17, 0), UserId: "U1", Name: "Ada Lovelace",
+      RespondedAt: at(6, 9, 12), Response: "Fix the `parser` | then *ship*\nand review snake_case names"},
+    {SessionId: 1, Standup: "standup", OpenedAt: *at(6, 9, 0), ClosedAt: at(6, 17, 0), UserId: "U2", Name: "Grace_Hopper"},
+    {SessionId: 2, Standup: "standup", OpenedAt: *at(7, 9, 0), UserId: "U1", Name: "Ada Lovelace",
+      RespondedAt: at(7, 10, 5), Response: `Said "hi", with a comma \ backslash`},
+  }
+}
+
+func TestWriteExportCSV(t *testing.T) {
+  var buf bytes.Buffer
+  if err := WriteExportCSV(&buf, exportRecords()); err != nil {
+    t.Fatalf("WriteExportCSV() = %v", err)
+  }
+  checkGolden(t, "export.csv", buf.Bytes())
+}
+
+func TestWriteExportJSONL(t *testing.T) {
+  var buf bytes.Buffer
+  if err := WriteExportJSONL(&buf, exportRecords()); err != nil {
+    t.Fatalf("WriteExportJSONL() = %v", err)
+  }
+  checkGolden(t, "export.jsonl", buf.Bytes())
+}
+
+func TestWriteExportMarkdown(t *testing.T) {
+  loc := GetLocation()
+  from, to := time.Date(2024, 5, 6, 0, 0, 0, 0, loc), time.Date(2024, 5, 8, 0, 0, 0, 0, loc)
+  var buf bytes.Buffer
+  if err := WriteExportMarkdown(&buf, "standup", from, to, exportRecords()); err != nil {
+    t.Fatalf("WriteExportMarkdown() = %v", err)
+  }
+  checkGolden(t, "export.md", buf.Bytes())
+
+  buf.Reset()
+  if err := WriteExportMarkdown(&buf, "standup", from, to, nil); err != nil {
+    t.Fatalf("WriteExportMarkdown() = %v", err)
+  }
+  checkGolden(t, "export_empty.md", buf.Bytes())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// type to contain a teammate mentioned in a checkin response
+type Followup struct {
+  Id int64
+  FromUser string
+  ToUser string
+  Permalink string
+  Text string
+  CreatedAt time.Time
+}
+
+// creates the followups table if it does not exist yet
+func FollowupSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS followups (
+      id SERIAL PRIMARY KEY,
+      standup TEXT NOT NULL,
+      response_id INTEGER REFERENCES responses(id),
+      from_user TEXT NOT NULL,
+      to_user TEXT NOT NULL,
+      permalink TEXT NOT NULL,
+      text TEXT NOT NULL,
+      created_at TIMESTAMPTZ NOT NULL,
+      resolved_at TIMESTAMPTZ
+    );`); err != nil {
+    LOG.Error("Error creating followups table", "err", err)
+  }
+}
+
+// get the permalink of the message with the given ts in the given channel
+func GetPermalink(cfg *Config, channelId, ts string) (permalink string, err error) {
+  url := "chat.getPermalink"
+  params := make(map[string]string)
+  params["channel"] = channelId
+  params["message_ts"] = ts
+  res, err := PerformGet(cfg, url, nil, params, true)
+  body, err := HandleResponse(cfg, res, err, false)
+  if err == nil && !body.Ok {
+    err = fmt.Errorf("chat.getPermalink failed: %s", body.Error)
+  }
+  return body.Permalink, err
+}
+
+// records a followup for every teammate mentioned in a response forwarded to the given thread reply,
+// and notifies each of them with a link to the reply
+// bots and deactivated users are left out, a user who can't be looked up being taken as a teammate
+func TrackFollowups(cfg *Config, fromUser, name, text string, responseId int64, replyTs string) {
+  mentions := ParseMentions(text)
+  if len(mentions) == 0 {
+    return
+  }
+
+  permalink, err := GetPermalink(cfg, GetSessionChannelId(cfg), replyTs)
+  if err != nil {
+    cfg.Log().Error("Error getting permalink for followups", "err", err)
+  }
+
+  for _, toUser := range mentions {
+    if toUser == fromUser {
+      continue
+    }
+    if user, err := LookupUser(cfg, toUser); err != nil {
+      cfg.Log().Warn("Could not look up mentioned user", "user", toUser, "err", err)
+    } else if user.IsBotUser() || user.Deleted {
+      continue
+    }
+    if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO followups (standup, response_id, from_user, to_user, permalink, text, created_at)
+      VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7);`,
+      cfg.Standup.Name, responseId, fromUser, toUser, permalink, text, time.Now()); err != nil {
+      cfg.Log().Error("Error inserting followup", "err", err)
+    }
+
+    message := fmt.Sprintf("Hey! %s mentioned you in their checkin", name)
+    if permalink != "" {
+      message = fmt.Sprintf("%s: %s", message, permalink)
+    }
+    MessageUser(cfg, toUser, fmt.Sprintf("%s\nUse `/standup followups` to see everything you've been asked about.", message))
+  }
+}
+
+// gets the open followups mentioning the given user, or raised by them if raised is true
+func GetOpenFollowups(cfg *Config, userId string, raised bool) (followups []Followup, err error) {
+  column := "to_user"
+  if raised {
+    column = "from_user"
+  }
+  rows, err := DB.QueryContext(cfg.Context(), fmt.Sprintf(`SELECT id, from_user, to_user, permalink, text, created_at
+    FROM followups WHERE standup = $1 AND %s = $2 AND resolved_at IS NULL ORDER BY created_at, id;`, column),
+    cfg.Standup.Name, userId)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var followup Followup
+    if err = rows.Scan(&followup.Id, &followup.FromUser, &followup.ToUser, &followup.Permalink,
+      &followup.Text, &followup.CreatedAt); err != nil {
+      return nil, err
+    }
+    followups = append(followups, followup)
+  }
+  return followups, rows.Err()
+}
+
+// marks a followup as resolved, returning false if it was not open or does not involve the user
+func ResolveFollowup(cfg *Config, id int64, userId string) bool {
+  res, err := DB.ExecContext(cfg.Context(), `UPDATE followups SET resolved_at = $1
+    WHERE id = $2 AND standup = $3 AND (to_user = $4 OR from_user = $4) AND resolved_at IS NULL;`,
+    time.Now(), id, cfg.Standup.Name, userId)
+  if err != nil {
+    cfg.Log().Error("Error resolving followup", "err", err)
+    return false
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff != 0
+}
+
+// formats a followup as a line of a Slack message
+func FormatFollowup(followup Followup) string {
+  line := fmt.Sprintf("#%d <@%s> → <@%s> on %s", followup.Id, followup.FromUser, followup.ToUser,
+    followup.CreatedAt.In(GetLocation()).Format("Jan 2"))
+  if followup.Permalink != "" {
+    line = fmt.Sprintf("%s (<%s|view>)", line, followup.Permalink)
+  }
+  return line
+}
+
+// runs the followups command, listing the open followups mentioning or raised by the user,
+// or resolving one when the args are "done <id>"
+func RunFollowupsCommand(cfg *Config, userId string, args []string) string {
+  if len(args) == 2 && args[0] == "done" {
+    id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+    if err != nil {
+      return fmt.Sprintf("%s is not a followup id", args[1])
+    }
+    if !ResolveFollowup(cfg, id, userId) {
+      return fmt.Sprintf("You have no open followup #%d", id)
+    }
+    return fmt.Sprintf("Followup #%d done", id)
+  }
+  if len(args) != 0 {
+    return "Usage: `followups` to list your open followups, `followups done <id>` to close one"
+  }
+
+  builder := strings.Builder{}
+  for _, raised := range []bool{false, true} {
+    followups, err := GetOpenFollowups(cfg, userId, raised)
+    if err != nil {
+      cfg.Log().Error("Error getting followups", "err", err)
+      return "Could not get your followups, try again later"
+    }
+    if len(followups) == 0 {
+      continue
+    }
+    if raised {
+      builder.WriteString("Asks you raised:\n")
+    } else {
+      builder.WriteString("Asks for you:\n")
+    }
+    for _, followup := range followups {
+      builder.WriteString(FormatFollowup(followup))
+      builder.WriteString("\n")
+    }
+  }
+  if builder.Len() == 0 {
+    return "You have no open followups :tada:"
+  }
+  return builder.String()
+}
+
+// the handler for the /followups endpoint
+func FollowupsHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if !HasPermission(cfg, req.UserId, PERM_CHECKIN) {
+    w.Write([]byte("You don't have permission to view followups"))
+    return
+  }
+  w.Write([]byte(RunFollowupsCommand(cfg, req.UserId, strings.Fields(req.Text))))
+}
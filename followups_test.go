@@ -0,0 +1,61 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "testing"
+)
+
+func TestTrackFollowupsSkipsBots(t *testing.T) {
+  requireDB(t)
+  DM_CHANNELS = make(map[string]string)
+  var dms []string
+  _, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    params := slackParams(r)
+    switch SlackMethod(r) {
+    case "users.info":
+      switch params["user"] {
+      case "UBOT":
+        return http.StatusOK, `{"ok":true,"user":{"id":"UBOT","real_name":"Deploy Bot","is_bot":true}}`, nil
+      case "UGONE":
+        return http.StatusOK, `{"ok":true,"user":{"id":"UGONE","real_name":"Former","deleted":true}}`, nil
+      case "UFAIL":
+        return http.StatusOK, `{"ok":false,"error":"fatal_error"}`, nil
+      }
+      return http.StatusOK, fmt.Sprintf(`{"ok":true,"user":{"id":%q,"real_name":"User %s"}}`, params["user"], params["user"]), nil
+    case "chat.getPermalink":
+      return http.StatusOK, `{"ok":true,"permalink":"https://example.slack.com/archives/C1/p1000"}`, nil
+    case "conversations.open":
+      return http.StatusOK, fmt.Sprintf(`{"ok":true,"channel":{"id":"D%s"}}`, params["users"]), nil
+    case "chat.postMessage":
+      dms = append(dms, params["channel"])
+      return http.StatusOK, `{"ok":true,"ts":"1000.2"}`, nil
+    }
+    return http.StatusOK, `{"ok":false,"error":"unknown_method"}`, nil
+  })
+  defer restore()
+  cfg := sessionConfig()
+  StartSession(cfg, "C1", "1000.1", []string{"U1"})
+
+  TrackFollowups(cfg, "U1", "User U1", "ask <@U2>, <@UBOT>, <@UGONE>, <@UFAIL> and <@U1>", 0, "1000.2")
+
+  // a user who can't be looked up is still a teammate
+  want := map[string]bool{"DU2": true, "DUFAIL": true}
+  if len(dms) != len(want) || !want[dms[0]] || !want[dms[1]] {
+    t.Errorf("DM'd %v, want only DU2 and DUFAIL", dms)
+  }
+  var toUsers []string
+  rows, err := DB.Query("SELECT to_user FROM followups ORDER BY to_user;")
+  if err != nil {
+    t.Fatalf("listing followups: %v", err)
+  }
+  for rows.Next() {
+    var toUser string
+    rows.Scan(&toUser)
+    toUsers = append(toUsers, toUser)
+  }
+  rows.Close()
+  if fmt.Sprint(toUsers) != "[U2 UFAIL]" {
+    t.Errorf("followups to %v, want U2 and UFAIL", toUsers)
+  }
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// how long each readiness check can take before it fails
+const READY_CHECK_TIMEOUT = 3 * time.Second
+
+// how long a successful Slack auth check is reused, so probes don't run into Slack's rate limits
+const SLACK_AUTH_CACHE = time.Minute
+
+// the tables and columns created at startup, as table or table.column, which must exist to be ready
+var REQUIRED_SCHEMA = []string{
+  "sessions", "participants", "responses", "blockers", "followups", "out_of_office", "roles", "audit_log",
+  "standup_settings", "processed_events", "jobs", "jobs.request_id", "jobs.trace_parent", "leader_lease",
+  "dm_channels", "deliveries", "user_directory", "channels",
+}
+
+// type to contain the result of a readiness check
+type CheckResult struct {
+  Ok bool `json:"ok"`
+  Error string `json:"error,omitempty"`
+  Duration string `json:"duration"`
+}
+
+// type to contain the response of the readiness endpoint
+type Readiness struct {
+  Ready bool `json:"ready"`
+  Checks map[string]CheckResult `json:"checks"`
+}
+
+var SLACK_AUTH_MTX = sync.Mutex{}
+var SLACK_AUTH_CHECKED_AT time.Time
+
+// checks the db can be reached
+func CheckDatabase(cfg *Config) error {
+  ctx, cancel := context.WithTimeout(cfg.Context(), READY_CHECK_TIMEOUT)
+  defer cancel()
+  return DB.PingContext(ctx)
+}
+
+// checks the API token is accepted by Slack, reusing a success for SLACK_AUTH_CACHE
+// the lock is only held to read and record the last success, so a slow Slack can't queue up probes behind it
+func CheckSlackAuth(cfg *Config) error {
+  SLACK_AUTH_MTX.Lock()
+  checkedAt := SLACK_AUTH_CHECKED_AT
+  SLACK_AUTH_MTX.Unlock()
+  if time.Since(checkedAt) < SLACK_AUTH_CACHE {
+    return nil
+  }
+
+  ctx, cancel := context.WithTimeout(cfg.Context(), READY_CHECK_TIMEOUT)
+  defer cancel()
+  res, err := PerformPost(cfg.WithContext(ctx), "auth.test", nil, nil, true)
+  body, err := HandleResponse(cfg, res, err, false)
+  if err != nil {
+    return err
+  }
+  if !body.Ok {
+    return fmt.Errorf("auth.test failed: %s", body.Error)
+  }
+  SLACK_AUTH_MTX.Lock()
+  SLACK_AUTH_CHECKED_AT = time.Now()
+  SLACK_AUTH_MTX.Unlock()
+  return nil
+}
+
+// checks every table and column in REQUIRED_SCHEMA exists
+func CheckSchema(cfg *Config) error {
+  ctx, cancel := context.WithTimeout(cfg.Context(), READY_CHECK_TIMEOUT)
+  defer cancel()
+  rows, err := DB.QueryContext(ctx,
+    "SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema();")
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+  found := make(map[string]bool)
+  for rows.Next() {
+    var table, column string
+    if err = rows.Scan(&table, &column); err != nil {
+      return err
+    }
+    found[table] = true
+    found[fmt.Sprintf("%s.%s", table, column)] = true
+  }
+  if err = rows.Err(); err != nil {
+    return err
+  }
+
+  missing := make([]string, 0)
+  for _, name := range REQUIRED_SCHEMA {
+    if !found[name] {
+      missing = append(missing, name)
+    }
+  }
+  if len(missing) > 0 {
+    return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+  }
+  return nil
+}
+
+// checks the background work of the bot has been started
+func CheckBackground(cfg *Config) error {
+  if !SCHEDULER.Running() {
+    return fmt.Errorf("scheduler is not running")
+  }
+  if !QUEUE.Running() {
+    return fmt.Errorf("job queue is not running")
+  }
+  if cfg.SocketMode && !SOCKET.Connected() {
+    return fmt.Errorf("socket mode is not connected")
+  }
+  return nil
+}
+
+// runs the check, failing it if it takes longer than READY_CHECK_TIMEOUT
+func runCheck(cfg *Config, check func(cfg *Config) error) CheckResult {
+  start := time.Now()
+  done := make(chan error, 1)
+  go func() {
+    done <- check(cfg)
+  }()
+
+  var err error
+  select {
+  case err = <-done:
+  case <-time.After(READY_CHECK_TIMEOUT):
+    err = fmt.Errorf("timed out after %s", READY_CHECK_TIMEOUT)
+  }
+  result := CheckResult{Ok: err == nil, Duration: time.Since(start).Round(time.Millisecond).String()}
+  if err != nil {
+    result.Error = err.Error()
+  }
+  return result
+}
+
+// runs every readiness check at the same time
+func GetReadiness(cfg *Config) Readiness {
+  checks := map[string]func(cfg *Config) error{
+    "database": CheckDatabase,
+    "slack": CheckSlackAuth,
+    "schema": CheckSchema,
+    "background": CheckBackground,
+  }
+
+  readiness := Readiness{Ready: true, Checks: make(map[string]CheckResult)}
+  mtx := sync.Mutex{}
+  wg := sync.WaitGroup{}
+  for name, check := range checks {
+    wg.Add(1)
+    go func(name string, check func(cfg *Config) error) {
+      defer wg.Done()
+      result := runCheck(cfg, check)
+      mtx.Lock()
+      defer mtx.Unlock()
+      readiness.Checks[name] = result
+      readiness.Ready = readiness.Ready && result.Ok
+    }(name, check)
+  }
+  wg.Wait()
+  return readiness
+}
+
+// the handler for the /healthz endpoint
+// responds as long as the process is serving requests
+func HealthHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  w.Write([]byte("ok"))
+}
+
+// the handler for the /readyz endpoint
+// responds with the readiness checks as JSON, with a 503 status if any of them failed
+func ReadyHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  readiness := GetReadiness(cfg)
+  for name, result := range readiness.Checks {
+    if !result.Ok {
+      cfg.Log().Warn("Readiness check failed", "check", name, "err", result.Error)
+    }
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  if !readiness.Ready {
+    w.WriteHeader(http.StatusServiceUnavailable)
+  }
+  if err := json.NewEncoder(w).Encode(readiness); err != nil {
+    cfg.Log().Error("Error writing readiness", "err", err)
+  }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+  "net/http"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+func TestCheckSlackAuthTimesOut(t *testing.T) {
+  SLACK_AUTH_CHECKED_AT = time.Time{}
+  defer func() { SLACK_AUTH_CHECKED_AT = time.Time{} }()
+  var calls int32
+  release := make(chan struct{})
+  defer close(release)
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    // the first call hangs until the check gives up on it
+    if atomic.AddInt32(&calls, 1) == 1 {
+      select {
+      case <-r.Context().Done():
+      case <-release:
+      }
+    }
+    return map[string]interface{}{"ok": true}
+  })()
+  cfg := &Config{ApiToken: "xoxb-test"}
+
+  start := time.Now()
+  hung := make(chan error, 1)
+  go func() {
+    hung <- CheckSlackAuth(cfg)
+  }()
+  for atomic.LoadInt32(&calls) == 0 {
+    time.Sleep(10 * time.Millisecond)
+  }
+
+  // a probe made while Slack is slow to answer another isn't held up behind it
+  done := make(chan error, 1)
+  go func() {
+    done <- CheckSlackAuth(cfg)
+  }()
+  select {
+  case err := <-done:
+    if err != nil {
+      t.Errorf("CheckSlackAuth() while another check was waiting = %v, want nil", err)
+    }
+  case <-time.After(time.Second):
+    t.Fatalf("CheckSlackAuth() was held up by the check waiting on Slack")
+  }
+
+  select {
+  case err := <-hung:
+    if err == nil {
+      t.Errorf("CheckSlackAuth() of a call Slack didn't answer = nil, want an error")
+    }
+    if elapsed := time.Since(start); elapsed < READY_CHECK_TIMEOUT {
+      t.Errorf("CheckSlackAuth() gave up after %s, before READY_CHECK_TIMEOUT", elapsed)
+    }
+  case <-time.After(READY_CHECK_TIMEOUT + time.Second):
+    t.Fatalf("CheckSlackAuth() kept waiting on Slack after READY_CHECK_TIMEOUT")
+  }
+}
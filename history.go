@@ -0,0 +1,428 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+  "time"
+)
+
+const DATE_FORMAT = "2006-01-02"
+const DEFAULT_REPORT_DAYS = 30
+
+// how long a session can be left open before the next open closes it, as its close was likely missed
+const STALE_SESSION_AFTER = 12 * time.Hour
+
+// type to contain participation stats for a single user over a report range
+type UserStats struct {
+  UserId string `json:"user_id"`
+  Name string `json:"name"`
+  Expected int `json:"expected"`
+  Answered int `json:"answered"`
+  OnTime int `json:"on_time"`
+  OnTimeRate float64 `json:"on_time_rate"`
+  CurrentStreak int `json:"current_streak"`
+  LongestStreak int `json:"longest_streak"`
+}
+
+// type to contain participation for a single session of a standup
+type SessionTrend struct {
+  SessionId int64 `json:"session_id"`
+  OpenedAt time.Time `json:"opened_at"`
+  Expected int `json:"expected"`
+  Answered int `json:"answered"`
+  ResponseRate float64 `json:"response_rate"`
+}
+
+// type to contain a participation report for a standup over a date range
+type ParticipationReport struct {
+  Standup string `json:"standup"`
+  From time.Time `json:"from"`
+  To time.Time `json:"to"`
+  Users []UserStats `json:"users"`
+  Trend []SessionTrend `json:"trend"`
+}
+
+// gets the location used for displaying and parsing dates
+func GetLocation() *time.Location {
+  loc, err := time.LoadLocation("America/New_York")
+  if err != nil {
+    return time.UTC
+  }
+  return loc
+}
+
+// creates the history tables if they do not exist yet
+// unlike DBSetup, these tables are never dropped
+func HistorySetup() {
+  stmts := []string{
+    `CREATE TABLE IF NOT EXISTS sessions (
+      id SERIAL PRIMARY KEY,
+      standup TEXT NOT NULL,
+      channel_id TEXT NOT NULL,
+      thread_id TEXT NOT NULL,
+      opened_at TIMESTAMPTZ NOT NULL,
+      reminded_at TIMESTAMPTZ,
+      closed_at TIMESTAMPTZ
+    );`,
+    `CREATE TABLE IF NOT EXISTS participants (
+      session_id INTEGER NOT NULL REFERENCES sessions(id),
+      user_id TEXT NOT NULL,
+      responded_at TIMESTAMPTZ,
+      PRIMARY KEY (session_id, user_id)
+    );`,
+    `CREATE TABLE IF NOT EXISTS responses (
+      id SERIAL PRIMARY KEY,
+      session_id INTEGER NOT NULL REFERENCES sessions(id),
+      user_id TEXT NOT NULL,
+      user_name TEXT NOT NULL,
+      text TEXT NOT NULL,
+      created_at TIMESTAMPTZ NOT NULL
+    );`,
+  }
+  for _, stmt := range stmts {
+    if _, err := DB.Exec(stmt); err != nil {
+      LOG.Error("Error creating history tables", "err", err)
+      return
+    }
+  }
+}
+
+// gets the id of the currently open session for the standup, or 0 if there is none
+func GetCurrentSessionId(cfg *Config) (id int64) {
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT id FROM sessions WHERE standup = $1 AND closed_at IS NULL ORDER BY opened_at DESC LIMIT 1;",
+    cfg.Standup.Name).Scan(&id)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting current session", "err", err)
+  }
+  return id
+}
+
+// gets when the open session of the standup was opened
+// returns false if there is no open session
+func GetSessionOpenedAt(cfg *Config) (openedAt time.Time, ok bool) {
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT opened_at FROM sessions WHERE standup = $1 AND closed_at IS NULL ORDER BY opened_at DESC LIMIT 1;",
+    cfg.Standup.Name).Scan(&openedAt)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting session", "err", err)
+  }
+  return openedAt, err == nil
+}
+
+// gets the channel the open session of the standup was posted in
+// replicas resolve the channel on their own, so the one recorded with the session is used for its thread
+// falls back to the configured channel if there is no open session
+func GetSessionChannelId(cfg *Config) string {
+  var channelId string
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT channel_id FROM sessions WHERE standup = $1 AND closed_at IS NULL ORDER BY opened_at DESC LIMIT 1;",
+    cfg.Standup.Name).Scan(&channelId)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting session channel", "err", err)
+  }
+  if channelId == "" {
+    return cfg.Standup.ChannelId
+  }
+  return channelId
+}
+
+// records a new session with the given expected users
+// any session left open for the standup is closed first
+func StartSession(cfg *Config, channelId, threadId string, users []string) int64 {
+  EndSession(cfg)
+
+  var id int64
+  err := DB.QueryRowContext(cfg.Context(),
+    "INSERT INTO sessions (standup, channel_id, thread_id, opened_at) VALUES ($1, $2, $3, $4) RETURNING id;",
+    cfg.Standup.Name, channelId, threadId, time.Now()).Scan(&id)
+  if err != nil {
+    cfg.Log().Error("Error inserting session", "err", err)
+    return 0
+  }
+
+  for _, user := range users {
+    if _, err := DB.ExecContext(cfg.Context(),
+      "INSERT INTO participants (session_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;",
+      id, user); err != nil {
+      cfg.Log().Error("Error inserting participant", "err", err)
+    }
+  }
+  return id
+}
+
+// marks the current session as reminded, keeping the time of the first reminder
+func MarkSessionReminded(cfg *Config) {
+  if _, err := DB.ExecContext(cfg.Context(),
+    "UPDATE sessions SET reminded_at = $1 WHERE standup = $2 AND closed_at IS NULL AND reminded_at IS NULL;",
+    time.Now(), cfg.Standup.Name); err != nil {
+    cfg.Log().Error("Error marking session reminded", "err", err)
+  }
+}
+
+// records that the given user answered the current session with the given text
+// returns the id of the stored response, or 0 if it was not stored
+func RecordResponse(cfg *Config, userId, name, text string) (id int64) {
+  sessionId := GetCurrentSessionId(cfg)
+  if sessionId == 0 {
+    return 0
+  }
+  now := time.Now()
+  if _, err := DB.ExecContext(cfg.Context(),
+    "UPDATE participants SET responded_at = $1 WHERE session_id = $2 AND user_id = $3 AND responded_at IS NULL;",
+    now, sessionId, userId); err != nil {
+    cfg.Log().Error("Error recording response", "err", err)
+  }
+  if err := DB.QueryRowContext(cfg.Context(),
+    "INSERT INTO responses (session_id, user_id, user_name, text, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id;",
+    sessionId, userId, name, text, now).Scan(&id); err != nil {
+    cfg.Log().Error("Error inserting response", "err", err)
+  }
+  return id
+}
+
+// closes the currently open session for the standup, if any
+func EndSession(cfg *Config) {
+  if _, err := DB.ExecContext(cfg.Context(),
+    "UPDATE sessions SET closed_at = $1 WHERE standup = $2 AND closed_at IS NULL;",
+    time.Now(), cfg.Standup.Name); err != nil {
+    cfg.Log().Error("Error closing session", "err", err)
+  }
+}
+
+// builds the participation report for the standup between from (inclusive) and to (exclusive)
+// a response is on time if it was received before the first reminder of its session
+// streaks count consecutive expected sessions answered, ignoring a still open unanswered session
+func GetParticipationReport(cfg *Config, from, to time.Time) (report ParticipationReport, err error) {
+  report = ParticipationReport{Standup: cfg.Standup.Name, From: from, To: to}
+
+  rows, err := DB.QueryContext(cfg.Context(), `SELECT s.id, s.opened_at, s.reminded_at, s.closed_at, p.user_id, p.responded_at,
+    p.skipped
+    FROM sessions s JOIN participants p ON p.session_id = s.id
+    WHERE s.standup = $1 AND s.opened_at >= $2 AND s.opened_at < $3
+    ORDER BY s.opened_at, s.id;`, cfg.Standup.Name, from, to)
+  if err != nil {
+    return report, err
+  }
+  defer rows.Close()
+
+  var participants []participationRow
+  for rows.Next() {
+    var row participationRow
+    if err = rows.Scan(&row.SessionId, &row.OpenedAt, &row.RemindedAt, &row.ClosedAt, &row.UserId, &row.RespondedAt,
+      &row.Skipped); err != nil {
+      return report, err
+    }
+    participants = append(participants, row)
+  }
+  if err = rows.Err(); err != nil {
+    return report, err
+  }
+
+  stats, order := tallyParticipation(&report, participants)
+  names := MapIdsToNames(cfg, append([]string{}, order...))
+  for pos, userId := range order {
+    // the bot is mapped to an empty name and left out of the report
+    if names[pos] == "" {
+      continue
+    }
+    user := stats[userId]
+    user.Name = names[pos]
+    report.Users = append(report.Users, *user)
+  }
+  sort.Slice(report.Users, func(i, j int) bool {
+    return report.Users[i].Name < report.Users[j].Name
+  })
+  return report, nil
+}
+
+// type to contain a participant of a session, as read for a participation report
+type participationRow struct {
+  SessionId int64
+  OpenedAt time.Time
+  RemindedAt, ClosedAt, RespondedAt sql.NullTime
+  UserId string
+  Skipped bool
+}
+
+// adds the trend of each session to the report, and gets the stats of each user in the order they first took part
+// the rows must be ordered by when their session opened
+// sessions skipped, or left out of while out of office, neither count nor break a streak,
+// and an unanswered session that is still open has not been missed yet
+func tallyParticipation(report *ParticipationReport, rows []participationRow) (stats map[string]*UserStats, order []string) {
+  type streak struct {
+    current, longest int
+  }
+  stats = make(map[string]*UserStats)
+  streaks := make(map[string]*streak)
+  order = make([]string, 0)
+  trendIdx := make(map[int64]int)
+
+  for _, row := range rows {
+    if row.Skipped {
+      continue
+    }
+    idx, ok := trendIdx[row.SessionId]
+    if !ok {
+      idx = len(report.Trend)
+      trendIdx[row.SessionId] = idx
+      report.Trend = append(report.Trend, SessionTrend{SessionId: row.SessionId, OpenedAt: row.OpenedAt})
+    }
+
+    user, ok := stats[row.UserId]
+    if !ok {
+      user = &UserStats{UserId: row.UserId}
+      stats[row.UserId] = user
+      streaks[row.UserId] = &streak{}
+      order = append(order, row.UserId)
+    }
+
+    if !row.RespondedAt.Valid && !row.ClosedAt.Valid {
+      continue
+    }
+
+    report.Trend[idx].Expected++
+    user.Expected++
+    s := streaks[row.UserId]
+    if row.RespondedAt.Valid {
+      report.Trend[idx].Answered++
+      user.Answered++
+      if !row.RemindedAt.Valid || row.RespondedAt.Time.Before(row.RemindedAt.Time) {
+        user.OnTime++
+      }
+      s.current++
+      if s.current > s.longest {
+        s.longest = s.current
+      }
+    } else {
+      s.current = 0
+    }
+  }
+
+  for pos := range report.Trend {
+    if report.Trend[pos].Expected > 0 {
+      report.Trend[pos].ResponseRate = float64(report.Trend[pos].Answered) / float64(report.Trend[pos].Expected)
+    }
+  }
+  for userId, user := range stats {
+    user.CurrentStreak = streaks[userId].current
+    user.LongestStreak = streaks[userId].longest
+    if user.Expected > 0 {
+      user.OnTimeRate = float64(user.OnTime) / float64(user.Expected)
+    }
+  }
+  return stats, order
+}
+
+// parses an optional from and to date, defaulting to the last DEFAULT_REPORT_DAYS days
+// the returned range covers the whole of the to date
+func ParseReportRange(fromStr, toStr string) (from, to time.Time, err error) {
+  loc := GetLocation()
+  now := time.Now().In(loc)
+  today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+  to = today.AddDate(0, 0, 1)
+  from = to.AddDate(0, 0, -DEFAULT_REPORT_DAYS)
+
+  if toStr != "" {
+    parsed, err := time.ParseInLocation(DATE_FORMAT, toStr, loc)
+    if err != nil {
+      return from, to, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", toStr)
+    }
+    to = parsed.AddDate(0, 0, 1)
+    from = to.AddDate(0, 0, -DEFAULT_REPORT_DAYS)
+  }
+  if fromStr != "" {
+    parsed, err := time.ParseInLocation(DATE_FORMAT, fromStr, loc)
+    if err != nil {
+      return from, to, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", fromStr)
+    }
+    from = parsed
+  }
+  if !from.Before(to) {
+    return from, to, fmt.Errorf("from date must be before to date")
+  }
+  return from, to, nil
+}
+
+// formats a participation report as a Slack message
+func FormatReport(report ParticipationReport) string {
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "Participation for %s from %s to %s (%d sessions)\n",
+    report.Standup, report.From.Format(DATE_FORMAT), report.To.AddDate(0, 0, -1).Format(DATE_FORMAT), len(report.Trend))
+  if len(report.Users) == 0 {
+    builder.WriteString("No checkins were recorded in this range.")
+    return builder.String()
+  }
+  for _, user := range report.Users {
+    fmt.Fprintf(&builder, "• %s: %d/%d answered, %.0f%% on time, streak %d (longest %d)\n",
+      user.Name, user.Answered, user.Expected, user.OnTimeRate*100, user.CurrentStreak, user.LongestStreak)
+  }
+  return builder.String()
+}
+
+// runs the report command, taking an optional from and to date (YYYY-MM-DD)
+func RunReportCommand(cfg *Config, args []string) string {
+  var fromStr, toStr string
+  if len(args) > 0 {
+    fromStr = args[0]
+  }
+  if len(args) > 1 {
+    toStr = args[1]
+  }
+  from, to, err := ParseReportRange(fromStr, toStr)
+  if err != nil {
+    return err.Error()
+  }
+
+  report, err := GetParticipationReport(cfg, from, to)
+  if err != nil {
+    cfg.Log().Error("Error building report", "err", err)
+    return "Could not build the report, try again later"
+  }
+  return FormatReport(report)
+}
+
+// the handler for the /report endpoint
+// takes an optional from and to date (YYYY-MM-DD) separated by a space as the command text
+// if the given user_id is not allowed to view reports, then the function does not proceed
+func ReportHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if !HasPermission(cfg, req.UserId, PERM_VIEW_REPORTS) {
+    w.Write([]byte("You are not allowed to view reports"))
+    return
+  }
+  w.Write([]byte(RunReportCommand(cfg, strings.Fields(req.Text))))
+}
+
+// the handler for the /api/report endpoint
+// responds with the participation report as JSON, using the from and to query params
+func ReportApiHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  if !IsApiAuthorized(cfg, r) {
+    http.Error(w, "unauthorized", http.StatusUnauthorized)
+    return
+  }
+
+  query := r.URL.Query()
+  from, to, err := ParseReportRange(query.Get("from"), query.Get("to"))
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  report, err := GetParticipationReport(cfg, from, to)
+  if err != nil {
+    cfg.Log().Error("Error building report", "err", err)
+    http.Error(w, "could not build report", http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(report)
+}
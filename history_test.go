@@ -0,0 +1,102 @@
+package main
+
+import (
+  "database/sql"
+  "testing"
+  "time"
+)
+
+// builds the participation of a user in a session, opened on the given day of May 2024
+// answered is when they responded in hours after the open, or -1 if they didn't
+func participant(sessionId int64, userId string, answered int, closed, reminded, skipped bool) participationRow {
+  openedAt := time.Date(2024, 5, int(sessionId), 9, 0, 0, 0, time.UTC)
+  row := participationRow{SessionId: sessionId, OpenedAt: openedAt, UserId: userId, Skipped: skipped}
+  if answered >= 0 {
+    row.RespondedAt = sql.NullTime{Time: openedAt.Add(time.Duration(answered) * time.Hour), Valid: true}
+  }
+  if closed {
+    row.ClosedAt = sql.NullTime{Time: openedAt.Add(8 * time.Hour), Valid: true}
+  }
+  if reminded {
+    row.RemindedAt = sql.NullTime{Time: openedAt.Add(2 * time.Hour), Valid: true}
+  }
+  return row
+}
+
+func TestTallyParticipation(t *testing.T) {
+  tests := []struct {
+    name string
+    rows []participationRow
+    want UserStats
+  }{
+    {"answered every session", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U1", 1, true, false, false),
+      participant(3, "U1", 1, true, false, false),
+    }, UserStats{Expected: 3, Answered: 3, OnTime: 3, OnTimeRate: 1, CurrentStreak: 3, LongestStreak: 3}},
+    {"streak reset by a missed session", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U1", 1, true, false, false),
+      participant(3, "U1", -1, true, false, false),
+      participant(4, "U1", 1, true, false, false),
+    }, UserStats{Expected: 4, Answered: 3, OnTime: 3, OnTimeRate: 0.75, CurrentStreak: 1, LongestStreak: 2}},
+    {"missed the last session", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U1", -1, true, false, false),
+    }, UserStats{Expected: 2, Answered: 1, OnTime: 1, OnTimeRate: 0.5, CurrentStreak: 0, LongestStreak: 1}},
+    {"skipped session neither counted nor breaking the streak", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U1", -1, true, false, true),
+      participant(3, "U1", 1, true, false, false),
+    }, UserStats{Expected: 2, Answered: 2, OnTime: 2, OnTimeRate: 1, CurrentStreak: 2, LongestStreak: 2}},
+    {"out of office for a session", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U2", 1, true, false, false),
+      participant(3, "U1", 1, true, false, false),
+    }, UserStats{Expected: 2, Answered: 2, OnTime: 2, OnTimeRate: 1, CurrentStreak: 2, LongestStreak: 2}},
+    {"open session not answered yet", []participationRow{
+      participant(1, "U1", 1, true, false, false),
+      participant(2, "U1", -1, false, true, false),
+    }, UserStats{Expected: 1, Answered: 1, OnTime: 1, OnTimeRate: 1, CurrentStreak: 1, LongestStreak: 1}},
+    {"answered after the reminder", []participationRow{
+      participant(1, "U1", 1, true, true, false),
+      participant(2, "U1", 3, true, true, false),
+    }, UserStats{Expected: 2, Answered: 2, OnTime: 1, OnTimeRate: 0.5, CurrentStreak: 2, LongestStreak: 2}},
+  }
+  for _, test := range tests {
+    stats, _ := tallyParticipation(&ParticipationReport{}, test.rows)
+    got := *stats["U1"]
+    test.want.UserId = "U1"
+    if got != test.want {
+      t.Errorf("%s: stats = %+v, want %+v", test.name, got, test.want)
+    }
+  }
+}
+
+func TestTallyParticipationTrend(t *testing.T) {
+  report := ParticipationReport{}
+  _, order := tallyParticipation(&report, []participationRow{
+    participant(1, "U1", 1, true, false, false),
+    participant(1, "U2", -1, true, false, false),
+    participant(2, "U2", 1, true, false, false),
+    participant(2, "U1", -1, true, false, true),
+    participant(3, "U3", -1, true, false, true),
+  })
+  if len(order) != 2 || order[0] != "U1" || order[1] != "U2" {
+    t.Errorf("order = %v, want U1 then U2, leaving out U3 who only skipped", order)
+  }
+  // a session everyone skipped has no trend
+  want := []SessionTrend{
+    {SessionId: 1, Expected: 2, Answered: 1, ResponseRate: 0.5},
+    {SessionId: 2, Expected: 1, Answered: 1, ResponseRate: 1},
+  }
+  if len(report.Trend) != len(want) {
+    t.Fatalf("trend = %+v, want %d sessions", report.Trend, len(want))
+  }
+  for i, trend := range report.Trend {
+    trend.OpenedAt = time.Time{}
+    if trend != want[i] {
+      t.Errorf("trend[%d] = %+v, want %+v", i, trend, want[i])
+    }
+  }
+}
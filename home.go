@@ -0,0 +1,358 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+// how many days of participation the App Home shows
+const HOME_REPORT_DAYS = 14
+
+// only the questions, the digest schedule and roles can be edited from the App Home
+// the values of the digest ids are kept from when they were named for the schedule, so published views keep working
+const ACTION_EDIT_QUESTIONS = "edit_questions"
+const ACTION_EDIT_DIGEST_SCHEDULE = "edit_schedule"
+const ACTION_EDIT_ROLE = "edit_role"
+
+const CALLBACK_QUESTIONS = "questions"
+const CALLBACK_DIGEST_SCHEDULE = "schedule"
+const CALLBACK_ROLE = "role"
+
+// type to contain the payload of a job republishing the App Home of a user
+type HomeUpdate struct {
+  UserId string `json:"user_id"`
+}
+
+// type to contain a Block Kit block, element or view, marshalled as is
+type Block map[string]interface{}
+
+// type to contain the user of an interaction
+type InteractionUser struct {
+  Id string `json:"id"`
+}
+
+// type to contain a button clicked in an interaction
+type InteractionAction struct {
+  ActionId string `json:"action_id"`
+}
+
+// type to contain the selected option of a select input
+type InteractionOption struct {
+  Value string `json:"value"`
+}
+
+// type to contain the value of an input when a modal is submitted
+type InteractionValue struct {
+  Value string `json:"value"`
+  SelectedUser string `json:"selected_user"`
+  SelectedOption *InteractionOption `json:"selected_option"`
+}
+
+// type to contain the state of a submitted modal, keyed by block id then action id
+type InteractionState struct {
+  Values map[string]map[string]InteractionValue `json:"values"`
+}
+
+// type to contain the modal of an interaction
+type InteractionView struct {
+  CallbackId string `json:"callback_id"`
+  State InteractionState `json:"state"`
+}
+
+// type to unmarshal the payload of Slack interactions into
+type InteractionPayload struct {
+  Type string `json:"type"`
+  TriggerId string `json:"trigger_id"`
+  User InteractionUser `json:"user"`
+  Actions []InteractionAction `json:"actions"`
+  View InteractionView `json:"view"`
+}
+
+// gets the text value of the input in the given block, which uses the block id as its action id
+func (state InteractionState) Get(blockId string) InteractionValue {
+  return state.Values[blockId][blockId]
+}
+
+// creates a plain text object
+func PlainText(text string) Block {
+  return Block{"type": "plain_text", "text": text}
+}
+
+// creates a header block
+func HeaderBlock(text string) Block {
+  return Block{"type": "header", "text": PlainText(text)}
+}
+
+// creates a divider block
+func DividerBlock() Block {
+  return Block{"type": "divider"}
+}
+
+// creates a section block with markdown text
+func MarkdownSection(text string) Block {
+  return Block{"type": "section", "text": Block{"type": "mrkdwn", "text": text}}
+}
+
+// creates a section with a button next to it, the button is left out when actionId is empty
+func ButtonSection(text, actionId, label string) Block {
+  section := MarkdownSection(text)
+  if actionId != "" {
+    section["accessory"] = Block{"type": "button", "action_id": actionId, "text": PlainText(label)}
+  }
+  return section
+}
+
+// creates an input block, whose element uses the block id as its action id
+func InputBlock(blockId, label string, element Block, optional bool, hint string) Block {
+  element["action_id"] = blockId
+  input := Block{"type": "input", "block_id": blockId, "label": PlainText(label), "element": element, "optional": optional}
+  if hint != "" {
+    input["hint"] = PlainText(hint)
+  }
+  return input
+}
+
+// creates a plain text input, with the given initial value if any
+func TextInput(initial string, multiline bool) Block {
+  input := Block{"type": "plain_text_input", "multiline": multiline}
+  if initial != "" {
+    input["initial_value"] = initial
+  }
+  return input
+}
+
+// creates a modal with the given blocks, submitted with the given callback id
+func ModalView(callbackId, title string, blocks []Block) Block {
+  return Block{
+    "type": "modal",
+    "callback_id": callbackId,
+    "title": PlainText(title),
+    "submit": PlainText("Save"),
+    "close": PlainText("Cancel"),
+    "blocks": blocks,
+  }
+}
+
+// gets the action id if the user has the permission, otherwise an empty action id so no button is shown
+func actionFor(cfg *Config, userId, permission, actionId string) string {
+  if HasPermission(cfg, userId, permission) {
+    return actionId
+  }
+  return ""
+}
+
+// builds the roster of the standup channel, with each member's role and whether they are out of office
+func FormatRoster(cfg *Config) string {
+  members, ok := GetChannelMembers(cfg, cfg.Standup.ChannelId)
+  if !ok {
+    return "Could not get the members of the standup channel."
+  }
+  names := MapIdsToNames(cfg, append([]string{}, members...))
+  ooo := GetOutOfOfficeUsers(cfg)
+  builder := strings.Builder{}
+  for pos, userId := range members {
+    // the bot is mapped to an empty name and left out of the roster
+    if names[pos] == "" {
+      continue
+    }
+    fmt.Fprintf(&builder, "• <@%s> - %s", userId, GetRole(cfg, userId))
+    if ooo[userId] {
+      builder.WriteString(" (out of office)")
+    }
+    builder.WriteString("\n")
+  }
+  if builder.Len() == 0 {
+    return "No one is in the standup channel yet."
+  }
+  return builder.String()
+}
+
+// builds a short summary of the participation of the last HOME_REPORT_DAYS days
+func FormatRecentParticipation(cfg *Config) string {
+  to := time.Now().In(GetLocation())
+  from := to.AddDate(0, 0, -HOME_REPORT_DAYS)
+  report, err := GetParticipationReport(cfg, from, to)
+  if err != nil {
+    cfg.Log().Error("Error building home report", "err", err)
+    return "Could not get the recent participation."
+  }
+  if len(report.Users) == 0 {
+    return "No checkin sessions yet."
+  }
+  builder := strings.Builder{}
+  for _, user := range report.Users {
+    fmt.Fprintf(&builder, "• %s: %d/%d checked in, %.0f%% on time, streak %d\n",
+      user.Name, user.Answered, user.Expected, user.OnTimeRate*100, user.CurrentStreak)
+  }
+  return builder.String()
+}
+
+// builds the blocks of the App Home tab for the given user
+// the buttons to edit the standup are only shown to users allowed to use them
+func BuildHomeBlocks(cfg *Config, userId string) []Block {
+  blocks := []Block{HeaderBlock(fmt.Sprintf("%s standup", cfg.Standup.Name))}
+  if GetRole(cfg, userId) == "" {
+    return append(blocks, MarkdownSection("You aren't part of this standup."))
+  }
+
+  session := "There is no open checkin session."
+  if GetThreadId() != "" {
+    session = "A checkin session is open."
+  }
+  blocks = append(blocks, MarkdownSection(fmt.Sprintf("Checkins are posted in <#%s>, and opened and closed by owners "+
+    "and admins with `/standup open` and `/standup close`. %s", cfg.Standup.ChannelId, session)))
+  blocks = append(blocks, DividerBlock())
+
+  digest := "Not scheduled"
+  if cfg.Digest.Schedule != "" {
+    digest = fmt.Sprintf("%s to the %s", cfg.Digest.Schedule, cfg.Digest.Target)
+  }
+  blocks = append(blocks, ButtonSection(fmt.Sprintf("*Weekly digest*\n%s", digest),
+    actionFor(cfg, userId, PERM_MANAGE_STANDUP, ACTION_EDIT_DIGEST_SCHEDULE), "Edit digest schedule"))
+  blocks = append(blocks, ButtonSection(fmt.Sprintf("*Questions*\n>%s\n*Reminder*\n>%s",
+    strings.Replace(cfg.Standup.Prompt, "\n", "\n>", -1), strings.Replace(cfg.Standup.Reminder, "\n", "\n>", -1)),
+    actionFor(cfg, userId, PERM_MANAGE_STANDUP, ACTION_EDIT_QUESTIONS), "Edit questions"))
+  blocks = append(blocks, DividerBlock())
+
+  if HasPermission(cfg, userId, PERM_VIEW_CONFIG) {
+    // who checks in follows the members of the channel, so only roles are changed from here
+    blocks = append(blocks, ButtonSection(fmt.Sprintf("*Roster*\n%sInvite people to or remove them from <#%s> to "+
+      "change who checks in.", FormatRoster(cfg), cfg.Standup.ChannelId),
+      actionFor(cfg, userId, PERM_MANAGE_ROLES, ACTION_EDIT_ROLE), "Change a role"))
+  }
+  if HasPermission(cfg, userId, PERM_VIEW_REPORTS) {
+    blocks = append(blocks, MarkdownSection(fmt.Sprintf("*Participation in the last %d days*\n%s",
+      HOME_REPORT_DAYS, FormatRecentParticipation(cfg))))
+  }
+  return blocks
+}
+
+// publishes the App Home tab for the given user
+func PublishHome(cfg *Config, userId string) error {
+  body, err := PerformJsonPost(cfg, "views.publish", map[string]interface{}{
+    "user_id": userId,
+    "view": Block{"type": "home", "blocks": BuildHomeBlocks(cfg, userId)},
+  })
+  if err == nil && !body.Ok {
+    err = fmt.Errorf("slack error %s", body.Error)
+  }
+  if err != nil {
+    cfg.Log().Error("Error publishing home", "user", userId, "err", err)
+  }
+  return err
+}
+
+// republishes the App Home of the user in the payload, with the config in use when the job runs
+func RunPublishHomeJob(cfg *Config, payload []byte) error {
+  var update HomeUpdate
+  if err := json.Unmarshal(payload, &update); err != nil {
+    return err
+  }
+  return PublishHome(cfg, update.UserId)
+}
+
+// builds the modal for the given App Home button
+func BuildModal(cfg *Config, actionId string) (Block, bool) {
+  switch actionId {
+  case ACTION_EDIT_QUESTIONS:
+    return ModalView(CALLBACK_QUESTIONS, "Edit questions", []Block{
+      InputBlock(SETTING_PROMPT, "Checkin questions", TextInput(cfg.Standup.Prompt, true), true,
+        "DM'd to everyone when a session opens, leave empty to use the config file"),
+      InputBlock(SETTING_REMINDER, "Reminder", TextInput(cfg.Standup.Reminder, true), true,
+        "DM'd to everyone still awaited when reminding, leave empty to use the config file"),
+    }), true
+  case ACTION_EDIT_DIGEST_SCHEDULE:
+    return ModalView(CALLBACK_DIGEST_SCHEDULE, "Edit digest schedule", []Block{
+      InputBlock(SETTING_DIGEST_SCHEDULE, "Weekly digest", TextInput(cfg.Digest.Schedule, false), true,
+        "A day and time such as fri 16:00, leave empty to use the config file"),
+    }), true
+  case ACTION_EDIT_ROLE:
+    options := make([]Block, 0, len(ROLE_PERMISSIONS))
+    for _, role := range []string{ROLE_OWNER, ROLE_ADMIN, ROLE_PARTICIPANT, ROLE_VIEWER} {
+      options = append(options, Block{"text": PlainText(role), "value": role})
+    }
+    return ModalView(CALLBACK_ROLE, "Change a role", []Block{
+      InputBlock("user", "User", Block{"type": "users_select"}, false, ""),
+      InputBlock("role", "Role", Block{"type": "static_select", "options": options}, false, ""),
+    }), true
+  }
+  return nil, false
+}
+
+// saves a submitted modal, returning the errors to show keyed by block id
+func SubmitModal(cfg *Config, userId string, view InteractionView) map[string]string {
+  switch view.CallbackId {
+  case CALLBACK_QUESTIONS, CALLBACK_DIGEST_SCHEDULE:
+    // errors are shown under the first input of the modal
+    errorBlock := SETTING_PROMPT
+    if view.CallbackId == CALLBACK_DIGEST_SCHEDULE {
+      errorBlock = SETTING_DIGEST_SCHEDULE
+    }
+    if !HasPermission(cfg, userId, PERM_MANAGE_STANDUP) {
+      return map[string]string{errorBlock: "You are not allowed to edit this standup"}
+    }
+    settings := make(map[string]string)
+    for blockId := range view.State.Values {
+      settings[blockId] = view.State.Get(blockId).Value
+    }
+    if err := SaveSettings(cfg, userId, settings); err != nil {
+      return map[string]string{errorBlock: err.Error()}
+    }
+  case CALLBACK_ROLE:
+    role := ""
+    if option := view.State.Get("role").SelectedOption; option != nil {
+      role = option.Value
+    }
+    if err := SetRole(cfg, userId, view.State.Get("user").SelectedUser, role, SOURCE_HOME); err != nil {
+      return map[string]string{"role": err.Error()}
+    }
+  default:
+    cfg.Log().Warn("Unknown modal submitted", "callback_id", view.CallbackId)
+  }
+  return nil
+}
+
+// the handler for the /interactions endpoint
+// opens the modal for App Home buttons, and saves submitted modals before republishing the App Home
+func InteractionsHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  if err := r.ParseForm(); err != nil {
+    cfg.Log().Error("Error parsing interaction", "err", err)
+    http.Error(w, "invalid interaction", http.StatusBadRequest)
+    return
+  }
+  var payload InteractionPayload
+  if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload); err != nil {
+    cfg.Log().Error("Error parsing interaction payload", "err", err)
+    http.Error(w, "invalid interaction", http.StatusBadRequest)
+    return
+  }
+
+  switch payload.Type {
+  case "block_actions":
+    for _, action := range payload.Actions {
+      modal, ok := BuildModal(cfg, action.ActionId)
+      if !ok {
+        continue
+      }
+      body, err := PerformJsonPost(cfg, "views.open", map[string]interface{}{"trigger_id": payload.TriggerId, "view": modal})
+      if err != nil || !body.Ok {
+        cfg.Log().Error("Error opening modal", "err", err, "slack_error", body.Error)
+      }
+    }
+  case "view_submission":
+    if errors := SubmitModal(cfg, payload.User.Id, payload.View); len(errors) > 0 {
+      w.Header().Set("Content-Type", "application/json")
+      json.NewEncoder(w).Encode(map[string]interface{}{"response_action": "errors", "errors": errors})
+      return
+    }
+    // the modal closes once this responds, so the App Home is republished with the new config by a queued job
+    if err := Enqueue(cfg, JOB_PUBLISH_HOME, HomeUpdate{UserId: payload.User.Id}); err != nil {
+      cfg.Log().Error("Error queueing the App Home update", "user", payload.User.Id, "err", err)
+    }
+  default:
+    cfg.Log().Warn("Unknown interaction", "type", payload.Type)
+  }
+}
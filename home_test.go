@@ -0,0 +1,113 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+)
+
+// opens a fake db in DB, where no session is open and everyone has the default role
+func useFakeDB(t *testing.T) (restore func()) {
+  db, err := sql.Open(FAKE_DB_DRIVER, "")
+  if err != nil {
+    t.Fatalf("opening the fake db: %v", err)
+  }
+  DB = db
+  return func() {
+    db.Close()
+    DB = nil
+  }
+}
+
+func TestBuildModal(t *testing.T) {
+  cfg := &Config{Digest: DigestConfig{Schedule: "fri 16:00"}}
+  modal, ok := BuildModal(cfg, ACTION_EDIT_DIGEST_SCHEDULE)
+  if !ok {
+    t.Fatalf("BuildModal(%s) found no modal", ACTION_EDIT_DIGEST_SCHEDULE)
+  }
+  blocks := modal["blocks"].([]Block)
+  if title := modal["title"].(Block)["text"]; title != "Edit digest schedule" || len(blocks) != 1 ||
+    blocks[0]["block_id"] != SETTING_DIGEST_SCHEDULE {
+    t.Errorf("BuildModal(%s) = %v, want only the digest schedule", ACTION_EDIT_DIGEST_SCHEDULE, modal)
+  }
+  if _, ok = BuildModal(cfg, "edit_roster"); ok {
+    t.Errorf("BuildModal(edit_roster) found a modal, want none")
+  }
+}
+
+func TestBuildHomeBlocksForParticipants(t *testing.T) {
+  defer useFakeDB(t)()
+  cfg := &Config{Standup: StandupConfig{Name: "eng", ChannelId: "C1", Prompt: "What did you do?"}}
+
+  blocks := BuildHomeBlocks(cfg, "U1")
+  encoded, _ := json.Marshal(blocks)
+  for _, block := range blocks {
+    if _, ok := block["accessory"]; ok {
+      t.Errorf("participant is shown the button %v", block["accessory"])
+    }
+  }
+  for _, want := range []string{"*Weekly digest*\\nNot scheduled", "`/standup open`", "What did you do?"} {
+    if !strings.Contains(string(encoded), want) {
+      t.Errorf("BuildHomeBlocks() = %s, want it to contain %q", encoded, want)
+    }
+  }
+  if strings.Contains(string(encoded), "*Roster*") {
+    t.Errorf("participant is shown the roster: %s", encoded)
+  }
+}
+
+func TestRunPublishHomeJob(t *testing.T) {
+  defer useFakeDB(t)()
+  var published []string
+  ok := true
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    if method != "views.publish" {
+      t.Errorf("unexpected Slack call %s", method)
+    }
+    published = append(published, slackParams(r)["user_id"])
+    if !ok {
+      return map[string]interface{}{"ok": false, "error": "not_enabled"}
+    }
+    return map[string]interface{}{"ok": true}
+  })()
+  cfg := &Config{ApiToken: "xoxb-test", Standup: StandupConfig{Name: "eng"}}
+
+  if err := RunPublishHomeJob(cfg, []byte(`{"user_id":"U1"}`)); err != nil {
+    t.Errorf("RunPublishHomeJob() = %v, want nil", err)
+  }
+  // a failed publish is retried by the queue
+  ok = false
+  if err := RunPublishHomeJob(cfg, []byte(`{"user_id":"U2"}`)); err == nil {
+    t.Errorf("RunPublishHomeJob() of a failed publish = nil, want an error")
+  }
+  if strings.Join(published, ",") != "U1,U2" {
+    t.Errorf("published the App Home of %v, want U1 then U2", published)
+  }
+}
+
+func TestSubmittedModalQueuesHomeUpdate(t *testing.T) {
+  requireDB(t)
+  cfg := sessionConfig()
+  cfg.AdminUsers = []string{"U1"}
+  payload := `{"type":"view_submission","user":{"id":"U1"},"view":{"callback_id":"schedule",` +
+    `"state":{"values":{"digest_schedule":{"digest_schedule":{"value":"fri 16:00"}}}}}}`
+  r := httptest.NewRequest("POST", "/interactions", strings.NewReader(url.Values{"payload": {payload}}.Encode()))
+  r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  w := httptest.NewRecorder()
+
+  InteractionsHandler(cfg, w, r)
+  if w.Code != http.StatusOK || w.Body.Len() != 0 {
+    t.Fatalf("InteractionsHandler() = %d %q, want the modal closed", w.Code, w.Body.String())
+  }
+  var queued string
+  if err := DB.QueryRow("SELECT payload FROM jobs WHERE kind = $1;", JOB_PUBLISH_HOME).Scan(&queued); err != nil {
+    t.Fatalf("getting the queued App Home update: %v", err)
+  }
+  if queued != `{"user_id":"U1"}` {
+    t.Errorf("queued App Home update %s, want one for U1", queued)
+  }
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "sync/atomic"
+  "time"
+)
+
+const LEADER_LEASE_NAME = "scheduler"
+
+// how long a lease lasts without being renewed, after which another replica can take over
+const LEADER_LEASE_TTL = 30 * time.Second
+
+// type to elect a single leader among the replicas sharing the db, using a lease renewed by the leader
+type LeaderElector struct {
+  name string
+  id string
+  ttl time.Duration
+  leader int32
+  stop chan struct{}
+}
+
+var LEADER *LeaderElector
+
+// creates the leader lease table if it does not exist yet
+func LeaderSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS leader_lease (
+      name TEXT PRIMARY KEY,
+      holder TEXT NOT NULL,
+      expires_at TIMESTAMPTZ NOT NULL
+    );`); err != nil {
+    LOG.Error("Error creating leader lease table", "err", err)
+  }
+}
+
+// gets an id for this replica, unique among replicas running at the same time
+func InstanceId() string {
+  host, err := os.Hostname()
+  if err != nil {
+    host = "unknown"
+  }
+  return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// creates an elector for the lease with the given name, identified by the given instance id
+func NewLeaderElector(name, id string, ttl time.Duration) *LeaderElector {
+  return &LeaderElector{name: name, id: id, ttl: ttl}
+}
+
+// takes the lease if it is free or expired, or renews it if this replica already holds it
+// the db clock is used for expiry, so replicas with skewed clocks agree on it
+func (e *LeaderElector) TryAcquire() bool {
+  res, err := DB.Exec(`INSERT INTO leader_lease (name, holder, expires_at)
+    VALUES ($1, $2, now() + $3::interval)
+    ON CONFLICT (name) DO UPDATE SET holder = $2, expires_at = now() + $3::interval
+    WHERE leader_lease.holder = $2 OR leader_lease.expires_at < now();`,
+    e.name, e.id, fmt.Sprintf("%d milliseconds", e.ttl.Milliseconds()))
+  acquired := false
+  if err != nil {
+    // without the db no one can tell who leads, so this replica steps down
+    LOG.Error("Error acquiring leader lease", "err", err)
+  } else {
+    rowsAff, _ := res.RowsAffected()
+    acquired = rowsAff == 1
+  }
+
+  was := atomic.SwapInt32(&e.leader, boolToInt32(acquired)) == 1
+  if acquired && !was {
+    LOG.Info("Instance is now the leader", "instance", e.id)
+  } else if !acquired && was {
+    LOG.Warn("Instance is no longer the leader", "instance", e.id)
+  }
+  return acquired
+}
+
+func boolToInt32(b bool) int32 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+// determines if this replica currently holds the lease
+func (e *LeaderElector) IsLeader() bool {
+  return atomic.LoadInt32(&e.leader) == 1
+}
+
+// gets the id this replica holds the lease with
+func (e *LeaderElector) Id() string {
+  return e.id
+}
+
+// takes part in the election in the background, trying for the lease well before it would expire
+func (e *LeaderElector) Start() {
+  if e.stop != nil {
+    return
+  }
+  e.stop = make(chan struct{})
+  e.TryAcquire()
+  go func(stop chan struct{}) {
+    ticker := time.NewTicker(e.ttl / 3)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-stop:
+        return
+      case <-ticker.C:
+        e.TryAcquire()
+      }
+    }
+  }(e.stop)
+}
+
+// stops taking part in the election and gives up the lease, so another replica can take over right away
+func (e *LeaderElector) Stop() {
+  if e.stop == nil {
+    return
+  }
+  close(e.stop)
+  e.stop = nil
+  atomic.StoreInt32(&e.leader, 0)
+  if _, err := DB.Exec("DELETE FROM leader_lease WHERE name = $1 AND holder = $2;", e.name, e.id); err != nil {
+    LOG.Error("Error releasing leader lease", "err", err)
+  }
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+const TEST_LEASE_TTL = 1500 * time.Millisecond
+
+// gets a scheduler gated by the given leader check, with a job counting its runs
+func countingScheduler(isLeader func() bool, runs *int32) *Scheduler {
+  scheduler := &Scheduler{}
+  scheduler.SetLeaderCheck(isLeader)
+  scheduler.Add(&ScheduledJob{
+    Name: "count",
+    Next: func(after time.Time) time.Time {
+      return after.Add(time.Hour)
+    },
+    Run: func() {
+      atomic.AddInt32(runs, 1)
+    },
+  })
+  return scheduler
+}
+
+// waits up to the given time for the elector to become the leader
+func waitForLeader(e *LeaderElector, timeout time.Duration) bool {
+  deadline := time.Now().Add(timeout)
+  for time.Now().Before(deadline) {
+    if e.IsLeader() {
+      return true
+    }
+    time.Sleep(50 * time.Millisecond)
+  }
+  return e.IsLeader()
+}
+
+func TestSchedulerOnlyRunsOnLeader(t *testing.T) {
+  leader := int32(0)
+  var runs int32
+  scheduler := countingScheduler(func() bool { return atomic.LoadInt32(&leader) == 1 }, &runs)
+
+  scheduler.runDue(time.Now().Add(2 * time.Hour))
+  if runs != 0 {
+    t.Fatalf("job ran %d times on a follower, want 0", runs)
+  }
+  // the skipped run moved on, so becoming the leader does not run it late
+  atomic.StoreInt32(&leader, 1)
+  scheduler.runDue(time.Now().Add(2 * time.Hour))
+  if runs != 0 {
+    t.Fatalf("job skipped by a follower ran %d times once it led, want 0", runs)
+  }
+  scheduler.runDue(time.Now().Add(4 * time.Hour))
+  if runs != 1 {
+    t.Fatalf("job ran %d times on the leader, want 1", runs)
+  }
+}
+
+// type for a run log kept in memory, shared by the schedulers of a test as the db is by replicas
+type memoryRunLog struct {
+  mtx sync.Mutex
+  runs map[string]time.Time
+}
+
+func (l *memoryRunLog) Claim(name string, scheduledAt time.Time) (bool, error) {
+  l.mtx.Lock()
+  defer l.mtx.Unlock()
+  if last, ok := l.runs[name]; ok && !last.Before(scheduledAt) {
+    return false, nil
+  }
+  if l.runs == nil {
+    l.runs = make(map[string]time.Time)
+  }
+  l.runs[name] = scheduledAt
+  return true, nil
+}
+
+func (l *memoryRunLog) Ran(name string, scheduledAt time.Time) (bool, error) {
+  l.mtx.Lock()
+  defer l.mtx.Unlock()
+  last, ok := l.runs[name]
+  return ok && !last.Before(scheduledAt), nil
+}
+
+// gets a scheduler gated by the given leader check and recording its runs in the given log,
+// with a job due on the hour counting its runs
+func loggingScheduler(isLeader func() bool, runLog RunLog, runs *int32) *Scheduler {
+  scheduler := &Scheduler{}
+  scheduler.SetLeaderCheck(isLeader)
+  scheduler.SetRunLog(runLog)
+  scheduler.Add(&ScheduledJob{
+    Name: "count",
+    Next: func(after time.Time) time.Time {
+      return after.Truncate(time.Hour).Add(time.Hour)
+    },
+    Run: func() {
+      atomic.AddInt32(runs, 1)
+    },
+  })
+  return scheduler
+}
+
+func TestSchedulerFailoverBeforeTheRun(t *testing.T) {
+  runLog := &memoryRunLog{}
+  leader := int32(1)
+  var firstRuns, secondRuns int32
+  first := loggingScheduler(func() bool { return atomic.LoadInt32(&leader) == 1 }, runLog, &firstRuns)
+  second := loggingScheduler(func() bool { return atomic.LoadInt32(&leader) == 2 }, runLog, &secondRuns)
+
+  // the leader stops just before the run is due, so only the follower checks for it
+  due := time.Now().Truncate(time.Hour).Add(time.Hour + time.Minute)
+  second.runDue(due)
+  if secondRuns != 0 {
+    t.Fatalf("job ran %d times on the follower, want 0", secondRuns)
+  }
+  // the follower takes over a little later, and makes the run the old leader missed
+  atomic.StoreInt32(&leader, 2)
+  second.runDue(due.Add(time.Minute))
+  second.runDue(due.Add(2 * time.Minute))
+  if secondRuns != 1 {
+    t.Fatalf("job ran %d times on the new leader, want the missed run made once", secondRuns)
+  }
+  // the old leader comes back as a follower, and sees the run was made
+  first.runDue(due.Add(3 * time.Minute))
+  if firstRuns != 0 {
+    t.Errorf("job ran %d times on the old leader, want 0", firstRuns)
+  }
+  if next := first.Status()[0].NextRun; !next.After(due) {
+    t.Errorf("old leader's next run is %s, want it moved past the run made by the new leader", next)
+  }
+}
+
+func TestSchedulerFailoverAfterTheRun(t *testing.T) {
+  runLog := &memoryRunLog{}
+  leader := int32(1)
+  var firstRuns, secondRuns int32
+  first := loggingScheduler(func() bool { return atomic.LoadInt32(&leader) == 1 }, runLog, &firstRuns)
+  second := loggingScheduler(func() bool { return atomic.LoadInt32(&leader) == 2 }, runLog, &secondRuns)
+
+  due := time.Now().Truncate(time.Hour).Add(time.Hour + time.Minute)
+  first.runDue(due)
+  // the follower's run was due at the same time, and the leader failing over before it checks doesn't run it again
+  atomic.StoreInt32(&leader, 2)
+  second.runDue(due)
+  if firstRuns != 1 || secondRuns != 0 {
+    t.Errorf("job ran %d times on the old leader and %d on the new one, want 1 and 0", firstRuns, secondRuns)
+  }
+  second.runDue(due.Add(time.Hour))
+  if secondRuns != 1 {
+    t.Errorf("job ran %d times on the new leader at its next run, want 1", secondRuns)
+  }
+}
+
+func TestDBRunLog(t *testing.T) {
+  requireDB(t)
+  at := time.Now().Truncate(time.Hour)
+  runLog := DBRunLog{}
+  if ran, err := runLog.Ran("digest", at); ran || err != nil {
+    t.Errorf("Ran() before any run = %t, %v, want false", ran, err)
+  }
+  if claimed, err := runLog.Claim("digest", at); !claimed || err != nil {
+    t.Fatalf("Claim() of the first run = %t, %v, want true", claimed, err)
+  }
+  if claimed, _ := runLog.Claim("digest", at); claimed {
+    t.Errorf("Claim() of a run already made = true, want false")
+  }
+  if claimed, _ := runLog.Claim("digest", at.Add(-time.Hour)); claimed {
+    t.Errorf("Claim() of a run before the last one = true, want false")
+  }
+  if ran, _ := runLog.Ran("digest", at); !ran {
+    t.Errorf("Ran() of a run made = false, want true")
+  }
+  if ran, _ := runLog.Ran("prune", at); ran {
+    t.Errorf("Ran() of another job = true, want false")
+  }
+  if claimed, _ := runLog.Claim("digest", at.Add(time.Hour)); !claimed {
+    t.Errorf("Claim() of the next run = false, want true")
+  }
+}
+
+func TestLeaderFailoverOnStop(t *testing.T) {
+  requireDB(t)
+  first := NewLeaderElector("test", "first", TEST_LEASE_TTL)
+  second := NewLeaderElector("test", "second", TEST_LEASE_TTL)
+  first.Start()
+  defer first.Stop()
+  second.Start()
+  defer second.Stop()
+
+  if !first.IsLeader() || second.IsLeader() {
+    t.Fatalf("leaders = %t, %t, want only the first instance to lead", first.IsLeader(), second.IsLeader())
+  }
+  var firstRuns, secondRuns int32
+  firstScheduler := loggingScheduler(first.IsLeader, DBRunLog{}, &firstRuns)
+  secondScheduler := loggingScheduler(second.IsLeader, DBRunLog{}, &secondRuns)
+  due := time.Now().Add(2 * time.Hour)
+  firstScheduler.runDue(due)
+  secondScheduler.runDue(due)
+  if firstRuns != 1 || secondRuns != 0 {
+    t.Fatalf("job ran %d times on the leader and %d on the follower, want 1 and 0", firstRuns, secondRuns)
+  }
+
+  // giving up the lease lets the other instance take over at its next try, well within the TTL
+  first.Stop()
+  if !waitForLeader(second, TEST_LEASE_TTL) {
+    t.Fatalf("second instance did not take over within %s of the leader stopping", TEST_LEASE_TTL)
+  }
+  due = due.Add(2 * time.Hour)
+  firstScheduler.runDue(due)
+  secondScheduler.runDue(due)
+  if firstRuns != 1 || secondRuns != 1 {
+    t.Fatalf("after failover the job ran %d more times on the old leader and %d on the new one, want 0 and 1",
+      firstRuns-1, secondRuns)
+  }
+}
+
+func TestLeaderFailoverOnExpiry(t *testing.T) {
+  requireDB(t)
+  // a leader that stops renewing its lease without giving it up, as when its process is killed
+  crashed := NewLeaderElector("test", "crashed", TEST_LEASE_TTL)
+  if !crashed.TryAcquire() {
+    t.Fatalf("first instance could not take the free lease")
+  }
+  standby := NewLeaderElector("test", "standby", TEST_LEASE_TTL)
+  standby.Start()
+  defer standby.Stop()
+  if standby.IsLeader() {
+    t.Fatalf("second instance took a lease that had not expired")
+  }
+
+  start := time.Now()
+  // the lease expires after the TTL, and is taken at the next try after that
+  if !waitForLeader(standby, TEST_LEASE_TTL+TEST_LEASE_TTL/3+time.Second) {
+    t.Fatalf("second instance did not take over after the lease expired")
+  }
+  if elapsed := time.Since(start); elapsed < TEST_LEASE_TTL-100*time.Millisecond {
+    t.Errorf("second instance took over after %s, before the lease expired", elapsed)
+  }
+  if crashed.TryAcquire() {
+    t.Errorf("the instance whose lease expired took it back from the new leader")
+  }
+}
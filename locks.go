@@ -0,0 +1,70 @@
+package main
+
+import (
+  "context"
+  "database/sql/driver"
+  "fmt"
+  "sync"
+  "time"
+)
+
+// how long to wait for another request, job or instance to release a standup before giving up on locking it
+// it is kept well under QUEUE_STALE_AFTER, so a job waiting for the lock isn't taken for a stale one and run again
+var STANDUP_LOCK_TIMEOUT = time.Minute
+
+var STANDUP_LOCKS_MTX = sync.Mutex{}
+var STANDUP_LOCKS = make(map[string]chan struct{})
+
+// gets the in-process lock of the given standup, held while a value is in the channel
+func getStandupLock(standup string) chan struct{} {
+  STANDUP_LOCKS_MTX.Lock()
+  defer STANDUP_LOCKS_MTX.Unlock()
+  lock, ok := STANDUP_LOCKS[standup]
+  if !ok {
+    lock = make(chan struct{}, 1)
+    STANDUP_LOCKS[standup] = lock
+  }
+  return lock
+}
+
+// locks the given standup while a session is opened, closed or reminded, both in this process and,
+// with a Postgres advisory lock, across every instance sharing the db
+// returns the function releasing the lock, or an error when the standup could not be locked within
+// STANDUP_LOCK_TIMEOUT, in which case nothing is locked and the caller should give up and be retried
+func LockStandup(standup string) (unlock func(), err error) {
+  ctx, cancel := context.WithTimeout(BASE_CONTEXT, STANDUP_LOCK_TIMEOUT)
+  defer cancel()
+  lock := getStandupLock(standup)
+  select {
+  case lock <- struct{}{}:
+  case <-ctx.Done():
+    return nil, fmt.Errorf("waiting for the standup %s to be unlocked in this process: %s", standup, ctx.Err())
+  }
+  release := func() { <-lock }
+
+  key := fmt.Sprintf("standup:%s", standup)
+  // advisory locks belong to a session, so the same connection is kept to release it
+  conn, err := DB.Conn(ctx)
+  if err != nil {
+    release()
+    return nil, fmt.Errorf("getting a connection for the standup lock: %s", err)
+  }
+  if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1));", key); err != nil {
+    // a lock granted as the wait was cancelled is still released along with the dropped connection
+    conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+    conn.Close()
+    release()
+    return nil, fmt.Errorf("taking the standup advisory lock: %s", err)
+  }
+
+  return func() {
+    // released even when a shutdown cancelled the work done under the lock
+    if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1));", key); err != nil {
+      LOG.Error("Error releasing the standup advisory lock", "err", err)
+      // the connection is dropped rather than reused, which releases the lock with its session
+      conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+    }
+    conn.Close()
+    release()
+  }, nil
+}
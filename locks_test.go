@@ -0,0 +1,67 @@
+package main
+
+import (
+  "net/http"
+  "testing"
+  "time"
+)
+
+func TestLockStandupTimesOut(t *testing.T) {
+  defer useFakeDB(t)()
+  defer func(timeout time.Duration) { STANDUP_LOCK_TIMEOUT = timeout }(STANDUP_LOCK_TIMEOUT)
+  STANDUP_LOCK_TIMEOUT = 50 * time.Millisecond
+
+  unlock, err := LockStandup("eng")
+  if err != nil {
+    t.Fatalf("LockStandup() = %v", err)
+  }
+  if _, err = LockStandup("eng"); err == nil {
+    t.Fatalf("LockStandup() of a locked standup = nil, want an error once the wait times out")
+  }
+  // other standups aren't held up
+  other, err := LockStandup("design")
+  if err != nil {
+    t.Fatalf("LockStandup() of another standup = %v", err)
+  }
+  other()
+
+  unlock()
+  unlock, err = LockStandup("eng")
+  if err != nil {
+    t.Fatalf("LockStandup() after unlocking = %v", err)
+  }
+  unlock()
+}
+
+func TestSessionsNotChangedWithoutTheLock(t *testing.T) {
+  defer useFakeDB(t)()
+  defer func(timeout time.Duration) { STANDUP_LOCK_TIMEOUT = timeout }(STANDUP_LOCK_TIMEOUT)
+  STANDUP_LOCK_TIMEOUT = 10 * time.Millisecond
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    t.Errorf("unexpected Slack call %s", method)
+    return map[string]interface{}{"ok": false, "error": "unexpected"}
+  })()
+  unlock, err := LockStandup("standup")
+  if err != nil {
+    t.Fatalf("LockStandup() = %v", err)
+  }
+  defer unlock()
+  cfg := sessionConfig()
+
+  for name, run := range map[string]func(cfg *Config) (bool, error){"OpenCheckin": OpenCheckin,
+    "CloseCheckin": CloseCheckin, "RemindCheckin": RemindCheckin} {
+    if done, err := run(cfg); done || err == nil {
+      t.Errorf("%s() of a locked standup = %t, %v, want an error", name, done, err)
+    }
+  }
+  // the event is retried rather than dropped
+  for _, eventType := range []string{"member_joined_channel", "member_left_channel"} {
+    event := SlackEvent{Type: eventType, User: "U1", Channel: "C1"}
+    if err := ProcessEvent(cfg, SlackResponse{Event_id: "Ev1", Event: event}); err == nil {
+      t.Errorf("ProcessEvent() of %s with the standup locked = nil, want an error", eventType)
+    }
+  }
+  if got := RunOpenCommand(cfg, SlashRequest{UserId: "U1"}, nil); got != STANDUP_BUSY_MESSAGE {
+    t.Errorf("RunOpenCommand() with the standup locked = %q, want %q", got, STANDUP_BUSY_MESSAGE)
+  }
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log"
+  "net/http"
+  "os"
+  "path"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+const LEVEL_DEBUG = 0
+const LEVEL_INFO = 1
+const LEVEL_WARN = 2
+const LEVEL_ERROR = 3
+
+var LEVEL_NAMES = map[string]int{"debug": LEVEL_DEBUG, "info": LEVEL_INFO, "warn": LEVEL_WARN, "error": LEVEL_ERROR}
+
+const LOG_FORMAT_JSON = "json"
+const LOG_FORMAT_TEXT = "text"
+
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// the paths polled by probes and scrapers, whose requests are only logged at debug level
+var QUIET_PATHS = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// the keys whose values are message text, hidden when redaction is on
+var LOG_TEXT_KEYS = map[string]bool{"text": true, "body": true, "prompt": true}
+
+// the patterns of the keys whose values are always hidden, matched against the lowercased key with path.Match
+var LOG_SECRET_KEYS = []string{"token", "*_token", "*secret*", "*password*", "authorization", "*api_key*"}
+
+// type to contain how log records are written
+type LogSettings struct {
+  Level int
+  Format string
+  RedactText bool
+}
+
+// type to write structured log records, with the attributes it was created with added to each
+type Logger struct {
+  attrs []interface{}
+}
+
+type requestIdKey struct{}
+
+var LOG_MTX = sync.Mutex{}
+var LOG_SETTINGS = LogSettings{Level: LEVEL_INFO, Format: LOG_FORMAT_JSON}
+var LOG_OUTPUT io.Writer = os.Stderr
+
+var LOG = &Logger{}
+
+// applies the logging settings of the config, and sends anything still logged with the log package
+// through the structured logger
+func ConfigureLogging(cfg *Config) {
+  level, ok := LEVEL_NAMES[strings.ToLower(cfg.Logging.Level)]
+  if !ok {
+    level = LEVEL_INFO
+  }
+  LOG_MTX.Lock()
+  LOG_SETTINGS = LogSettings{
+    Level: level,
+    Format: cfg.Logging.Format,
+    RedactText: cfg.Logging.RedactText,
+  }
+  LOG_MTX.Unlock()
+
+  log.SetFlags(0)
+  log.SetOutput(stdLogWriter{})
+}
+
+// type to turn lines written by the log package into info records
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+  LOG.Info(strings.TrimSpace(string(p)))
+  return len(p), nil
+}
+
+// creates a random id to correlate the records logged while handling a request
+func NewRequestId() string {
+  id := make([]byte, 8)
+  if _, err := rand.Read(id); err != nil {
+    return fmt.Sprintf("%x", time.Now().UnixNano())
+  }
+  return hex.EncodeToString(id)
+}
+
+// adds the request id to the context, so the queries run with it are logged with the id
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+  return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// gets the request id of the context, or an empty string if it has none
+func RequestIdFromContext(ctx context.Context) string {
+  requestId, _ := ctx.Value(requestIdKey{}).(string)
+  return requestId
+}
+
+// creates a logger adding the given key value pairs to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+  attrs := make([]interface{}, 0, len(l.attrs)+len(args))
+  attrs = append(attrs, l.attrs...)
+  attrs = append(attrs, args...)
+  return &Logger{attrs: attrs}
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) {
+  l.write(LEVEL_DEBUG, msg, args)
+}
+
+func (l *Logger) Info(msg string, args ...interface{}) {
+  l.write(LEVEL_INFO, msg, args)
+}
+
+func (l *Logger) Warn(msg string, args ...interface{}) {
+  l.write(LEVEL_WARN, msg, args)
+}
+
+func (l *Logger) Error(msg string, args ...interface{}) {
+  l.write(LEVEL_ERROR, msg, args)
+}
+
+// logs an error record, then exits
+func (l *Logger) Fatal(msg string, args ...interface{}) {
+  l.write(LEVEL_ERROR, msg, args)
+  os.Exit(1)
+}
+
+func levelName(level int) string {
+  switch level {
+  case LEVEL_DEBUG:
+    return "DEBUG"
+  case LEVEL_WARN:
+    return "WARN"
+  case LEVEL_ERROR:
+    return "ERROR"
+  }
+  return "INFO"
+}
+
+// writes a record, made of the time, level and message, then the key value pairs of the logger and the call
+// a key without a value is logged under "!BADKEY", as log/slog does
+func (l *Logger) write(level int, msg string, args []interface{}) {
+  LOG_MTX.Lock()
+  defer LOG_MTX.Unlock()
+  if level < LOG_SETTINGS.Level {
+    return
+  }
+
+  keys := []string{"time", "level", "msg"}
+  values := []interface{}{time.Now().UTC().Format(time.RFC3339Nano), levelName(level), msg}
+  attrs := append(append([]interface{}{}, l.attrs...), args...)
+  for i := 0; i < len(attrs); i += 2 {
+    key, ok := attrs[i].(string)
+    if !ok || i+1 >= len(attrs) {
+      keys = append(keys, "!BADKEY")
+      values = append(values, attrs[i])
+      i--
+      continue
+    }
+    keys = append(keys, key)
+    values = append(values, redactValue(key, attrs[i+1], LOG_SETTINGS.RedactText))
+  }
+
+  var builder strings.Builder
+  if LOG_SETTINGS.Format == LOG_FORMAT_TEXT {
+    for i, key := range keys {
+      if i > 0 {
+        builder.WriteByte(' ')
+      }
+      fmt.Fprintf(&builder, "%s=%s", key, textValue(values[i]))
+    }
+  } else {
+    builder.WriteByte('{')
+    for i, key := range keys {
+      if i > 0 {
+        builder.WriteByte(',')
+      }
+      keyJson, _ := json.Marshal(key)
+      fmt.Fprintf(&builder, "%s:%s", keyJson, jsonValue(values[i]))
+    }
+    builder.WriteByte('}')
+  }
+  builder.WriteByte('\n')
+  io.WriteString(LOG_OUTPUT, builder.String())
+}
+
+// hides secrets, and message text when redaction is on, keeping only its length
+func redactValue(key string, value interface{}, redactText bool) interface{} {
+  lower := strings.ToLower(key)
+  if isSecretKey(lower) {
+    return REDACTED
+  }
+  if redactText && LOG_TEXT_KEYS[lower] {
+    return fmt.Sprintf("[redacted %d chars]", len(fmt.Sprint(value)))
+  }
+  return value
+}
+
+// determines if the lowercased key matches one of LOG_SECRET_KEYS
+func isSecretKey(lower string) bool {
+  for _, pattern := range LOG_SECRET_KEYS {
+    if matched, _ := path.Match(pattern, lower); matched {
+      return true
+    }
+  }
+  return false
+}
+
+// converts errors, durations and other values without a JSON form into strings
+func plainValue(value interface{}) interface{} {
+  switch v := value.(type) {
+  case nil:
+    return nil
+  case error:
+    return v.Error()
+  case time.Duration:
+    return v.String()
+  case time.Time:
+    return v.Format(time.RFC3339Nano)
+  case fmt.Stringer:
+    return v.String()
+  }
+  return value
+}
+
+func jsonValue(value interface{}) string {
+  data, err := json.Marshal(plainValue(value))
+  if err != nil {
+    data, _ = json.Marshal(fmt.Sprint(value))
+  }
+  return string(data)
+}
+
+// formats a value for the text format, quoting it when it has spaces, quotes or is empty
+func textValue(value interface{}) string {
+  var text string
+  switch v := plainValue(value).(type) {
+  case string:
+    text = v
+  case map[string]string:
+    // maps are sorted so records are stable
+    keys := make([]string, 0, len(v))
+    for key := range v {
+      keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    pairs := make([]string, 0, len(keys))
+    for _, key := range keys {
+      pairs = append(pairs, fmt.Sprintf("%s:%s", key, v[key]))
+    }
+    text = strings.Join(pairs, ",")
+  default:
+    text = fmt.Sprint(v)
+  }
+  if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+    return fmt.Sprintf("%q", text)
+  }
+  return text
+}
+
+// gets the logger of the config, which carries the request id when the config was made for a request
+func (cfg *Config) Log() *Logger {
+  if cfg == nil || cfg.logger == nil {
+    return LOG
+  }
+  return cfg.logger
+}
+
+// gets the request id the config was made for, or an empty string
+func (cfg *Config) RequestId() string {
+  if cfg == nil {
+    return ""
+  }
+  return cfg.requestId
+}
+
+// gets the context to make Slack and db calls with, carrying the request id so queries are logged with it,
+// and the span of the config so they are traced within it
+// it derives from BASE_CONTEXT rather than the http request, so work keeps going after Slack is answered,
+// and is only cancelled when a shutdown runs out of time, or when the config was made with a shorter context
+func (cfg *Config) Context() context.Context {
+  ctx := BASE_CONTEXT
+  if cfg != nil && cfg.ctx != nil {
+    ctx = cfg.ctx
+  }
+  return ContextWithSpan(ContextWithRequestId(ctx, cfg.RequestId()), cfg.SpanContext())
+}
+
+// creates a copy of the config whose calls are made with the given context, such as one with a deadline
+// the copy shares everything else with the config, so it must not be changed
+func (cfg *Config) WithContext(ctx context.Context) *Config {
+  ctxCfg := *cfg
+  ctxCfg.ctx = ctx
+  return &ctxCfg
+}
+
+// creates a copy of the config for handling a single request, logging with the given request id
+// the copy shares everything else with the config, so it must not be changed
+func (cfg *Config) WithRequestId(requestId string) *Config {
+  reqCfg := *cfg
+  reqCfg.requestId = requestId
+  reqCfg.logger = LOG.With("request_id", requestId)
+  return &reqCfg
+}
+
+// type to record the status written by a handler
+type statusRecorder struct {
+  http.ResponseWriter
+  status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+  r.status = status
+  r.ResponseWriter.WriteHeader(status)
+}
+
+// gets the request id given by the caller, or a new one
+func requestIdFor(r *http.Request) string {
+  if requestId := r.Header.Get(REQUEST_ID_HEADER); requestId != "" && len(requestId) <= 64 {
+    return requestId
+  }
+  return NewRequestId()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "strings"
+  "testing"
+)
+
+// logs the record with the given settings, returning what was written
+func logRecord(settings LogSettings, msg string, args ...interface{}) string {
+  LOG_MTX.Lock()
+  output, previous := LOG_OUTPUT, LOG_SETTINGS
+  var buf bytes.Buffer
+  LOG_OUTPUT, LOG_SETTINGS = &buf, settings
+  LOG_MTX.Unlock()
+  defer func() {
+    LOG_MTX.Lock()
+    LOG_OUTPUT, LOG_SETTINGS = output, previous
+    LOG_MTX.Unlock()
+  }()
+  LOG.Info(msg, args...)
+  return buf.String()
+}
+
+func TestRedactValue(t *testing.T) {
+  tests := []struct {
+    key string
+    value interface{}
+    redactText bool
+    want interface{}
+  }{
+    {"token", "xoxb-1", false, REDACTED},
+    {"bot_token", "xoxb-1", false, REDACTED},
+    {"App_Token", "xapp-1", false, REDACTED},
+    {"Authorization", "Bearer abc", false, REDACTED},
+    {"signing_secret", "8f74", false, REDACTED},
+    {"SECRET", "8f74", false, REDACTED},
+    {"db_password", "hunter2", false, REDACTED},
+    {"x_api_key", "k", false, REDACTED},
+    {"tokens_used", 3, false, 3},
+    {"user", "U1", true, "U1"},
+    {"text", "hello", false, "hello"},
+    {"text", "hello", true, "[redacted 5 chars]"},
+    {"Body", "hi there", true, "[redacted 8 chars]"},
+    {"prompt", 1234, true, "[redacted 4 chars]"},
+  }
+  for _, test := range tests {
+    if got := redactValue(test.key, test.value, test.redactText); got != test.want {
+      t.Errorf("redactValue(%q, %v, %t) = %v, want %v", test.key, test.value, test.redactText, got, test.want)
+    }
+  }
+}
+
+func TestLogRecordJSON(t *testing.T) {
+  line := logRecord(LogSettings{Level: LEVEL_INFO, Format: LOG_FORMAT_JSON, RedactText: true}, "Sent message",
+    "user", "U1", "bot_token", "xoxb-1", "text", "hello", 42)
+  var record map[string]interface{}
+  if err := json.Unmarshal([]byte(line), &record); err != nil {
+    t.Fatalf("record %q isn't JSON: %v", line, err)
+  }
+  want := map[string]interface{}{
+    "level": "INFO",
+    "msg": "Sent message",
+    "user": "U1",
+    "bot_token": REDACTED,
+    "text": "[redacted 5 chars]",
+    "!BADKEY": float64(42),
+  }
+  for key, value := range want {
+    if record[key] != value {
+      t.Errorf("record[%q] = %v, want %v in %s", key, record[key], value, line)
+    }
+  }
+  if strings.Contains(line, "xoxb-1") || strings.Contains(line, "hello") {
+    t.Errorf("record %s has a secret or message text in it", line)
+  }
+}
+
+func TestLogRecordText(t *testing.T) {
+  line := logRecord(LogSettings{Level: LEVEL_INFO, Format: LOG_FORMAT_TEXT, RedactText: true}, "Sent message",
+    "Authorization", "Bearer abc", "body", "hi there", "channel", "C1", 7, "dangling")
+  for _, want := range []string{
+    `level=INFO msg="Sent message"`,
+    "Authorization=[redacted]",
+    `body="[redacted 8 chars]"`,
+    "channel=C1",
+    // a key that isn't a string is logged under !BADKEY, and its value is taken as the next key
+    "!BADKEY=7",
+    "!BADKEY=dangling",
+  } {
+    if !strings.Contains(line, want) {
+      t.Errorf("record %q is missing %q", line, want)
+    }
+  }
+  if strings.Contains(line, "Bearer") || strings.Contains(line, "hi there") {
+    t.Errorf("record %q has a secret or message text in it", line)
+  }
+
+  // message text is kept when redaction is off, while secrets are always hidden
+  line = logRecord(LogSettings{Level: LEVEL_INFO, Format: LOG_FORMAT_TEXT}, "Sent message", "text", "hello", "app_token", "xapp-1")
+  if !strings.Contains(line, "text=hello") || !strings.Contains(line, "app_token=[redacted]") {
+    t.Errorf("record %q without redaction, want the text kept and the token hidden", line)
+  }
+}
@@ -1,36 +1,28 @@
 package main
 
 import (
-	"bytes"
+  "bytes"
+  "crypto/subtle"
 	"encoding/json"
   "database/sql"
+  "errors"
 	"fmt"
 	"io"
-	"log"
+  "net"
 	"net/http"
+  "net/url"
 	"os"
+  "strconv"
 	"strings"
   "time"
-  "sync"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
   _ "github.com/lib/pq"
 )
 
-var API_TOKEN string
 const SERVICE_URL = "https://slack.com/api/"
-var MAIN_CHANNEL_ID string
-var MAIN_CHANNEL_NAME string
 const BOT_NAME = "c4c_checkin"
-var CUSTOM_ADMIN_APPENDIX string
-var ADMIN_USERS []string
-var OPEN_CHECKIN_STR string
-var CLOSE_CHECKIN_STR string
-var REMIND_CHECKIN_STR string
-var LAST_MESSAGE time.Time
-var LAST_MESSAGE_CUTOFF_MILLI time.Duration
-var MTX = sync.Mutex{}
 var DB *sql.DB
 
 // type to unmarshal JSON Slack responses into
@@ -42,82 +34,160 @@ type SlackResponse struct {
   Type string
   Challenge string
   Event SlackEvent
+  Event_id string
   Ts string
+  Permalink string
   User UserInfo
   Error string
 }
 
+// type to contain an error returned by the Slack API in the body of its response
+type SlackApiError struct {
+  Method string
+  Code string
+}
+
+func (e *SlackApiError) Error() string {
+  return fmt.Sprintf("%s failed: %s", e.Method, e.Code)
+}
+
+// type to contain a chat.postMessage call that failed without a reply from Slack, so the message may have been posted
+// it is not retried, as posting the message again could post it twice
+type UnconfirmedMessageError struct {
+  Err error
+}
+
+func (e *UnconfirmedMessageError) Error() string {
+  return fmt.Sprintf("message may have been sent: %s", e.Err)
+}
+
+func (e *UnconfirmedMessageError) Unwrap() error {
+  return e.Err
+}
+
+// type to contain a rate limited Slack API call, with how long Slack asked to wait before calling again
+type RateLimitError struct {
+  Method string
+  RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+  return fmt.Sprintf("%s rate limited, retry after %s", e.Method, e.RetryAfter)
+}
+
 // type to contain conversation info
 type ConversationList struct {
   Id, Name string
-  Is_channel, Is_group, Is_im, Is_member, Is_mpim, Is_private bool
+  Is_channel, Is_group, Is_im, Is_member, Is_mpim, Is_private, Is_archived bool
 }
 
 // type to contain Slack Event callback info
+// User is the id of the user of the event, user_change events also giving the user's details in UserInfo
+// Channel is the id of the channel of the event, rename events also giving the channel's details in Conversation
 type SlackEvent struct {
   Type string
+  Subtype string
   Text string
   User string
+  Channel string
+  Tab string
+  UserInfo *UserInfo `json:"-"`
+  Conversation *ConversationList `json:"-"`
 }
 
 // type to contain user info
 type UserInfo struct {
   Id string
   Real_name string
+  Tz string
+  Is_bot bool
+  Deleted bool
+  Profile UserProfile
+}
+
+// type to contain the profile of a user
+type UserProfile struct {
+  Real_name string
+  Display_name string
+  Image_72 string
 }
 
-// determines if time is within allowed cutoff for heroku dyno startup
-// returns true if it's okay to send another message, false otherwise
-func IsCutoffOK() bool {
-  defTime := time.Time{}
-  if LAST_MESSAGE == defTime {
-    return true
+// reads the user and channel of the event, which are ids in most events but the whole user in user_change events
+// and the whole channel in rename events
+func (e *SlackEvent) UnmarshalJSON(data []byte) error {
+  type plainEvent SlackEvent
+  var event struct {
+    plainEvent
+    User json.RawMessage
+    Channel json.RawMessage
+  }
+  if err := json.Unmarshal(data, &event); err != nil {
+    return err
+  }
+  *e = SlackEvent(event.plainEvent)
+  if len(event.User) > 0 && event.User[0] == '{' {
+    e.UserInfo = &UserInfo{}
+    if err := json.Unmarshal(event.User, e.UserInfo); err != nil {
+      return err
+    }
+    e.User = e.UserInfo.Id
+  } else if len(event.User) > 0 {
+    if err := json.Unmarshal(event.User, &e.User); err != nil {
+      return err
+    }
   }
-  curr := time.Now()
-  dur := curr.Sub(LAST_MESSAGE).Milliseconds()
-  return LAST_MESSAGE_CUTOFF_MILLI.Milliseconds() <= dur
+  if len(event.Channel) > 0 && event.Channel[0] == '{' {
+    e.Conversation = &ConversationList{}
+    if err := json.Unmarshal(event.Channel, e.Conversation); err != nil {
+      return err
+    }
+    e.Channel = e.Conversation.Id
+  } else if len(event.Channel) > 0 {
+    return json.Unmarshal(event.Channel, &e.Channel)
+  }
+  return nil
 }
-  
 
 // converts a string map to a JSON string
 func StringMapToPostBody(m map[string]string) string {
   if m == nil {
     return ""
   }
-  b := new(bytes.Buffer)
-	fmt.Fprintf(b, "{")
-	for key, value := range m {
-		fmt.Fprintf(b, "\"%s\":\"%s\",", key, value)
-	}
-	if len(m) > 0 {
-		b.Truncate(b.Len() - 1)
-	}
-	fmt.Fprintf(b, "}")
-	return b.String()
+  // marshal so that quotes and newlines in multi-line messages are escaped
+  b, err := json.Marshal(m)
+  if err != nil {
+    return ""
+  }
+  return string(b)
 }
 
-// conversts a string map to a url encoded param map
-func StringMapToGetBody(m map[string]string, trail bool) string {
-	b := new(bytes.Buffer)
-	fmt.Fprintf(b, "?")
-	for key, value := range m {
-		fmt.Fprintf(b, "%s=%s&", key, value)
-	}
-	if len(m) > 0 && !trail {
-		b.Truncate(b.Len() - 2)
-	}
-	return b.String()
+// conversts a string map to a url encoded query string
+func StringMapToGetBody(m map[string]string) string {
+  values := url.Values{}
+  for key, value := range m {
+    values.Set(key, value)
+  }
+  if len(values) == 0 {
+    return ""
+  }
+  return fmt.Sprintf("?%s", values.Encode())
 }
 
-// maps a list of userIds to list of usernames
-func MapIdsToNames(strs []string) []string {
+// maps a list of userIds to list of usernames, from the user directory
+// bots are mapped to an empty name, and users who can't be looked up or have no name to a mention of them
+func MapIdsToNames(cfg *Config, strs []string) []string {
   for pos, val := range strs {
     if val != "" {
-      name, _ := GetUsername(val)
-      if name != BOT_NAME {
-        strs[pos] = name
-      } else {
+      user, err := LookupUser(cfg, val)
+      if err != nil {
+        cfg.Log().Warn("Error looking up user, using a mention of them", "user", val, "err", err)
+        strs[pos] = fmt.Sprintf("<@%s>", val)
+      } else if user.IsBotUser() {
         strs[pos] = ""
+      } else if user.RealName == "" {
+        strs[pos] = fmt.Sprintf("<@%s>", val)
+      } else {
+        strs[pos] = user.RealName
       }
     }
   }
@@ -141,31 +211,50 @@ func FlattenList(strs []string) string {
 // sets up db by removing and recreating threads table
 func DBSetup() {
   if _, err := DB.Exec("DROP TABLE IF EXISTS threads;"); err != nil {
-    log.Printf("Error dropping db %q\n", err)
+    LOG.Error("Error dropping db", "err", err)
     return 
   }
 
   if _, err := DB.Exec("DROP TABLE IF EXISTS users;"); err != nil {
-    log.Printf("Error dropping db %q\n", err)
+    LOG.Error("Error dropping db", "err", err)
     return
   }
 
   if _, err := DB.Exec("CREATE TABLE threads (id TEXT PRIMARY KEY);"); err != nil {
-    log.Printf("Error creating db %q\n", err)
+    LOG.Error("Error creating db", "err", err)
     return 
   }
 
   if _, err := DB.Exec("CREATE TABLE users (id TEXT PRIMARY KEY);"); err != nil {
-    log.Printf("Error creating db %q\n", err)
+    LOG.Error("Error creating db", "err", err)
     return 
   }
 }
 
+// creates the tables that do not exist yet
+func SchemaSetup() {
+  HistorySetup()
+  BlockerSetup()
+  FollowupSetup()
+  AttendanceSetup()
+  RoleSetup()
+  AuditSetup()
+  SettingsSetup()
+  EventSetup()
+  DeliverySetup()
+  UserDirectorySetup()
+  ChannelSetup()
+  QueueSetup()
+  LeaderSetup()
+  ScheduledRunSetup()
+}
+
 // removes the given user from the db
 func UpdateUser(userId string) bool {
-  res, err := DB.Exec(fmt.Sprintf("DELETE FROM users WHERE id = '%s';", userId)) 
+  res, err := DB.Exec("DELETE FROM users WHERE id = $1;", userId)
   if err != nil {
-    log.Printf("Error deleting user form db %q\n", err)
+    LOG.Error("Error deleting user form db", "err", err)
+    return false
   }
   rowsAff, _ := res.RowsAffected()
   return rowsAff != 0
@@ -175,40 +264,38 @@ func UpdateUser(userId string) bool {
 func PostThreadId(id string) {
   DBSetup()
 
-  stmt := fmt.Sprintf("INSERT INTO threads VALUES ('%s');", id)
-  log.Println(stmt)
-  if _, err := DB.Exec(stmt); err != nil {
-    log.Printf("Error inserting into db %q\n", err)
+  LOG.Debug("Setting thread id", "thread_id", id)
+  if _, err := DB.Exec("INSERT INTO threads VALUES ($1);", id); err != nil {
+    LOG.Error("Error inserting into db", "err", err)
   }
 }
 
 // sets the given list of user ids in the db
 func PostUsers(users []string) {
   for _, user := range users {
-    stmt := fmt.Sprintf("INSERT INTO users VALUES ('%s');", user)
-    log.Println(stmt)
-    if _, err := DB.Exec(stmt); err != nil {
-      log.Printf("Error inserting into db %q\n", err)
+    LOG.Debug("Adding awaited user", "user", user)
+    if _, err := DB.Exec("INSERT INTO users VALUES ($1);", user); err != nil {
+      LOG.Error("Error inserting into db", "err", err)
     }
   }
 }
 
 // gets the list of users for the channel
-func GetUsers(channelId string, updateUsers bool) (users []string) {
+func GetUsers(cfg *Config, channelId string, updateUsers bool) (users []string) {
   if updateUsers {
-    SetUsers(channelId, false)
+    SetUsers(cfg, channelId, false)
   }
   //users = make([]string, 0)
-  rows, err := DB.Query("SELECT id FROM users;")
+  rows, err := DB.QueryContext(cfg.Context(), "SELECT id FROM users;")
   if err != nil {
-    log.Printf("Error getting users %q\n", err)
+    cfg.Log().Error("Error getting users", "err", err)
     return users
   }
   defer rows.Close()
   for rows.Next() {
     var user string
     if err = rows.Scan(&user); err != nil {
-      log.Printf("Error converting user id to string %q\n", err)
+      cfg.Log().Error("Error converting user id to string", "err", err)
     }
     users = append(users, user)
   }
@@ -220,19 +307,18 @@ func GetUsers(channelId string, updateUsers bool) (users []string) {
 func GetThreadId() (id string) {
   rows, err := DB.Query("SELECT id FROM threads;")
   if err != nil {
-    log.Printf("Error getting thread ids %q\n", err)
+    LOG.Error("Error getting thread ids", "err", err)
     return ""
   }
 
   defer rows.Close()
   if rows.Next() {
     if err = rows.Scan(&id); err != nil {
-      log.Printf("Error converting id to string %q\n", err)
+      LOG.Error("Error converting id to string", "err", err)
       return ""
     }
     return id
   }
-  log.Println("No rows found")
   return ""
 }
 
@@ -253,80 +339,136 @@ func CaptureResponseBody(r io.ReadCloser) string {
 	return builder.String()
 }
 
-// unmarshal get url-encoded string into a string map
-func UnmarshalGet(req string) map[string]string {
-  body := make(map[string]string)
-  split := strings.Split(req, "&")
-  for _, val := range split {
-    temp := strings.Split(val, "=")
-    body[temp[0]] = temp[1]
+// determines if the request carries the configured API auth token as a bearer token
+// always false when no API auth token is set, so the API is disabled by default
+func IsApiAuthorized(cfg *Config, r *http.Request) bool {
+  if cfg.ApiAuthToken == "" {
+    return false
   }
-  return body
+  expected := fmt.Sprintf("Bearer %s", cfg.ApiAuthToken)
+  return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
 }
 
-// determines if user with given userId is an admin user
-func IsAdminUser(userId string) bool {
-  for _, id := range ADMIN_USERS {
-    if userId == id {
-      return true
+// type for http handlers that are given the config of the bot
+type ConfigHandlerFunc func(cfg *Config, w http.ResponseWriter, r *http.Request)
+
+// wraps a handler that needs the config into a regular http handler
+// the handler is given the config in use when the request comes in, logging with the id of the request,
+// which is taken from the X-Request-Id header when given
+func WithConfig(handler ConfigHandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    requestId := requestIdFor(r)
+    cfg := CONFIG.Get().WithRequestId(requestId)
+    w.Header().Set(REQUEST_ID_HEADER, requestId)
+    recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+    start := time.Now()
+    // probes and scrapes are left out of traces, a request continuing the trace of its caller when it has a traceparent
+    var span *Span
+    if !QUIET_PATHS[r.URL.Path] {
+      cfg, span = cfg.WithSpan(ParseTraceparent(r.Header.Get(TRACEPARENT_HEADER))).StartSpan(
+        fmt.Sprintf("%s %s", r.Method, r.URL.Path), SPAN_KIND_SERVER, "http.method", r.Method, "http.target", r.URL.Path)
     }
+    handler(cfg, recorder, r)
+    span.SetAttributes("http.status_code", recorder.status)
+    if recorder.status >= http.StatusInternalServerError {
+      span.SetError(fmt.Errorf("responded with %d", recorder.status))
+    }
+    span.End()
+    logRequest := cfg.Log().Info
+    if QUIET_PATHS[r.URL.Path] {
+      logRequest = cfg.Log().Debug
+    }
+    logRequest("Handled request", "method", r.Method, "path", r.URL.Path, "status", recorder.status,
+      "duration", time.Since(start))
   }
-  return false
 }
 
 // handle http responses and error, and convert the response into SlackResponse or error
-func HandleResponse(res *http.Response, err error, logBody bool) (resp SlackResponse, retErr error) {
+func HandleResponse(cfg *Config, res *http.Response, err error, logBody bool) (resp SlackResponse, retErr error) {
 	if err != nil {
-		log.Println("Error in HandleResponse:")
-		log.Println(err)
     return SlackResponse{}, err
 	} else {
-		log.Printf("Status: %s", res.Status)
     body := CaptureResponseBody(res.Body)
     if logBody {
-		  log.Printf(body)
+      cfg.Log().Debug("Slack response", "body", body)
     }
     var resp SlackResponse
+    method := SlackMethod(res.Request)
+    if res.StatusCode == http.StatusTooManyRequests {
+      // Slack gives the seconds to wait, defaulting to a second if it doesn't
+      retryAfter, convErr := strconv.Atoi(res.Header.Get("Retry-After"))
+      if convErr != nil || retryAfter < 1 {
+        retryAfter = 1
+      }
+      resp.Error = "ratelimited"
+      SLACK_API_REQUESTS.WithLabelValues(method, resp.Error).Inc()
+      return resp, &RateLimitError{Method: method, RetryAfter: time.Duration(retryAfter) * time.Second}
+    }
+    // Slack answers most errors with a 200, so calls are counted by the error in the body rather than the status
     err = json.Unmarshal([]byte(body), &resp)
+    if err != nil {
+      SLACK_API_REQUESTS.WithLabelValues(method, "invalid_response").Inc()
+      return resp, err
+    }
+    SLACK_API_REQUESTS.WithLabelValues(method, SlackResult(resp.Ok, resp.Error)).Inc()
+    if !resp.Ok && resp.Error != "" {
+      cfg.Log().Warn("Slack API returned an error", "method", method, "slack_error", resp.Error)
+    }
     return resp, err
 	}
 }
 
 // perform request by creating client and using do method
-func DoRequest(url string, req *http.Request) (res *http.Response, err error) {
+// the call is logged with the request id of the config, without its params or body
+func DoRequest(cfg *Config, req *http.Request) (res *http.Response, err error) {
 	req.Header.Add("charset", "utf-8")
-	client := http.Client{}
-
-	log.Println("Pre-request")
-	defer log.Println("Post-request")
-	return client.Do(req)
+	client := http.Client{Timeout: SLACK_REQUEST_TIMEOUT}
+  method := SlackMethod(req)
+  cfg, span := cfg.StartSpan(fmt.Sprint("slack ", method), SPAN_KIND_CLIENT, "slack.method", method, "http.method", req.Method)
+  defer span.End()
+	req = req.WithContext(cfg.Context())
+
+  start := time.Now()
+	res, err = client.Do(req)
+  duration := time.Since(start)
+  SLACK_API_DURATION.WithLabelValues(method).Observe(duration.Seconds())
+  if err != nil {
+    span.SetError(err)
+    // calls with a response are counted once it is read, by HandleResponse
+    SLACK_API_REQUESTS.WithLabelValues(method, "request_failed").Inc()
+    cfg.Log().Error("Error calling Slack API", "method", method, "duration", duration, "err", err)
+    return res, err
+  }
+  span.SetAttributes("http.status_code", res.StatusCode)
+  cfg.Log().Debug("Called Slack API", "method", method, "status", res.StatusCode, "duration", duration)
+  return res, err
 }
 
 // perform HTTP GET request and return the response
-func PerformGet(url string, headers map[string]string, body map[string]string, includeAuth bool) (res *http.Response, err error) {
-	if includeAuth {
-		url = fmt.Sprintf("%s%s%stoken=%s", SERVICE_URL, url, StringMapToGetBody(body, true), API_TOKEN)
-	} else {
-		url = fmt.Sprint(SERVICE_URL, url, StringMapToGetBody(body, false))
-	}
+func PerformGet(cfg *Config, url string, headers map[string]string, body map[string]string, includeAuth bool) (res *http.Response, err error) {
+	url = fmt.Sprint(SERVICE_URL, url, StringMapToGetBody(body))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+  // the token is sent as a header rather than a query param so it never shows up in logged urls
+	if includeAuth {
+		authHeader := fmt.Sprintf("Bearer %s", cfg.ApiToken)
+		req.Header.Add("Authorization", authHeader)
+	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	for key, value := range headers {
 		req.Header.Add(key, value)
 	}
 
-	log.Printf("Performing GET for URL: %s\n", url)
-	return DoRequest(url, req)
+	return DoRequest(cfg, req)
 }
 
 // perform HTTP POST request and return the response
-func PerformPost(url string, headers map[string]string, body map[string]string, includeAuth bool) (res *http.Response, err error) {
+func PerformPost(cfg *Config, url string, headers map[string]string, body map[string]string, includeAuth bool) (res *http.Response, err error) {
 	url = fmt.Sprint(SERVICE_URL, url)
 	req, err := http.NewRequest("POST", url, strings.NewReader(StringMapToPostBody(body)))
 	if err != nil {
@@ -334,7 +476,7 @@ func PerformPost(url string, headers map[string]string, body map[string]string,
 	}
 
 	if includeAuth {
-		authHeader := fmt.Sprintf("Bearer %s", API_TOKEN)
+		authHeader := fmt.Sprintf("Bearer %s", cfg.ApiToken)
 		req.Header.Add("Authorization", authHeader)
 	}
 	req.Header.Add("Content-Type", "application/json")
@@ -343,13 +485,31 @@ func PerformPost(url string, headers map[string]string, body map[string]string,
 		req.Header.Add(key, value)
 	}
 
-  log.Printf("Performing POST for URL: %s with Body:\n", url)
-  log.Println(StringMapToPostBody(body))
-	return DoRequest(url, req)
+  cfg.Log().Debug("Slack request", "method", SlackMethod(req), "body", StringMapToPostBody(body))
+	return DoRequest(cfg, req)
+}
+
+// perform HTTP POST request with the given value marshalled as the JSON body, for Slack methods
+// taking nested objects such as views, and convert the response into SlackResponse
+func PerformJsonPost(cfg *Config, url string, body interface{}) (resp SlackResponse, err error) {
+  data, err := json.Marshal(body)
+  if err != nil {
+    return SlackResponse{}, err
+  }
+	url = fmt.Sprint(SERVICE_URL, url)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return SlackResponse{}, err
+	}
+  req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cfg.ApiToken))
+	req.Header.Add("Content-Type", "application/json")
+
+  res, err := DoRequest(cfg, req)
+  return HandleResponse(cfg, res, err, false)
 }
 
 // send the given message to the given channel and optional thread, then return the resulting SlackResponse
-func SendMessage(message, channelId, thread string) (body SlackResponse, err error) {
+func SendMessage(cfg *Config, message, channelId, thread string) (body SlackResponse, err error) {
 	params := make(map[string]string)
 	params["text"] = message
 	if thread != "" {
@@ -357,39 +517,37 @@ func SendMessage(message, channelId, thread string) (body SlackResponse, err err
 	}
 	params["channel"] = channelId
 
-	res, err := PerformPost("chat.postMessage", nil, params, true)
+	res, err := PerformPost(cfg, "chat.postMessage", nil, params, true)
 
-  return HandleResponse(res, err, false)
+  return HandleResponse(cfg, res, err, false)
 }
 
 // hit up the Slack test endpoint
-func TestSlack(error bool, message string) {
+func TestSlack(cfg *Config, error bool, message string) {
 	var params string
 	if error {
-		fmt.Printf("Error test for %s", message)
+		cfg.Log().Info("Testing Slack API error", "text", message)
 		params = fmt.Sprintf("error=%s", message)
 	} else {
-		fmt.Printf("Testing %s", message)
+		cfg.Log().Info("Testing Slack API", "text", message)
 		params = fmt.Sprintf("test_message=%s", message)
 	}
 	url := fmt.Sprintf("api.test?%s", params)
-	res, err := PerformPost(url, nil, nil, false)
+	res, err := PerformPost(cfg, url, nil, nil, false)
 
-	HandleResponse(res, err, true)
+	HandleResponse(cfg, res, err, true)
 }
 
 // get all (public) channels in the Slack workspace and optionally log the response, 
 // then return a map of names to ConversationList
-// if MAIN_CHANNEL_ID is not set, then it is updated 
-func GetChannels(logAnswer bool) (channels map[string]ConversationList) {
+// if the standup channel id is not set, then it is updated 
+func GetChannels(cfg *Config, logAnswer bool) (channels map[string]ConversationList) {
 	url := "conversations.list"
-	res, err := PerformGet(url, nil, nil, true)
-  body, err := HandleResponse(res, err, false)
+	res, err := PerformGet(cfg, url, nil, nil, true)
+  body, err := HandleResponse(cfg, res, err, false)
 
 	if err != nil || !body.Ok {
-		log.Println("Error in GetChannels:")
-		log.Println(err)
-    log.Printf("body.Ok: %t\n", body.Ok)
+    cfg.Log().Error("Error getting channels", "err", err, "slack_error", body.Error)
     return nil
 	}
 
@@ -398,317 +556,518 @@ func GetChannels(logAnswer bool) (channels map[string]ConversationList) {
     channels[item.Name] = item
   }
   if logAnswer {
-    log.Println(channels)
+    cfg.Log().Info("Got channels", "channels", len(channels))
   }
 
-  if MAIN_CHANNEL_ID == "" {
-    MAIN_CHANNEL_ID = channels[MAIN_CHANNEL_NAME].Id
-    log.Printf("Main Channel Name: %s, Id: %s\n", MAIN_CHANNEL_NAME, MAIN_CHANNEL_ID)
-  }
   return channels
 }
 
-// sets the list of users in the db for the given channel
-func SetUsers(channelId string, logAnswer bool) {
+// looks up the id of the standup channel by its name, or an empty string if it is not found
+// a channel renamed since the name was configured is found by its previous name
+func LookupChannelId(cfg *Config) (channelId string) {
+  channels := GetChannels(cfg, false)
+  if channel, ok := channels[cfg.Standup.ChannelName]; ok {
+    channelId = channel.Id
+    SaveChannel(cfg, channel.Id, channel.Name, channel.Is_archived)
+  } else if channelId = FindRenamedChannel(cfg, cfg.Standup.ChannelName); channelId != "" {
+    cfg.Log().Warn("Main channel was renamed, update the configured channel name", "channel_name", cfg.Standup.ChannelName)
+  }
+  cfg.Log().Info("Found main channel", "channel_name", cfg.Standup.ChannelName, "channel_id", channelId)
+  return channelId
+}
+
+// gets the members of the given channel
+// returns false if the members could not be fetched
+func GetChannelMembers(cfg *Config, channelId string) (members []string, ok bool) {
   url := "conversations.members"
   params := make(map[string]string)
   params["channel"] = channelId
-  res, err := PerformGet(url, nil, params, true)
-  body, err := HandleResponse(res, err, false)
+  res, err := PerformGet(cfg, url, nil, params, true)
+  body, err := HandleResponse(cfg, res, err, false)
 
   if err != nil || !body.Ok {
-    log.Println("Error in SetUsers:")
-    log.Println(err)
-    log.Printf("body.Ok: %t\n", body.Ok)
-    log.Printf("response body error: %s\n", body.Error)
+    cfg.Log().Error("Error getting channel members", "channel_id", channelId, "err", err, "slack_error", body.Error)
+    return nil, false
+  }
+  return body.Members, true
+}
+
+// sets the list of users in the db for the given channel
+func SetUsers(cfg *Config, channelId string, logAnswer bool) {
+  members, ok := GetChannelMembers(cfg, channelId)
+  if !ok {
     return 
   }
 
   if logAnswer {
-    log.Println(body.Members)
+    cfg.Log().Info("Got channel members", "channel_id", channelId, "members", members)
   }
 
-  PostUsers(body.Members)
+  PostUsers(members)
 }
 
-// send the given message to the given user by userId
-func MessageUser(userId, message string) {
-  url := "conversations.open"
-  params := make(map[string]string)
-  params["users"] = userId
-  res, err := PerformPost(url, nil, params, true)
-  body, err := HandleResponse(res, err, false)
-
-  if err != nil {
-    log.Println("Error in HandleCheckin")
+// send the given message to the given user by userId, through the cached DM channel with them
+// a cached channel Slack no longer knows is opened again once
+func MessageUser(cfg *Config, userId, message string) error {
+  for retried := false; ; retried = true {
+    channelId, err := GetDMChannel(cfg, userId)
+    if err != nil {
+      cfg.Log().Error("Error opening DM", "user", userId, "err", err)
+      return err
+    }
+    body, err := SendMessage(cfg, message, channelId, "")
+    var rateLimitErr *RateLimitError
+    if err != nil && !errors.As(err, &rateLimitErr) && !IsUnsentError(err) {
+      err = &UnconfirmedMessageError{Err: err}
+    }
+    if err == nil && !body.Ok {
+      err = &SlackApiError{Method: "chat.postMessage", Code: body.Error}
+      if STALE_DM_ERRORS[body.Error] && !retried {
+        ForgetDMChannel(cfg, userId)
+        continue
+      }
+    }
+    if err != nil {
+      cfg.Log().Error("Error sending DM", "user", userId, "err", err)
+    }
+    return err
   }
-
-  newChannelId := body.Channel["id"]
-
-  SendMessage(message, newChannelId, "")
 }
 
-// get the username of a userId
-func GetUsername(userId string) (name string, err error) {
-  url := "users.info"
-  params := make(map[string]string)
-  params["user"] = userId
-  res, err := PerformGet(url, nil, params, true)
-
-  body, _ := HandleResponse(res, err, false)
-
-  return body.User.Real_name, err
+// determines if a Slack API call failed before its request was sent, as when Slack could not be connected to
+func IsUnsentError(err error) bool {
+  var opErr *net.OpError
+  return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 // the handler for the /test endpoint
-func TestSuccess(w http.ResponseWriter, r *http.Request) {
-	TestSlack(false, r.URL.Path)
+func TestSuccess(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	TestSlack(cfg, false, r.URL.Path)
 	w.Write([]byte("Tested Success"))
 }
 
 // the handler for the /testError endpoint
-func TestError(w http.ResponseWriter, r *http.Request) {
-	TestSlack(true, r.URL.Path)
+func TestError(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	TestSlack(cfg, true, r.URL.Path)
 	w.Write([]byte("Tested Error"))
 }
 
 // the handler for the /close endpoint
-// if the given user_id is not part of the admin users global var or empty,
-// then the function does not proceed
-func CloseCheckinHandler(w http.ResponseWriter, r *http.Request) {
-  req := CaptureResponseBody(r.Body)
-  reqBody := UnmarshalGet(req)
-  userId := reqBody["user_id"]
-  if !IsAdminUser(userId) && userId != "" {
+// if the given user_id is not allowed to run sessions, then the function does not proceed
+func CloseCheckinHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if !HasPermission(cfg, req.UserId, PERM_RUN_SESSIONS) {
     w.Write([]byte("You are not an admin"))
     return
   }
-  CloseCheckin()
-  w.Write([]byte(fmt.Sprintf("Checkin Closed%s", CUSTOM_ADMIN_APPENDIX)))
+  req.Text = "close"
+  w.Write([]byte(RunSlashCommand(cfg, req)))
 }
 
-func CloseCheckin() {
-  uncompletedUsers := FlattenList(MapIdsToNames(GetUsers("", false)))
+// closes the open checkin session, posting who did not complete it in the thread
+// returns false without doing anything if no session is open, so repeated closes are no-ops
+// returns an error without doing anything if the standup could not be locked
+func CloseCheckin(cfg *Config) (bool, error) {
+  cfg, span := cfg.StartSpan("CloseCheckin", SPAN_KIND_INTERNAL)
+  defer span.End()
+  unlock, err := LockStandup(cfg.Standup.Name)
+  if err != nil {
+    span.SetError(err)
+    return false, err
+  }
+  defer unlock()
+  thread_id := GetThreadId()
+  if thread_id == "" {
+    return false, nil
+  }
+  closeSession(cfg, thread_id)
+  return true, nil
+}
+
+// closes the session posted in the given thread, the standup being locked by the caller
+func closeSession(cfg *Config, thread_id string) {
+  uncompletedUsers := FlattenList(MapIdsToNames(cfg, GetUsers(cfg, "", false)))
   var uncompletedMessage string
   if uncompletedUsers == "" {
     uncompletedMessage = ""
   } else {
     uncompletedMessage = fmt.Sprintf(" These users did not complete the checkin: %s", uncompletedUsers)
   }
-  thread_id := GetThreadId()
-  SendMessage(fmt.Sprintf("Checkin is now closed.%s", uncompletedMessage), MAIN_CHANNEL_ID, thread_id)
+  SendMessage(cfg, fmt.Sprintf("Checkin is now closed.%s", uncompletedMessage), GetSessionChannelId(cfg), thread_id)
   PostThreadId("")
+  EndSession(cfg)
+  SESSIONS_CLOSED.Inc()
 }
 
 // Opens checkin by getting the main channel id, notifying users, opening the 
-// main thread message in the MAIN_CHANNEL_NAME, and saving the thread id
-func OpenCheckin() {
-  if MAIN_CHANNEL_ID == "" {
-    GetChannels(false)
+// main thread message in the standup channel, and saving the thread id
+// returns false without doing anything if a session is already open, so repeated opens are no-ops
+// a session left open for longer than STALE_SESSION_AFTER is closed first rather than blocking every open after it
+// returns an error without doing anything if the standup could not be locked
+func OpenCheckin(cfg *Config) (bool, error) {
+  cfg, span := cfg.StartSpan("OpenCheckin", SPAN_KIND_INTERNAL)
+  defer span.End()
+  unlock, err := LockStandup(cfg.Standup.Name)
+  if err != nil {
+    span.SetError(err)
+    return false, err
+  }
+  defer unlock()
+  if threadId := GetThreadId(); threadId != "" {
+    openedAt, ok := GetSessionOpenedAt(cfg)
+    if ok && time.Since(openedAt) < STALE_SESSION_AFTER {
+      return false, nil
+    }
+    cfg.Log().Warn("Closing the stale checkin session before opening a new one", "opened_at", openedAt)
+    closeSession(cfg, threadId)
   }
+  cfg = CONFIG.WithChannelId(cfg)
 
-  loc, _ := time.LoadLocation("America/New_York")
-  time := time.Now().In(loc).Format("Jan 2, 2006 at 3:04pm")
-  body, _ := SendMessage(fmt.Sprintf("Here are the results for the standup on `%s`", time), MAIN_CHANNEL_ID, "")
+  time := time.Now().In(GetLocation()).Format("Jan 2, 2006 at 3:04pm")
+  body, _ := SendMessage(cfg, fmt.Sprintf("Here are the results for the standup on `%s`", time), cfg.Standup.ChannelId, "")
   PostThreadId(body.Ts)
 
-  userList := GetUsers(MAIN_CHANNEL_ID, true)
-  log.Println("User List:")
-  log.Println(userList)
-  for _, userId := range userList {
-    MessageUser(userId, "Hey! It's time for your checkin. Let me know what you're gonna do, how long you think it will take, and when you plan on working on this -- *in one message please*. Thanks :)")
-  }
+  userList := RemoveOutOfOfficeUsers(cfg, GetUsers(cfg, cfg.Standup.ChannelId, true))
+  cfg.Log().Info("Opening checkin", "users", userList)
+  span.SetAttributes("checkin.users", len(userList))
+  sessionId := StartSession(cfg, cfg.Standup.ChannelId, body.Ts, userList)
+  SESSIONS_OPENED.Inc()
+  FanOutMessage(cfg, sessionId, DELIVERY_PROMPT, userList, cfg.Standup.Prompt)
+  return true, nil
 }
 
 // Reminds users who have not completed checkin to complete checkin
-func RemindCheckin() {
-  for _, userId := range GetUsers("", false) {
-    MessageUser(userId, "Don't forget to complete the checkin session!")
-  }
-}
-
-// log global vars to console
-func LogVars(w http.ResponseWriter, r *http.Request) {
-  log.Println("API_TOKEN: ")
-  log.Println(API_TOKEN)
-  log.Println("SERVICE_URL")
-  log.Println(SERVICE_URL)
-  log.Println("MAIN_CHANNEL_NAME")
-  log.Println(MAIN_CHANNEL_NAME)
-  log.Println("MAIN_CHANNEL_ID")
-  log.Println(MAIN_CHANNEL_ID)
-  log.Println("CURRENT_THREAD_ID")
-  log.Println(GetThreadId())
-  log.Println("USER_LIST")
-  log.Println(GetUsers("", false))
-  log.Println("BOT_NAME")
-  log.Println(BOT_NAME)
-  log.Println("OPEN_CHECKIN_STR")
-  log.Println(OPEN_CHECKIN_STR)
-  log.Println("CLOSE_CHECKIN_STR")
-  log.Println(CLOSE_CHECKIN_STR)
-  log.Println("REMIND_CHECKIN_STR")
-  log.Println(REMIND_CHECKIN_STR)
-  log.Println("LAST_MESSAGE")
-  log.Println(LAST_MESSAGE.Format("Jan 2, 2006 15:04:05.123"))
-  log.Println("LAST_MESSAGE_CUTOFF_MILLI")
-  log.Println(string(LAST_MESSAGE_CUTOFF_MILLI.Milliseconds()))
-  w.Write([]byte("Done"))
+// users already reminded in this session are left out, so repeated reminders are no-ops
+// returns false without doing anything if no session is open
+// returns an error without doing anything if the standup could not be locked
+func RemindCheckin(cfg *Config) (bool, error) {
+  cfg, span := cfg.StartSpan("RemindCheckin", SPAN_KIND_INTERNAL)
+  defer span.End()
+  unlock, err := LockStandup(cfg.Standup.Name)
+  if err != nil {
+    span.SetError(err)
+    return false, err
+  }
+  defer unlock()
+  if GetThreadId() == "" {
+    return false, nil
+  }
+  MarkSessionReminded(cfg)
+  sessionId := GetCurrentSessionId(cfg)
+  reminded := GetRemindedUsers(cfg, sessionId)
+  var userList []string
+  for _, userId := range GetUsers(cfg, "", false) {
+    if !reminded[userId] {
+      userList = append(userList, userId)
+    }
+  }
+  span.SetAttributes("checkin.users", len(userList))
+  if len(userList) == 0 {
+    cfg.Log().Info("Everyone awaited was already reminded")
+    return true, nil
+  }
+  for _, delivery := range FanOutMessage(cfg, sessionId, DELIVERY_REMINDER, userList, cfg.Standup.Reminder) {
+    if delivery.Status == DELIVERY_SENT {
+      REMINDERS_SENT.Inc()
+    }
+  }
+  return true, nil
 }
 
 // handle / endpoint callback
 // if type is 'url_verification', then returns verificaiton token
-// if type is 'event_callback', event type is 'message', and initiator is not the bot, then 
-// handle user message response
-// if type is 'event_callback' and event type is 'app_mention', then open or close depending on text
-func HandleCallback(w http.ResponseWriter, r *http.Request) {
+// if type is 'event_callback', then the event is acknowledged right away and queued for ProcessEvent,
+// so the Slack calls made while handling it don't run into Slack's timeout
+func HandleCallback(cfg *Config, w http.ResponseWriter, r *http.Request) {
   req := CaptureResponseBody(r.Body)
   var body SlackResponse
   json.Unmarshal([]byte(req), &body)
   if body.Type == "url_verification" {
     w.Write([]byte(body.Challenge))
-    log.Println("Slack API Callback Url Verified")
+    cfg.Log().Info("Slack API callback url verified")
     return
-  } else if body.Type == "event_callback" && body.Event.Type == "message" {
-    w.Write([]byte("Message Received"))
-    name, err := GetUsername(body.Event.User)
-    if name == BOT_NAME {
-      return
+  } else if body.Type != "event_callback" {
+    // the body is not logged, as callbacks carry the verification token
+    cfg.Log().Warn("Unknown callback", "type", body.Type)
+    w.Write([]byte("HandleCallback but no valid condition found"))
+    return
+  }
+
+  // Slack retries events that were not acknowledged in time, so each event is only handled once
+  if retry := r.Header.Get("X-Slack-Retry-Num"); retry != "" {
+    cfg.Log().Info("Slack retried event", "event_id", body.Event_id, "retry", retry, "reason", r.Header.Get("X-Slack-Retry-Reason"))
+  }
+  EVENTS_RECEIVED.WithLabelValues(body.Event.Type).Inc()
+  queued, err := QueueEvent(cfg, body.Event_id, json.RawMessage(req))
+  if err != nil {
+    // neither the claim nor the job were stored, so a retry of the event is handled again
+    cfg.Log().Error("Error queueing event, handling it now", "event_id", body.Event_id, "err", err)
+    ProcessEvent(cfg, body)
+  } else if !queued {
+    cfg.Log().Info("Skipping duplicate event", "event_id", body.Event_id)
+    w.Write([]byte("Duplicate event"))
+    return
+  } else {
+    cfg.Log().Info("Received event", "event_id", body.Event_id, "type", body.Event.Type)
+  }
+  w.Write([]byte("Event received"))
+}
+
+// handles a Slack event callback
+// if event type is 'message' from a user, without a subtype, and initiator is not the bot, then handle user message response
+// if event type is 'app_mention', then open or close depending on text
+// if event type is 'app_home_opened', then publish the App Home tab
+// if event type is 'user_change', then update the user directory
+// if event type is 'member_joined_channel' or 'member_left_channel', then update the open session's roster
+// if event type is a channel rename or (un)archive, then update the stored channel
+// returns an error when the event should be retried
+func ProcessEvent(cfg *Config, body SlackResponse) error {
+  if body.Event.Type == "message" {
+    // edits, deletions, joins and bot posts come with a subtype, and some of them without a user, none being a checkin
+    if body.Event.User == "" || body.Event.Subtype != "" {
+      cfg.Log().Debug("Ignoring message", "event_id", body.Event_id, "subtype", body.Event.Subtype)
+      return nil
     }
-    log.Printf("Handle Message Callback for user: %s\n", body.Event.User)
+    user, err := LookupUser(cfg, body.Event.User)
+    if err != nil {
+      // nothing has been done for the response yet, so it is safe to retry
+      return fmt.Errorf("could not get the name of %s: %s", body.Event.User, err)
+    }
+    if user.IsBotUser() {
+      return nil
+    }
+    if !HasPermission(cfg, body.Event.User, PERM_CHECKIN) {
+      cfg.Log().Warn("User is not allowed to check in", "user", body.Event.User)
+      MessageUser(cfg, body.Event.User, "You aren't allowed to check in to this standup, so your message wasn't recorded.")
+      return nil
+    }
+    name := user.RealName
+    cfg.Log().Info("Handling message", "event_id", body.Event_id, "user", body.Event.User)
     threadId := GetThreadId()
     if threadId == "" {
-      MessageUser(body.Event.User, "There is currently no open checkin session. Please try again later.")
-      return
+      MessageUser(cfg, body.Event.User, "There is currently no open checkin session. Please try again later.")
+      return nil
     }
+    channelId := GetSessionChannelId(cfg)
 
     if !UpdateUser(body.Event.User) {
-      MessageUser(body.Event.User, "Cannot change body once sent, please go to thread and post followup.")
-      return
+      MessageUser(cfg, body.Event.User, "Cannot change body once sent, please go to thread and post followup.")
+      return nil
+    }
+    responseId := RecordResponse(cfg, body.Event.User, name, body.Event.Text)
+    RESPONSES_RECEIVED.Inc()
+    blockers := TrackBlockers(cfg, body.Event.User, name, responseId, cfg.BlockerDetector().Detect(body.Event.Text))
+
+    MessageUser(cfg, body.Event.User, fmt.Sprintf("Hey, thanks for your response! You should soon see it in <#%s> under the most recent thread. Hope the rest of your day goes well ;)", channelId))
+    cfg.Log().Info("Received response", "user", body.Event.User, "name", name, "text", body.Event.Text)
+    response := fmt.Sprintf("%s's Response: %s", name, body.Event.Text)
+    if len(blockers) > 0 {
+      response = fmt.Sprintf(":warning: *Blocker* %s", response)
+    }
+    messageResp, err := SendMessage(cfg, response, channelId, threadId)
+    if err != nil || !messageResp.Ok {
+      cfg.Log().Error("Error posting response to the thread", "user", body.Event.User, "err", err, "slack_error", messageResp.Error)
+    }
+    EscalateBlockers(cfg, blockers, threadId)
+    TrackFollowups(cfg, body.Event.User, name, body.Event.Text, responseId, messageResp.Ts)
+  } else if body.Event.Type == "app_mention" {
+    if !HasPermission(cfg, body.Event.User, PERM_RUN_SESSIONS) {
+      cfg.Log().Warn("User is not allowed to run sessions from an app mention", "user", body.Event.User)
+      return nil
     }
 
-    if err != nil {
-      log.Println("Error in HandleCallback:")
-      log.Println(err)
-    }
-    MessageUser(body.Event.User, fmt.Sprintf("Hey, thanks for your response! You should soon see it in <#%s> under the most recent thread. Hope the rest of your day goes well ;)", MAIN_CHANNEL_ID))
-    log.Printf("%s's Response: %s", name, body.Event.Text)
-    messageResp, err := SendMessage(fmt.Sprintf("%s's Response: %s", name, body.Event.Text), MAIN_CHANNEL_ID, threadId)
-    log.Println(messageResp.Error)
-  } else if body.Type == "event_callback" && body.Event.Type == "app_mention" {
-    MTX.Lock()
-    if !IsCutoffOK() {
-      log.Println("Cutoff too soon in app mention callback")
-      w.Write([]byte("Cutoff too soon in app mention callback"))
-      MTX.Unlock()
-      return
-    }
-    LAST_MESSAGE = time.Now()
-
-    if strings.Contains(body.Event.Text, OPEN_CHECKIN_STR) {
-      OpenCheckin()
-      log.Println("Checkin Opened by Event Callback")
-      w.Write([]byte("Checkin opened"))
-    } else if strings.Contains(body.Event.Text, CLOSE_CHECKIN_STR) {
-      CloseCheckin()
-      log.Println("Checkin Closed by Event Callback")
-      w.Write([]byte("Checkin closed"))
-    } else if strings.Contains(body.Event.Text, REMIND_CHECKIN_STR) { 
-      RemindCheckin()
-      log.Println("Remind Awaiting by Event Callback")
-      w.Write([]byte("Checkin reminded"))
+    // duplicate triggers are no-ops, since a session is only opened, closed or reminded in its expected state,
+    // and a trigger that could not lock the standup is retried along with the event
+    if strings.Contains(body.Event.Text, cfg.Standup.OpenMention) {
+      opened, err := OpenCheckin(cfg)
+      if err != nil {
+        return err
+      }
+      if opened {
+        RecordAudit(cfg, body.Event.User, "open", SOURCE_MENTION, "")
+        cfg.Log().Info("Checkin opened by app mention", "user", body.Event.User)
+      }
+    } else if strings.Contains(body.Event.Text, cfg.Standup.CloseMention) {
+      closed, err := CloseCheckin(cfg)
+      if err != nil {
+        return err
+      }
+      if closed {
+        RecordAudit(cfg, body.Event.User, "close", SOURCE_MENTION, "")
+        cfg.Log().Info("Checkin closed by app mention", "user", body.Event.User)
+      }
+    } else if strings.Contains(body.Event.Text, cfg.Standup.RemindMention) { 
+      reminded, err := RemindCheckin(cfg)
+      if err != nil {
+        return err
+      }
+      if reminded {
+        RecordAudit(cfg, body.Event.User, "remind", SOURCE_MENTION, "")
+        cfg.Log().Info("Checkin reminded by app mention", "user", body.Event.User)
+      }
     } else {
-      log.Println("No action performed in app mention callback")
-      w.Write([]byte("No action performed in app mention callback"))
+      cfg.Log().Info("No action performed for app mention", "user", body.Event.User)
     }
-    MTX.Unlock()
+  } else if body.Event.Type == "app_home_opened" {
+    if body.Event.Tab == "home" {
+      PublishHome(cfg, body.Event.User)
+    }
+  } else if body.Event.Type == "user_change" {
+    HandleUserChange(cfg, body.Event.UserInfo)
+  } else if body.Event.Type == "member_joined_channel" {
+    return HandleMemberJoined(cfg, body.Event.User, body.Event.Channel)
+  } else if body.Event.Type == "member_left_channel" {
+    return HandleMemberLeft(cfg, body.Event.User, body.Event.Channel)
+  } else if body.Event.Type == "channel_rename" || body.Event.Type == "group_rename" {
+    HandleChannelRename(cfg, body.Event.Conversation)
+  } else if body.Event.Type == "channel_archive" || body.Event.Type == "group_archive" {
+    HandleChannelArchive(cfg, body.Event.Channel, true)
+  } else if body.Event.Type == "channel_unarchive" || body.Event.Type == "group_unarchive" {
+    HandleChannelArchive(cfg, body.Event.Channel, false)
   } else {
-    log.Println("Unknown callback:")
-    log.Println(req)
-    w.Write([]byte("HandleCallback but no valid condition found"))
+    cfg.Log().Warn("Unknown event type", "type", body.Event.Type)
   }
+  return nil
 }
 
 // handles the checkin initiation endpoint
-// updates the MAIN_CHANNEL_ID global var, gets the users in the main channel, 
+// updates the standup channel id, gets the users in the main channel, 
 // and notifies them about the checkin
-// if the given user_id is not part of the admin users global var or empty,
-// then the function does not proceed
-func HandleCheckin(w http.ResponseWriter, r *http.Request) {
-  req := CaptureResponseBody(r.Body)
-  reqBody := UnmarshalGet(req)
-  userId := reqBody["user_id"]
-  if !IsAdminUser(userId) && userId != "" {
+// if the given user_id is not allowed to run sessions, then the function does not proceed
+func HandleCheckin(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if !HasPermission(cfg, req.UserId, PERM_RUN_SESSIONS) {
     w.Write([]byte("You are not an admin"))
     return
   }
-
-  OpenCheckin()
-
-  w.Write([]byte(fmt.Sprintf("Checkin Sent%s", CUSTOM_ADMIN_APPENDIX)))
+  req.Text = "open"
+  w.Write([]byte(RunSlashCommand(cfg, req)))
 }
 
 // reminds the users who have not yet completed their checkin that they need to complete it
-// if the given user_id is not part of the admin users global var or empty,
-// then the function does not proceed
-func RemindAwaiting(w http.ResponseWriter, r *http.Request) {
-  threadId := GetThreadId()
-  if threadId == "" {
-    w.Write([]byte("There is currently no open checkin session, try again later ;)"))
+// if the given user_id is not allowed to run sessions, then the function does not proceed
+func RemindAwaiting(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  req, err := ParseSlashRequest(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
   }
-
-  RemindCheckin()
-  w.Write([]byte(fmt.Sprintf("Users have been notified%s", CUSTOM_ADMIN_APPENDIX)))
+  if !HasPermission(cfg, req.UserId, PERM_RUN_SESSIONS) {
+    w.Write([]byte("You are not an admin"))
+    return
+  }
+  req.Text = "remind"
+  w.Write([]byte(RunSlashCommand(cfg, req)))
 }
 
 func main() {
-  // sets up necessary env vars
-  var port string
+  // loads the .env file into the environment, then the config file and env overrides
   err := godotenv.Load()
   if err != nil {
-    log.Println(err)
+    LOG.Info("No .env file loaded", "err", err)
+  }
+  cfg, err := LoadConfig(os.Getenv("CONFIG_FILE"))
+  if err != nil {
+    LOG.Fatal("Error loading config", "err", err)
+  }
+
+  // the export subcommand only needs the db and runs instead of the server
+  if len(os.Args) > 1 && os.Args[1] == "export" {
+    if cfg.Standup.Name == "" {
+      cfg.Standup.Name = cfg.Standup.ChannelName
+    }
+    DB, err = sql.Open(METRICS_DB_DRIVER, cfg.DatabaseUrl)
+    if err != nil {
+      LOG.Fatal("Error opening db connection", "err", err)
+    }
+    HistorySetup()
+    AttendanceSetup()
+    os.Exit(RunExportCommand(cfg, os.Args[2:]))
   }
-  if os.Getenv("ENVIRONMENT") == "development" {
-	  port = os.Getenv("PORT")
-  } else {
-    port = fmt.Sprintf(":%s", os.Getenv("PORT"))
-  }
-	API_TOKEN = os.Getenv("API_TOKEN")
-  MAIN_CHANNEL_ID = os.Getenv("MAIN_CHANNEL_ID")
-  MAIN_CHANNEL_NAME = os.Getenv("MAIN_CHANNEL_NAME")
-  ADMIN_USERS = strings.Split(os.Getenv("ADMIN_USERS"), ",")
-  CUSTOM_ADMIN_APPENDIX = os.Getenv("CUSTOM_ADMIN_APPENDIX")
-  OPEN_CHECKIN_STR = os.Getenv("OPEN_CHECKIN_STR")
-  CLOSE_CHECKIN_STR = os.Getenv("CLOSE_CHECKIN_STR")
-  REMIND_CHECKIN_STR = os.Getenv("REMIND_CHECKIN_STR")
-  if port == "" || port == ":" || API_TOKEN == "" || MAIN_CHANNEL_NAME == "" {
-		log.Fatal("PORT, MAIN_CHANNEL_NAME, and API_TOKEN must be set")
-	}
-  LAST_MESSAGE_CUTOFF_MILLI, _ = time.ParseDuration("1m")
 
-  if OPEN_CHECKIN_STR == CLOSE_CHECKIN_STR {
-    log.Println("OPEN_CHECKIN_STR and CLOSE_CHECKIN_STR are the same, cannot open or close checkin using reminders")
+  if err = cfg.Validate(); err != nil {
+    LOG.Fatal("Invalid config", "err", err)
+  }
+  ConfigureLogging(cfg)
+  if cfg.AllowReminderTriggers {
+    LOG.Warn("Slack reminders can open, close and remind checkins, and anyone in the channel can set one, " +
+      "as allow_reminder_triggers (ALLOW_REMINDER_TRIGGERS) is on")
   }
+  ConfigureTracing(cfg)
 
-  dbUrl := os.Getenv("DATABASE_URL")
-  DB, err = sql.Open("postgres", dbUrl)
+  DB, err = sql.Open(METRICS_DB_DRIVER, cfg.DatabaseUrl)
   if err != nil {
-    log.Fatalf("Error opening db connection %q\n", err)
+    LOG.Fatal("Error opening db connection", "err", err)
+  }
+  SchemaSetup()
+  if err = ApplySettings(cfg); err != nil {
+    LOG.Fatal("Invalid settings", "err", err)
   }
 
-  GetChannels(false)
+  if cfg.Standup.ChannelId == "" {
+    cfg.Standup.ChannelId = LookupChannelId(cfg)
+  }
 
-  // sets up router
-  log.Printf("Server starting on Port: %s...\n", port)
-	router := mux.NewRouter()
+  ScheduleWeeklyDigest(cfg)
+  ScheduleEventPruning()
+  ScheduleJobPruning()
+  // every replica keeps its schedule, but only the leader runs the jobs
+  LEADER = NewLeaderElector(LEADER_LEASE_NAME, InstanceId(), LEADER_LEASE_TTL)
+  LEADER.Start()
+  SCHEDULER.SetLeaderCheck(LEADER.IsLeader)
+  SCHEDULER.SetRunLog(DBRunLog{})
+  SCHEDULER.Start()
+
+  CONFIG = NewConfigStore(os.Getenv("CONFIG_FILE"), cfg)
+  CONFIG.Watch()
+  QUEUE.Start(QUEUE_WORKERS)
+  // events, slash commands and interactions come over a websocket instead of the endpoints below,
+  // which still serve the API
+  if cfg.SocketMode {
+    SOCKET.Start()
+  }
 
-  // setup routes
-	router.HandleFunc("/", HandleCallback)
-	router.HandleFunc("/test", TestSuccess)
-	router.HandleFunc("/testError", TestError)
-  router.HandleFunc("/getVars", LogVars)
-  router.HandleFunc("/checkin", HandleCheckin)
-  router.HandleFunc("/remind", RemindAwaiting)
-  router.HandleFunc("/close", CloseCheckinHandler)
-	log.Fatal(http.ListenAndServe(port, router))
+  LOG.Info("Server starting", "addr", cfg.ListenAddr())
+  router := NewRouter()
+  server := &http.Server{Addr: cfg.ListenAddr(), Handler: router}
+  go func() {
+    if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      LOG.Fatal("Server stopped", "err", err)
+    }
+  }()
+  WaitForShutdown(server)
+}
+
+// sets up the routes of the bot
+// the endpoints Slack sends events, slash commands and interactions to only take requests signed by Slack
+func NewRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/", WithConfig(WithSlackSignature(HandleCallback)))
+  router.HandleFunc("/standup", WithConfig(WithSlackSignature(StandupCommandHandler)))
+  router.HandleFunc("/interactions", WithConfig(WithSlackSignature(InteractionsHandler)))
+	router.HandleFunc("/test", WithConfig(TestSuccess))
+	router.HandleFunc("/testError", WithConfig(TestError))
+  router.HandleFunc("/checkin", WithConfig(WithSlackSignature(HandleCheckin)))
+  router.HandleFunc("/remind", WithConfig(WithSlackSignature(RemindAwaiting)))
+  router.HandleFunc("/close", WithConfig(WithSlackSignature(CloseCheckinHandler)))
+  router.HandleFunc("/report", WithConfig(WithSlackSignature(ReportHandler)))
+  router.HandleFunc("/digest", WithConfig(WithSlackSignature(DigestPreviewHandler)))
+  router.HandleFunc("/blockers", WithConfig(WithSlackSignature(BlockersHandler)))
+  router.HandleFunc("/followups", WithConfig(WithSlackSignature(FollowupsHandler)))
+  router.HandleFunc("/api/report", WithConfig(ReportApiHandler))
+  router.HandleFunc("/api/export", WithConfig(ExportApiHandler))
+  router.HandleFunc("/api/audit", WithConfig(AuditApiHandler))
+  router.HandleFunc("/api/status", WithConfig(StatusApiHandler))
+  router.HandleFunc("/metrics", WithConfig(MetricsHandler))
+  router.HandleFunc("/healthz", WithConfig(HealthHandler))
+  router.HandleFunc("/readyz", WithConfig(ReadyHandler))
+  return router
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+  "database/sql"
+  "time"
+)
+
+// type to contain what is known of a channel, kept up to date by rename and archive events
+type ChannelInfo struct {
+  Id string `json:"id"`
+  Name string `json:"name"`
+  PreviousName string `json:"previous_name"`
+  Archived bool `json:"archived"`
+  UpdatedAt time.Time `json:"updated_at"`
+}
+
+// creates the channel metadata table if it does not exist yet
+func ChannelSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS channels (
+      channel_id TEXT PRIMARY KEY,
+      name TEXT NOT NULL,
+      previous_name TEXT NOT NULL DEFAULT '',
+      archived BOOLEAN NOT NULL DEFAULT FALSE,
+      updated_at TIMESTAMPTZ NOT NULL
+    );`); err != nil {
+    LOG.Error("Error creating channels table", "err", err)
+  }
+}
+
+// determines if the channel is the one the standup is run in
+func isStandupChannel(cfg *Config, channelId string) bool {
+  return channelId != "" && (channelId == cfg.Standup.ChannelId || channelId == GetSessionChannelId(cfg))
+}
+
+// stores the name of the channel, keeping its previous name when it changed
+func SaveChannel(cfg *Config, channelId, name string, archived bool) {
+  if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO channels (channel_id, name, archived, updated_at) VALUES ($1, $2, $3, $4)
+    ON CONFLICT (channel_id) DO UPDATE SET
+      previous_name = CASE WHEN channels.name <> $2 AND channels.name <> '' THEN channels.name ELSE channels.previous_name END,
+      name = $2, archived = $3, updated_at = $4;`, channelId, name, archived, time.Now()); err != nil {
+    cfg.Log().Error("Error saving channel", "channel_id", channelId, "err", err)
+  }
+}
+
+// gets what is known of the channel, returning false if nothing is
+func GetChannelInfo(cfg *Config, channelId string) (info ChannelInfo, ok bool) {
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT channel_id, name, previous_name, archived, updated_at FROM channels WHERE channel_id = $1;",
+    channelId).Scan(&info.Id, &info.Name, &info.PreviousName, &info.Archived, &info.UpdatedAt)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error getting channel", "channel_id", channelId, "err", err)
+  }
+  return info, err == nil
+}
+
+// gets the id of the channel last renamed from the given name, or an empty string if there is none
+// lets the standup channel be found after a rename, until the configured channel name is updated
+func FindRenamedChannel(cfg *Config, name string) (channelId string) {
+  err := DB.QueryRowContext(cfg.Context(),
+    "SELECT channel_id FROM channels WHERE previous_name = $1 ORDER BY updated_at DESC LIMIT 1;", name).Scan(&channelId)
+  if err != nil && err != sql.ErrNoRows {
+    cfg.Log().Error("Error finding renamed channel", "name", name, "err", err)
+  }
+  return channelId
+}
+
+// updates the stored name of a channel from a channel_rename or group_rename event
+func HandleChannelRename(cfg *Config, channel *ConversationList) {
+  if channel == nil || channel.Id == "" {
+    cfg.Log().Warn("Channel rename event without a channel")
+    return
+  }
+  info, _ := GetChannelInfo(cfg, channel.Id)
+  SaveChannel(cfg, channel.Id, channel.Name, info.Archived)
+  if isStandupChannel(cfg, channel.Id) {
+    cfg.Log().Warn("Standup channel renamed, update the configured channel name", "channel_id", channel.Id,
+      "name", channel.Name, "previous_name", info.Name)
+  }
+}
+
+// updates whether a channel is archived from a channel_archive, channel_unarchive, group_archive or group_unarchive event
+func HandleChannelArchive(cfg *Config, channelId string, archived bool) {
+  if channelId == "" {
+    cfg.Log().Warn("Channel archive event without a channel")
+    return
+  }
+  info, _ := GetChannelInfo(cfg, channelId)
+  SaveChannel(cfg, channelId, info.Name, archived)
+  if !isStandupChannel(cfg, channelId) {
+    return
+  }
+  if archived {
+    cfg.Log().Error("Standup channel archived, checkins can't be posted to it until it is unarchived", "channel_id", channelId)
+  } else {
+    cfg.Log().Info("Standup channel unarchived", "channel_id", channelId)
+  }
+}
+
+// adds the user to the session's participants
+// returns false if they already were one
+func AddParticipant(cfg *Config, sessionId int64, userId string) bool {
+  res, err := DB.ExecContext(cfg.Context(),
+    "INSERT INTO participants (session_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;", sessionId, userId)
+  if err != nil {
+    cfg.Log().Error("Error inserting participant", "err", err)
+    return false
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff == 1
+}
+
+// removes the user from the session's participants, unless they already responded
+// returns false if they were not an awaited participant
+func RemoveParticipant(cfg *Config, sessionId int64, userId string) bool {
+  res, err := DB.ExecContext(cfg.Context(),
+    "DELETE FROM participants WHERE session_id = $1 AND user_id = $2 AND responded_at IS NULL;", sessionId, userId)
+  if err != nil {
+    cfg.Log().Error("Error removing participant", "err", err)
+    return false
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff == 1
+}
+
+// adds a user who joined the standup channel to the open session, DMing them the prompt if PromptNewMembers is on
+// bots and users out of office are left out, as they are when a session opens
+// returns an error without doing anything if the standup could not be locked, so the event is retried
+func HandleMemberJoined(cfg *Config, userId, channelId string) error {
+  unlock, err := LockStandup(cfg.Standup.Name)
+  if err != nil {
+    return err
+  }
+  defer unlock()
+  if GetThreadId() == "" || channelId != GetSessionChannelId(cfg) {
+    return nil
+  }
+  if user, err := LookupUser(cfg, userId); err == nil && user.IsBotUser() {
+    return nil
+  }
+  if GetOutOfOfficeUsers(cfg)[userId] {
+    return nil
+  }
+
+  sessionId := GetCurrentSessionId(cfg)
+  if sessionId == 0 || !AddParticipant(cfg, sessionId, userId) {
+    return nil
+  }
+  PostUsers([]string{userId})
+  cfg.Log().Info("Added new channel member to the checkin", "user", userId, "prompted", cfg.Standup.PromptNewMembers)
+  if cfg.Standup.PromptNewMembers {
+    FanOutMessage(cfg, sessionId, DELIVERY_PROMPT, []string{userId}, cfg.Standup.Prompt)
+  }
+  return nil
+}
+
+// removes a user who left the standup channel from the open session, so they are no longer awaited
+// nor listed as not having completed it when it closes
+// a response they already gave is kept
+// returns an error without doing anything if the standup could not be locked, so the event is retried
+func HandleMemberLeft(cfg *Config, userId, channelId string) error {
+  unlock, err := LockStandup(cfg.Standup.Name)
+  if err != nil {
+    return err
+  }
+  defer unlock()
+  if GetThreadId() == "" || channelId != GetSessionChannelId(cfg) {
+    return nil
+  }
+  sessionId := GetCurrentSessionId(cfg)
+  if sessionId == 0 || !RemoveParticipant(cfg, sessionId, userId) {
+    return nil
+  }
+  UpdateUser(userId)
+  cfg.Log().Info("Removed channel member who left from the checkin", "user", userId)
+  return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+  "net/http"
+  "testing"
+  "time"
+)
+
+// determines if the user is a participant of the session
+func isParticipant(t *testing.T, sessionId int64, userId string) bool {
+  t.Helper()
+  var count int
+  if err := DB.QueryRow("SELECT COUNT(*) FROM participants WHERE session_id = $1 AND user_id = $2;", sessionId, userId).Scan(&count); err != nil {
+    t.Fatalf("getting the participants: %v", err)
+  }
+  return count == 1
+}
+
+// determines if the user is awaited in the open session
+func isAwaited(cfg *Config, userId string) bool {
+  for _, user := range GetUsers(cfg, "", false) {
+    if user == userId {
+      return true
+    }
+  }
+  return false
+}
+
+// opens a session in C1 awaiting U1, answering users.info for UBOT with a bot
+func openMembershipSession(t *testing.T) (cfg *Config, ws *fakeWorkspace, sessionId int64, restore func()) {
+  requireDB(t)
+  DM_CHANNELS = make(map[string]string)
+  ws = &fakeWorkspace{}
+  restore = fakeSlack(func(method string, r *http.Request) interface{} {
+    if method == "users.info" && slackParams(r)["user"] == "UBOT" {
+      return map[string]interface{}{"ok": true, "user": map[string]interface{}{"id": "UBOT", "real_name": "Bot", "is_bot": true}}
+    }
+    return ws.handle(method, r)
+  })
+  cfg = sessionConfig()
+  cfg.Standup.PromptNewMembers = true
+  CONFIG = NewConfigStore("", cfg)
+
+  PostThreadId("1000.1")
+  sessionId = StartSession(cfg, "C1", "1000.1", []string{"U1"})
+  PostUsers([]string{"U1"})
+  return cfg, ws, sessionId, restore
+}
+
+func TestMemberJoinedOpenSession(t *testing.T) {
+  cfg, ws, sessionId, restore := openMembershipSession(t)
+  defer restore()
+
+  if err := HandleMemberJoined(cfg, "U2", "C1"); err != nil {
+    t.Fatalf("HandleMemberJoined() = %v", err)
+  }
+  if !isParticipant(t, sessionId, "U2") || !isAwaited(cfg, "U2") {
+    t.Errorf("joiner was not added to the open session")
+  }
+  if count := ws.count("DU2", "time to check in"); count != 1 {
+    t.Errorf("joiner was prompted %d times, want once", count)
+  }
+
+  // joining again, or joining another channel, changes nothing
+  HandleMemberJoined(cfg, "U2", "C1")
+  HandleMemberJoined(cfg, "U3", "C2")
+  if ws.count("DU2", "time to check in") != 1 || isParticipant(t, sessionId, "U3") {
+    t.Errorf("joining again or joining another channel changed the session")
+  }
+}
+
+func TestMemberJoinedOutOfOfficeOrBot(t *testing.T) {
+  cfg, ws, sessionId, restore := openMembershipSession(t)
+  defer restore()
+  today := time.Now().In(GetLocation())
+  if err := SetOutOfOffice(cfg, "U2", today, today.AddDate(0, 0, 1)); err != nil {
+    t.Fatalf("SetOutOfOffice() = %v", err)
+  }
+
+  for _, userId := range []string{"U2", "UBOT"} {
+    if err := HandleMemberJoined(cfg, userId, "C1"); err != nil {
+      t.Fatalf("HandleMemberJoined(%s) = %v", userId, err)
+    }
+    if isParticipant(t, sessionId, userId) || isAwaited(cfg, userId) || ws.count("D"+userId, "time to check in") != 0 {
+      t.Errorf("%s was added to the session, want them left out", userId)
+    }
+  }
+}
+
+func TestMemberLeftPending(t *testing.T) {
+  cfg, ws, sessionId, restore := openMembershipSession(t)
+  defer restore()
+  PostUsers([]string{"U2"})
+  AddParticipant(cfg, sessionId, "U2")
+
+  if err := HandleMemberLeft(cfg, "U1", "C1"); err != nil {
+    t.Fatalf("HandleMemberLeft() = %v", err)
+  }
+  if isParticipant(t, sessionId, "U1") || isAwaited(cfg, "U1") {
+    t.Errorf("pending leaver is still in the session")
+  }
+
+  if closed, err := CloseCheckin(cfg); !closed || err != nil {
+    t.Fatalf("CloseCheckin() = %t, %v, want true", closed, err)
+  }
+  if ws.count("C1", "User U1") != 0 || ws.count("C1", "did not complete the checkin: User U2") != 1 {
+    t.Errorf("close listed the leaver, or not the member still pending: %v", ws.posted)
+  }
+}
+
+func TestMemberLeftResponded(t *testing.T) {
+  cfg, _, sessionId, restore := openMembershipSession(t)
+  defer restore()
+  RecordResponse(cfg, "U1", "User U1", "did things")
+  UpdateUser("U1")
+
+  if err := HandleMemberLeft(cfg, "U1", "C1"); err != nil {
+    t.Fatalf("HandleMemberLeft() = %v", err)
+  }
+  if !isParticipant(t, sessionId, "U1") {
+    t.Errorf("leaver who already responded was removed from the session, want their response kept")
+  }
+  var responses int
+  DB.QueryRow("SELECT COUNT(*) FROM responses WHERE session_id = $1 AND user_id = $2;", sessionId, "U1").Scan(&responses)
+  if responses != 1 {
+    t.Errorf("%d responses kept for the leaver, want 1", responses)
+  }
+}
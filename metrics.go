@@ -0,0 +1,326 @@
+package main
+
+import (
+  "context"
+  "database/sql"
+  "database/sql/driver"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/lib/pq"
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the name of the Postgres driver timing every query for the metrics
+const METRICS_DB_DRIVER = "postgres-metrics"
+
+// how long the queue counts are reused for, so every scrape doesn't query the db
+const QUEUE_COUNTS_TTL = 15 * time.Second
+
+// the registry the metrics are exposed from, holding only the bot's own metrics
+var METRICS = prometheus.NewRegistry()
+
+var SLACK_API_REQUESTS = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Name: "checkin_slack_api_requests_total",
+  Help: "Slack API calls made, by method and result: ok or the Slack error code.",
+}, []string{"method", "result"})
+var SLACK_API_DURATION = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+  Name: "checkin_slack_api_request_duration_seconds",
+  Help: "Latency of Slack API calls, by method.",
+}, []string{"method"})
+var EVENTS_RECEIVED = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Name: "checkin_slack_events_received_total",
+  Help: "Slack events received, by event type.",
+}, []string{"type"})
+var SESSIONS_OPENED = prometheus.NewCounter(prometheus.CounterOpts{
+  Name: "checkin_sessions_opened_total",
+  Help: "Checkin sessions opened.",
+})
+var SESSIONS_CLOSED = prometheus.NewCounter(prometheus.CounterOpts{
+  Name: "checkin_sessions_closed_total",
+  Help: "Checkin sessions closed.",
+})
+var RESPONSES_RECEIVED = prometheus.NewCounter(prometheus.CounterOpts{
+  Name: "checkin_responses_received_total",
+  Help: "Checkin responses received.",
+})
+var REMINDERS_SENT = prometheus.NewCounter(prometheus.CounterOpts{
+  Name: "checkin_reminders_sent_total",
+  Help: "Reminders sent to users yet to check in.",
+})
+var DELIVERIES = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Name: "checkin_dm_deliveries_total",
+  Help: "Checkin prompts and reminders DM'd, by kind and delivery status.",
+}, []string{"kind", "status"})
+var DB_QUERY_DURATION = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+  Name: "checkin_db_query_duration_seconds",
+  Help: "Latency of database queries, by statement type.",
+}, []string{"operation"})
+var QUEUE_JOBS = &queueCollector{
+  desc: prometheus.NewDesc("checkin_queue_jobs", "Jobs in the queue, by status.", []string{"status"}, nil),
+}
+
+func init() {
+  METRICS.MustRegister(
+    SLACK_API_REQUESTS,
+    SLACK_API_DURATION,
+    EVENTS_RECEIVED,
+    SESSIONS_OPENED,
+    SESSIONS_CLOSED,
+    RESPONSES_RECEIVED,
+    REMINDERS_SENT,
+    DELIVERIES,
+    DB_QUERY_DURATION,
+    QUEUE_JOBS,
+  )
+  sql.Register(METRICS_DB_DRIVER, &MetricsDriver{driver: &pq.Driver{}})
+}
+
+// type to collect the jobs in the queue by status, counting them at most once every QUEUE_COUNTS_TTL
+type queueCollector struct {
+  desc *prometheus.Desc
+  mtx sync.Mutex
+  counts map[string]int
+  countedAt time.Time
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+  ch <- c.desc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+  c.mtx.Lock()
+  defer c.mtx.Unlock()
+  if c.counts == nil || time.Since(c.countedAt) >= QUEUE_COUNTS_TTL {
+    c.counts = GetQueueCounts()
+    c.countedAt = time.Now()
+  }
+  for status, count := range c.counts {
+    ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), status)
+  }
+}
+
+// the handler for the /metrics endpoint
+// responds with the metrics in the Prometheus text format
+func MetricsHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  promhttp.HandlerFor(METRICS, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// gets the Slack method called by the request, from the path after /api/
+func SlackMethod(req *http.Request) string {
+  return strings.TrimPrefix(req.URL.Path, "/api/")
+}
+
+// gets the result of a Slack API call to count it by: ok, or the error code Slack returned
+func SlackResult(ok bool, slackError string) string {
+  if ok {
+    return "ok"
+  }
+  if slackError == "" {
+    return "unknown_error"
+  }
+  return slackError
+}
+
+// gets the kind of statement a query runs, from its first word
+func QueryOperation(query string) string {
+  fields := strings.Fields(query)
+  if len(fields) == 0 {
+    return "unknown"
+  }
+  return strings.ToLower(fields[0])
+}
+
+// type to wrap a database driver, timing the queries run through it
+type MetricsDriver struct {
+  driver driver.Driver
+}
+
+func (d *MetricsDriver) Open(name string) (driver.Conn, error) {
+  conn, err := d.driver.Open(name)
+  if err != nil {
+    return nil, err
+  }
+  return &metricsConn{Conn: conn}, nil
+}
+
+// type to wrap a database connection, timing and tracing the queries run on it
+// queries are timed until their rows are returned, not until the rows are read
+type metricsConn struct {
+  driver.Conn
+}
+
+func (c *metricsConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+  execer, ok := c.Conn.(driver.ExecerContext)
+  if !ok {
+    return nil, driver.ErrSkip
+  }
+  ctx, cancel := withQueryTimeout(ctx)
+  defer cancel()
+  ctx, span := startQuerySpan(ctx, QueryOperation(query), query)
+  start := time.Now()
+  res, err := execer.ExecContext(ctx, query, args)
+  observeQuery(ctx, QueryOperation(query), query, start, err)
+  span.SetError(err)
+  span.End()
+  return res, err
+}
+
+func (c *metricsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+  queryer, ok := c.Conn.(driver.QueryerContext)
+  if !ok {
+    return nil, driver.ErrSkip
+  }
+  ctx, cancel := withQueryTimeout(ctx)
+  ctx, span := startQuerySpan(ctx, QueryOperation(query), query)
+  start := time.Now()
+  rows, err := queryer.QueryContext(ctx, query, args)
+  observeQuery(ctx, QueryOperation(query), query, start, err)
+  span.SetError(err)
+  span.End()
+  if err != nil {
+    cancel()
+    return nil, err
+  }
+  return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+// adds DB_QUERY_TIMEOUT to contexts without a deadline, so a stuck query cannot hold up a request or job
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+  if _, ok := ctx.Deadline(); ok {
+    return ctx, func() {}
+  }
+  return context.WithTimeout(ctx, DB_QUERY_TIMEOUT)
+}
+
+// type to wrap the rows of a query, ending its timeout once they are closed
+type timeoutRows struct {
+  driver.Rows
+  cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Close() error {
+  defer r.cancel()
+  return r.Rows.Close()
+}
+
+// starts a span for the query when it is run within a trace
+// queries run outside of one, like the queue polling for jobs, are not traced, so they don't each make a trace
+func startQuerySpan(ctx context.Context, operation, query string) (context.Context, *Span) {
+  if !SpanFromContext(ctx).IsValid() {
+    return ctx, nil
+  }
+  return StartSpan(ctx, fmt.Sprint("db ", operation), SPAN_KIND_CLIENT, "db.system", "postgresql",
+    "db.operation", operation, "db.statement", strings.Join(strings.Fields(query), " "))
+}
+
+// records the latency of the query, and logs it at debug level with the request id of the context
+// the statement is logged without its args, which can hold message text
+func observeQuery(ctx context.Context, operation, query string, start time.Time, err error) {
+  duration := time.Since(start)
+  DB_QUERY_DURATION.WithLabelValues(operation).Observe(duration.Seconds())
+
+  logger := LOG
+  if requestId := RequestIdFromContext(ctx); requestId != "" {
+    logger = LOG.With("request_id", requestId)
+  }
+  logger.Debug("Ran query", "operation", operation, "query", strings.Join(strings.Fields(query), " "),
+    "duration", duration, "err", err)
+}
+
+// prepares the statement, timing and tracing both preparing it and each time it is run
+// preparing is recorded with the operation "prepare", and runs with the kind of statement prepared
+func (c *metricsConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+  ctx, cancel := withQueryTimeout(ctx)
+  defer cancel()
+  ctx, span := startQuerySpan(ctx, "prepare", query)
+  start := time.Now()
+  var stmt driver.Stmt
+  var err error
+  if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+    stmt, err = preparer.PrepareContext(ctx, query)
+  } else {
+    stmt, err = c.Conn.Prepare(query)
+  }
+  observeQuery(ctx, "prepare", query, start, err)
+  span.SetError(err)
+  span.End()
+  if err != nil {
+    return nil, err
+  }
+  return &metricsStmt{Stmt: stmt, query: query}, nil
+}
+
+// type to wrap a prepared statement, timing and tracing each time it is run like the queries of metricsConn
+type metricsStmt struct {
+  driver.Stmt
+  query string
+}
+
+func (s *metricsStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+  ctx, cancel := withQueryTimeout(ctx)
+  defer cancel()
+  operation := QueryOperation(s.query)
+  ctx, span := startQuerySpan(ctx, operation, s.query)
+  start := time.Now()
+  var res driver.Result
+  var err error
+  if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+    res, err = execer.ExecContext(ctx, args)
+  } else {
+    res, err = s.Stmt.Exec(namedValues(args))
+  }
+  observeQuery(ctx, operation, s.query, start, err)
+  span.SetError(err)
+  span.End()
+  return res, err
+}
+
+func (s *metricsStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+  ctx, cancel := withQueryTimeout(ctx)
+  operation := QueryOperation(s.query)
+  ctx, span := startQuerySpan(ctx, operation, s.query)
+  start := time.Now()
+  var rows driver.Rows
+  var err error
+  if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+    rows, err = queryer.QueryContext(ctx, args)
+  } else {
+    rows, err = s.Stmt.Query(namedValues(args))
+  }
+  observeQuery(ctx, operation, s.query, start, err)
+  span.SetError(err)
+  span.End()
+  if err != nil {
+    cancel()
+    return nil, err
+  }
+  return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+// gets the values of the args, for statements of drivers that only take them by position
+func namedValues(args []driver.NamedValue) []driver.Value {
+  values := make([]driver.Value, len(args))
+  for i, arg := range args {
+    values[i] = arg.Value
+  }
+  return values
+}
+
+func (c *metricsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+  if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+    return beginner.BeginTx(ctx, opts)
+  }
+  return c.Conn.Begin()
+}
+
+func (c *metricsConn) Ping(ctx context.Context) error {
+  if pinger, ok := c.Conn.(driver.Pinger); ok {
+    return pinger.Ping(ctx)
+  }
+  return nil
+}
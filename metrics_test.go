@@ -0,0 +1,183 @@
+package main
+
+import (
+  "context"
+  "database/sql"
+  "database/sql/driver"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/testutil"
+  dto "github.com/prometheus/client_model/go"
+)
+
+// the name of a MetricsDriver wrapping fakeDriver, so queries can be timed and traced without a db
+const FAKE_DB_DRIVER = "fake-metrics"
+
+func init() {
+  sql.Register(FAKE_DB_DRIVER, &MetricsDriver{driver: fakeDriver{}})
+}
+
+// type for a database driver that only prepares statements, each run returning no rows
+type fakeDriver struct{}
+
+type fakeConn struct{}
+
+type fakeStmt struct{}
+
+type fakeRows struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error { return nil }
+func (fakeConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+func (fakeStmt) Close() error { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) { return fakeRows{}, nil }
+
+func (fakeRows) Columns() []string { return []string{"n"} }
+func (fakeRows) Close() error { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+// gets how many values the histogram recorded for the given label values
+func histogramCount(t *testing.T, h *prometheus.HistogramVec, labelValues ...string) uint64 {
+  t.Helper()
+  var metric dto.Metric
+  if err := h.WithLabelValues(labelValues...).(prometheus.Histogram).Write(&metric); err != nil {
+    t.Fatalf("reading the histogram: %v", err)
+  }
+  return metric.GetHistogram().GetSampleCount()
+}
+
+func TestSlackApiRequestsByResult(t *testing.T) {
+  transport := http.DefaultTransport
+  defer func() { http.DefaultTransport = transport }()
+  http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+    status, body := http.StatusOK, `{"ok":true}`
+    switch SlackMethod(r) {
+    case "test.error":
+      body = `{"ok":false,"error":"channel_not_found"}`
+    case "test.ratelimited":
+      status, body = http.StatusTooManyRequests, ""
+    case "test.html":
+      status, body = http.StatusBadGateway, "<html>bad gateway</html>"
+    }
+    return &http.Response{StatusCode: status, Header: make(http.Header), Request: r,
+      Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+  })
+
+  tests := []struct {
+    method string
+    result string
+  }{
+    {"test.ok", "ok"},
+    {"test.error", "channel_not_found"},
+    {"test.ratelimited", "ratelimited"},
+    {"test.html", "invalid_response"},
+  }
+  cfg := &Config{ApiToken: "xoxb-test"}
+  for _, test := range tests {
+    res, err := PerformPost(cfg, test.method, nil, nil, true)
+    HandleResponse(cfg, res, err, false)
+    if count := testutil.ToFloat64(SLACK_API_REQUESTS.WithLabelValues(test.method, test.result)); count != 1 {
+      t.Errorf("%s counted %v times as %s, want 1", test.method, count, test.result)
+    }
+  }
+}
+
+func TestSlackResult(t *testing.T) {
+  tests := []struct {
+    ok bool
+    slackError string
+    want string
+  }{
+    {true, "", "ok"},
+    {true, "ignored", "ok"},
+    {false, "not_in_channel", "not_in_channel"},
+    {false, "", "unknown_error"},
+  }
+  for _, test := range tests {
+    if got := SlackResult(test.ok, test.slackError); got != test.want {
+      t.Errorf("SlackResult(%t, %q) = %q, want %q", test.ok, test.slackError, got, test.want)
+    }
+  }
+}
+
+func TestPreparedStatementsTimed(t *testing.T) {
+  db, err := sql.Open(FAKE_DB_DRIVER, "")
+  if err != nil {
+    t.Fatalf("opening the fake db: %v", err)
+  }
+  defer db.Close()
+  prepares := histogramCount(t, DB_QUERY_DURATION, "prepare")
+  updates := histogramCount(t, DB_QUERY_DURATION, "update")
+  selects := histogramCount(t, DB_QUERY_DURATION, "select")
+
+  stmt, err := db.PrepareContext(context.Background(), "UPDATE users SET name = $1;")
+  if err != nil {
+    t.Fatalf("preparing a statement: %v", err)
+  }
+  defer stmt.Close()
+  for i := 0; i < 2; i++ {
+    if _, err = stmt.Exec("someone"); err != nil {
+      t.Fatalf("running a prepared statement: %v", err)
+    }
+  }
+  rows, err := db.Query("SELECT 1;")
+  if err != nil {
+    t.Fatalf("running a query: %v", err)
+  }
+  rows.Close()
+
+  // the query is prepared by database/sql as the driver can't run it directly
+  if count := histogramCount(t, DB_QUERY_DURATION, "prepare") - prepares; count != 2 {
+    t.Errorf("%d statements prepared, want 2", count)
+  }
+  if count := histogramCount(t, DB_QUERY_DURATION, "update") - updates; count != 2 {
+    t.Errorf("prepared update timed %d times, want 2", count)
+  }
+  if count := histogramCount(t, DB_QUERY_DURATION, "select") - selects; count != 1 {
+    t.Errorf("query timed %d times, want 1", count)
+  }
+}
+
+func TestMetricsHandler(t *testing.T) {
+  defer useFakeDB(t)()
+  SESSIONS_OPENED.Inc()
+  w := httptest.NewRecorder()
+  MetricsHandler(&Config{}, w, httptest.NewRequest("GET", "/metrics", nil))
+  body := w.Body.String()
+  for _, want := range []string{"# TYPE checkin_sessions_opened_total counter", "checkin_queue_jobs{status=\"pending\"} 0"} {
+    if !strings.Contains(body, want) {
+      t.Errorf("/metrics is missing %q:\n%s", want, body)
+    }
+  }
+}
+
+func TestQueueGaugeCached(t *testing.T) {
+  defer useFakeDB(t)()
+  collector := &queueCollector{desc: QUEUE_JOBS.desc}
+  selects := histogramCount(t, DB_QUERY_DURATION, "select")
+  for i := 0; i < 3; i++ {
+    testutil.CollectAndCount(collector)
+  }
+  if count := histogramCount(t, DB_QUERY_DURATION, "select") - selects; count != 1 {
+    t.Errorf("queue counted %d times in 3 scrapes, want 1", count)
+  }
+
+  // counted again once the counts are older than QUEUE_COUNTS_TTL
+  collector.countedAt = time.Now().Add(-QUEUE_COUNTS_TTL)
+  testutil.CollectAndCount(collector)
+  if count := histogramCount(t, DB_QUERY_DURATION, "select") - selects; count != 2 {
+    t.Errorf("queue counted %d times after the counts expired, want 2", count)
+  }
+}
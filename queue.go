@@ -0,0 +1,321 @@
+package main
+
+import (
+  "context"
+  "database/sql"
+  "encoding/json"
+  "fmt"
+  "sync"
+  "time"
+)
+
+const JOB_SLACK_EVENT = "slack_event"
+const JOB_SLASH_COMMAND = "slash_command"
+const JOB_SLASH_RESPONSE = "slash_response"
+const JOB_PUBLISH_HOME = "publish_home"
+
+const JOB_PENDING = "pending"
+const JOB_RUNNING = "running"
+const JOB_DONE = "done"
+const JOB_DEAD = "dead"
+
+const QUEUE_WORKERS = 4
+const QUEUE_MAX_ATTEMPTS = 5
+const QUEUE_POLL_INTERVAL = time.Second
+
+// the delay before the first retry of a failed job, doubled on every attempt after
+const QUEUE_RETRY_DELAY = 10 * time.Second
+
+// how long a job can be running before it is assumed its worker died, and it is run again
+const QUEUE_STALE_AFTER = 5 * time.Minute
+
+// how long finished jobs are kept
+const QUEUE_KEEP_DONE = 7 * 24 * time.Hour
+
+const JOB_PRUNE_JOB_NAME = "prune jobs"
+
+// type to contain a job claimed from the queue
+type Job struct {
+  Id int64
+  Kind string
+  Payload string
+  Attempts int
+  RequestId string
+  TraceParent string
+  // when the job was claimed, which changes when a stale job is claimed again by another worker
+  LockedAt time.Time
+}
+
+// the functions running each kind of job, given the config in use and the job payload
+// a returned error retries the job, until it is dead lettered after QUEUE_MAX_ATTEMPTS attempts
+var JOB_HANDLERS map[string]func(cfg *Config, payload []byte) error
+
+func init() {
+  JOB_HANDLERS = map[string]func(cfg *Config, payload []byte) error{
+    JOB_SLACK_EVENT: RunEventJob,
+    JOB_SLASH_COMMAND: RunSlashCommandJob,
+    JOB_SLASH_RESPONSE: RunSlashResponseJob,
+    JOB_PUBLISH_HOME: RunPublishHomeJob,
+  }
+}
+
+// type to run queued jobs with a pool of workers
+type JobQueue struct {
+  mtx sync.Mutex
+  stop chan struct{}
+  wg sync.WaitGroup
+}
+
+var QUEUE = &JobQueue{}
+
+// creates the jobs table if it does not exist yet
+func QueueSetup() {
+  stmts := []string{
+    `CREATE TABLE IF NOT EXISTS jobs (
+      id SERIAL PRIMARY KEY,
+      kind TEXT NOT NULL,
+      payload TEXT NOT NULL,
+      status TEXT NOT NULL,
+      attempts INTEGER NOT NULL DEFAULT 0,
+      last_error TEXT NOT NULL DEFAULT '',
+      run_at TIMESTAMPTZ NOT NULL,
+      locked_at TIMESTAMPTZ,
+      created_at TIMESTAMPTZ NOT NULL,
+      updated_at TIMESTAMPTZ NOT NULL
+    );`,
+    "CREATE INDEX IF NOT EXISTS jobs_status_run_at ON jobs (status, run_at);",
+    "ALTER TABLE jobs ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';",
+    "ALTER TABLE jobs ADD COLUMN IF NOT EXISTS trace_parent TEXT NOT NULL DEFAULT '';",
+  }
+  for _, stmt := range stmts {
+    if _, err := DB.Exec(stmt); err != nil {
+      LOG.Error("Error creating jobs table", "err", err)
+      return
+    }
+  }
+}
+
+// adds a job of the given kind to the queue, with the payload marshalled as JSON
+// the job keeps the request id and span of the config, so what it logs and traces is correlated with the request
+// that queued it
+func Enqueue(cfg *Config, kind string, payload interface{}) error {
+  return enqueue(cfg, DB, kind, payload)
+}
+
+// type for what jobs are added with, the db or a transaction
+type execer interface {
+  ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// adds a job with the given db or transaction, so it can be queued along with other changes
+func enqueue(cfg *Config, db execer, kind string, payload interface{}) error {
+  data, err := json.Marshal(payload)
+  if err != nil {
+    return err
+  }
+  now := time.Now()
+  _, err = db.ExecContext(cfg.Context(), `INSERT INTO jobs (kind, payload, status, run_at, created_at, updated_at, request_id, trace_parent)
+    VALUES ($1, $2, $3, $4, $4, $4, $5, $6);`, kind, string(data), JOB_PENDING, now, cfg.RequestId(), Traceparent(cfg.SpanContext()))
+  return err
+}
+
+// claims the next due job, along with jobs whose worker stopped while running them
+// returns false if there is no job to run
+func ClaimJob() (job Job, ok bool, err error) {
+  now := time.Now()
+  err = DB.QueryRow(`UPDATE jobs SET status = $1, attempts = attempts + 1, locked_at = $2, updated_at = $2
+    WHERE id = (
+      SELECT id FROM jobs
+      WHERE (status = $3 AND run_at <= $2) OR (status = $1 AND locked_at < $4)
+      ORDER BY run_at LIMIT 1 FOR UPDATE SKIP LOCKED
+    ) RETURNING id, kind, payload, attempts, request_id, trace_parent, locked_at;`,
+    JOB_RUNNING, now, JOB_PENDING, now.Add(-QUEUE_STALE_AFTER)).Scan(&job.Id, &job.Kind, &job.Payload, &job.Attempts,
+    &job.RequestId, &job.TraceParent, &job.LockedAt)
+  if err == sql.ErrNoRows {
+    return job, false, nil
+  }
+  if err != nil {
+    return job, false, err
+  }
+  return job, true, nil
+}
+
+// marks the job as done
+// the job is left as is when it was claimed again after going stale, as it is up to the worker running it now
+func CompleteJob(job Job) {
+  res, err := DB.Exec("UPDATE jobs SET status = $1, locked_at = NULL, updated_at = $2 WHERE id = $3 AND locked_at = $4;",
+    JOB_DONE, time.Now(), job.Id, job.LockedAt)
+  if err != nil {
+    LOG.Error("Error completing job", "err", err)
+    return
+  }
+  warnIfReclaimed(job, res)
+}
+
+// warns when updating a job changed nothing, as it was claimed again by another worker
+func warnIfReclaimed(job Job, res sql.Result) {
+  if rowsAff, _ := res.RowsAffected(); rowsAff == 0 {
+    LOG.Warn("Job was claimed again after going stale, leaving it to its new worker", "kind", job.Kind,
+      "job_id", job.Id, "request_id", job.RequestId)
+  }
+}
+
+// schedules the job to be retried with a backoff, or dead letters it once it has used all of its attempts
+// the job is left as is when it was claimed again after going stale, as it is up to the worker running it now
+func FailJob(job Job, jobErr error) {
+  now := time.Now()
+  status := JOB_PENDING
+  runAt := now.Add(QUEUE_RETRY_DELAY << uint(job.Attempts-1))
+  if job.Attempts >= QUEUE_MAX_ATTEMPTS {
+    status = JOB_DEAD
+    LOG.Error("Dead lettering job", "kind", job.Kind, "job_id", job.Id, "request_id", job.RequestId, "attempts", job.Attempts, "err", jobErr)
+  }
+  res, err := DB.Exec(`UPDATE jobs SET status = $1, run_at = $2, last_error = $3, locked_at = NULL, updated_at = $4
+    WHERE id = $5 AND locked_at = $6;`, status, runAt, jobErr.Error(), now, job.Id, job.LockedAt)
+  if err != nil {
+    LOG.Error("Error failing job", "err", err)
+    return
+  }
+  warnIfReclaimed(job, res)
+}
+
+// runs the job with its handler, turning a panic into an error so the job is retried
+func RunJob(cfg *Config, job Job) (err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      err = fmt.Errorf("panic: %v", r)
+    }
+  }()
+  handler, ok := JOB_HANDLERS[job.Kind]
+  if !ok {
+    return fmt.Errorf("unknown job kind %s", job.Kind)
+  }
+  return handler(cfg, []byte(job.Payload))
+}
+
+// starts the given number of workers running queued jobs in the background
+func (q *JobQueue) Start(workers int) {
+  q.mtx.Lock()
+  defer q.mtx.Unlock()
+  if q.stop != nil {
+    return
+  }
+  q.stop = make(chan struct{})
+  for i := 0; i < workers; i++ {
+    q.wg.Add(1)
+    go q.worker(q.stop)
+  }
+}
+
+// stops the workers, waiting for the jobs they are running to finish
+func (q *JobQueue) Stop() {
+  q.mtx.Lock()
+  if q.stop != nil {
+    close(q.stop)
+    q.stop = nil
+  }
+  q.mtx.Unlock()
+  q.wg.Wait()
+}
+
+// determines if the workers have been started
+func (q *JobQueue) Running() bool {
+  q.mtx.Lock()
+  defer q.mtx.Unlock()
+  return q.stop != nil
+}
+
+func (q *JobQueue) worker(stop chan struct{}) {
+  defer q.wg.Done()
+  for {
+    select {
+    case <-stop:
+      return
+    default:
+    }
+
+    job, ok, err := ClaimJob()
+    if err != nil {
+      LOG.Error("Error claiming job", "err", err)
+    }
+    if !ok {
+      select {
+      case <-stop:
+        return
+      case <-time.After(QUEUE_POLL_INTERVAL):
+      }
+      continue
+    }
+
+    // jobs queued outside of a request get their own id
+    requestId := job.RequestId
+    if requestId == "" {
+      requestId = NewRequestId()
+    }
+    cfg := CONFIG.Get().WithRequestId(requestId)
+    cfg, span := cfg.WithSpan(ParseTraceparent(job.TraceParent)).StartSpan(fmt.Sprint("job ", job.Kind), SPAN_KIND_INTERNAL,
+      "job.id", job.Id, "job.attempt", job.Attempts)
+    err = RunJob(cfg, job)
+    span.SetError(err)
+    span.End()
+    if err != nil {
+      cfg.Log().Error("Error running job", "kind", job.Kind, "job_id", job.Id, "attempt", job.Attempts, "err", err)
+      FailJob(job, err)
+      continue
+    }
+    CompleteJob(job)
+  }
+}
+
+// gets the number of jobs with each status
+func GetQueueCounts() map[string]int {
+  counts := map[string]int{JOB_PENDING: 0, JOB_RUNNING: 0, JOB_DONE: 0, JOB_DEAD: 0}
+  rows, err := DB.Query("SELECT status, COUNT(*) FROM jobs GROUP BY status;")
+  if err != nil {
+    LOG.Error("Error counting jobs", "err", err)
+    return counts
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var status string
+    var count int
+    if err = rows.Scan(&status, &count); err != nil {
+      LOG.Error("Error converting job count", "err", err)
+      continue
+    }
+    counts[status] = count
+  }
+  return counts
+}
+
+// queues the dead lettered jobs to run again, returning how many were queued
+func RetryDeadJobs() int64 {
+  now := time.Now()
+  res, err := DB.Exec("UPDATE jobs SET status = $1, attempts = 0, run_at = $2, updated_at = $2 WHERE status = $3;",
+    JOB_PENDING, now, JOB_DEAD)
+  if err != nil {
+    LOG.Error("Error retrying dead jobs", "err", err)
+    return 0
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff
+}
+
+// removes the jobs that finished more than QUEUE_KEEP_DONE ago
+func PruneJobs() {
+  if _, err := DB.Exec("DELETE FROM jobs WHERE status = $1 AND updated_at < $2;",
+    JOB_DONE, time.Now().Add(-QUEUE_KEEP_DONE)); err != nil {
+    LOG.Error("Error pruning jobs", "err", err)
+  }
+}
+
+// schedules pruning the finished jobs every day
+func ScheduleJobPruning() {
+  SCHEDULER.Add(&ScheduledJob{
+    Name: JOB_PRUNE_JOB_NAME,
+    Next: func(after time.Time) time.Time {
+      return after.Add(24 * time.Hour)
+    },
+    Run: PruneJobs,
+  })
+}
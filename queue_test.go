@@ -0,0 +1,47 @@
+package main
+
+import (
+  "errors"
+  "testing"
+  "time"
+)
+
+// gets the status of the job with the given id
+func jobStatus(t *testing.T, id int64) string {
+  t.Helper()
+  var status string
+  if err := DB.QueryRow("SELECT status FROM jobs WHERE id = $1;", id).Scan(&status); err != nil {
+    t.Fatalf("getting the job status: %v", err)
+  }
+  return status
+}
+
+func TestStaleWorkerLeavesReclaimedJob(t *testing.T) {
+  requireDB(t)
+  if err := Enqueue(&Config{}, JOB_SLASH_RESPONSE, SlashReply{}); err != nil {
+    t.Fatalf("Enqueue() = %v", err)
+  }
+  stale, ok, err := ClaimJob()
+  if !ok || err != nil {
+    t.Fatalf("ClaimJob() = %t, %v, want the job", ok, err)
+  }
+  // the first worker takes too long, so the job is claimed again
+  if _, err = DB.Exec("UPDATE jobs SET locked_at = $1;", time.Now().Add(-QUEUE_STALE_AFTER-time.Minute)); err != nil {
+    t.Fatalf("aging the job: %v", err)
+  }
+  current, ok, err := ClaimJob()
+  if !ok || err != nil || current.Id != stale.Id {
+    t.Fatalf("ClaimJob() of a stale job = %+v, %t, %v, want it claimed again", current, ok, err)
+  }
+
+  // the first worker finishing or failing doesn't change the job its new worker is running
+  CompleteJob(stale)
+  FailJob(stale, errors.New("too slow"))
+  if status := jobStatus(t, current.Id); status != JOB_RUNNING {
+    t.Errorf("job %s after its stale worker finished, want it still running", status)
+  }
+  CompleteJob(current)
+  if status := jobStatus(t, current.Id); status != JOB_DONE {
+    t.Errorf("job %s after its worker finished, want it done", status)
+  }
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "os/signal"
+  "sync"
+  "sync/atomic"
+  "syscall"
+  "time"
+)
+
+// how often the config file is checked for changes
+const CONFIG_POLL_INTERVAL = 5 * time.Second
+
+const SOURCE_RELOAD = "reload"
+
+// the actor recorded for config reloads
+const SYSTEM_ACTOR = "system"
+
+// type to hold the config in use, which is swapped as a whole when the config file is reloaded
+// handlers get the config once per request, so requests in flight keep the config they started with
+type ConfigStore struct {
+  mtx sync.Mutex
+  channelMtx sync.Mutex
+  path string
+  modTime time.Time
+  size int64
+  loadedAt time.Time
+  current atomic.Value
+}
+
+var CONFIG *ConfigStore
+
+// creates a config store serving the given validated config, loaded from the given file if any
+func NewConfigStore(path string, cfg *Config) *ConfigStore {
+  store := &ConfigStore{path: path, loadedAt: time.Now()}
+  store.modTime, store.size = store.stat()
+  store.current.Store(cfg)
+  return store
+}
+
+// gets the config currently in use
+func (s *ConfigStore) Get() *Config {
+  return s.current.Load().(*Config)
+}
+
+// gets the given config with the id of the standup channel, looking it up by name when it isn't known,
+// such as when Slack could not be reached at startup
+// an id that is found is kept in the config in use, so the channel is only looked up once
+func (s *ConfigStore) WithChannelId(cfg *Config) *Config {
+  if cfg.Standup.ChannelId != "" {
+    return cfg
+  }
+  if s == nil {
+    return cfg.withChannelId(LookupChannelId(cfg))
+  }
+  s.channelMtx.Lock()
+  defer s.channelMtx.Unlock()
+  if current := s.Get(); current.Standup.ChannelId != "" && current.Standup.ChannelName == cfg.Standup.ChannelName {
+    return cfg.withChannelId(current.Standup.ChannelId)
+  }
+  channelId := LookupChannelId(cfg)
+  if channelId == "" {
+    return cfg
+  }
+  // the config in use is swapped for a copy, as requests may be reading it
+  s.mtx.Lock()
+  if current := s.Get(); current.Standup.ChannelId == "" && current.Standup.ChannelName == cfg.Standup.ChannelName {
+    s.current.Store(current.withChannelId(channelId))
+  }
+  s.mtx.Unlock()
+  return cfg.withChannelId(channelId)
+}
+
+// gets a copy of the config with the given standup channel id
+func (cfg *Config) withChannelId(channelId string) *Config {
+  copy := *cfg
+  copy.Standup.ChannelId = channelId
+  return &copy
+}
+
+// gets the config file and when the config in use was loaded
+func (s *ConfigStore) Source() (path string, loadedAt time.Time) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.path, s.loadedAt
+}
+
+func (s *ConfigStore) stat() (modTime time.Time, size int64) {
+  if s.path == "" {
+    return modTime, 0
+  }
+  info, err := os.Stat(s.path)
+  if err != nil {
+    return modTime, 0
+  }
+  return info.ModTime(), info.Size()
+}
+
+// checks the settings that can only change with a restart, and that the standup of an open
+// session is not changed underneath it
+func CheckReload(old, cfg *Config) error {
+  problems := make([]string, 0)
+  if cfg.ListenAddr() != old.ListenAddr() {
+    problems = append(problems, "port (PORT) can only be changed with a restart")
+  }
+  if cfg.DatabaseUrl != old.DatabaseUrl {
+    problems = append(problems, "database_url (DATABASE_URL) can only be changed with a restart")
+  }
+  if cfg.SocketMode != old.SocketMode {
+    problems = append(problems, "socket_mode (SOCKET_MODE) can only be changed with a restart")
+  }
+  if GetThreadId() != "" {
+    if cfg.Standup.Name != old.Standup.Name {
+      problems = append(problems, "standup.name (STANDUP_NAME) cannot change while a checkin session is open")
+    }
+    if cfg.Standup.ChannelName != old.Standup.ChannelName ||
+      (cfg.Standup.ChannelId != "" && cfg.Standup.ChannelId != old.Standup.ChannelId) {
+      problems = append(problems, "the standup channel cannot change while a checkin session is open")
+    }
+  }
+  if len(problems) > 0 {
+    return &ConfigError{Problems: problems}
+  }
+  return nil
+}
+
+// loads, validates and swaps in the config from the config file, environment and stored settings
+// the config in use is kept when the new one is invalid
+func (s *ConfigStore) Reload() error {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  modTime, size := s.stat()
+  old := s.Get()
+  cfg, err := LoadConfig(s.path)
+  if err != nil {
+    return err
+  }
+  if err = cfg.Validate(); err != nil {
+    return err
+  }
+  if err = ApplySettings(cfg); err != nil {
+    return err
+  }
+  if err = CheckReload(old, cfg); err != nil {
+    return err
+  }
+
+  // keeps the channel id looked up at startup, so the thread of an open session is still found
+  if cfg.Standup.ChannelId == "" && cfg.Standup.ChannelName == old.Standup.ChannelName {
+    cfg.Standup.ChannelId = old.Standup.ChannelId
+  }
+  if cfg.Standup.ChannelId == "" {
+    cfg.Standup.ChannelId = LookupChannelId(cfg)
+  }
+
+  s.current.Store(cfg)
+  s.modTime, s.size, s.loadedAt = modTime, size, time.Now()
+  ConfigureLogging(cfg)
+  ConfigureTracing(cfg)
+
+  SCHEDULER.Remove(DIGEST_JOB_NAME)
+  ScheduleWeeklyDigest(cfg)
+  LOG.Info("Config reloaded")
+  return nil
+}
+
+// determines if the config file changed since it was last loaded
+func (s *ConfigStore) changed() bool {
+  if s.path == "" {
+    return false
+  }
+  modTime, size := s.stat()
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return !modTime.IsZero() && (!modTime.Equal(s.modTime) || size != s.size)
+}
+
+// reloads the config on SIGHUP and whenever the config file changes
+func (s *ConfigStore) Watch() {
+  hangup := make(chan os.Signal, 1)
+  signal.Notify(hangup, syscall.SIGHUP)
+  ticker := time.NewTicker(CONFIG_POLL_INTERVAL)
+
+  go func() {
+    defer ticker.Stop()
+    for {
+      var reason string
+      select {
+      case <-hangup:
+        reason = "SIGHUP"
+      case <-ticker.C:
+        if !s.changed() {
+          continue
+        }
+        reason = fmt.Sprintf("change to %s", s.path)
+      }
+      LOG.Info("Reloading config", "reason", reason)
+      if err := s.Reload(); err != nil {
+        LOG.Error("Error reloading config, keeping the current one", "err", err)
+        // the file is not checked again until it changes
+        s.mtx.Lock()
+        s.modTime, s.size = s.stat()
+        s.mtx.Unlock()
+        continue
+      }
+      RecordAudit(s.Get(), SYSTEM_ACTOR, "reload_config", SOURCE_RELOAD, reason)
+    }
+  }()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+  "net/http"
+  "reflect"
+  "sync/atomic"
+  "testing"
+)
+
+func TestWithChannelIdLooksUpOnce(t *testing.T) {
+  requireDB(t)
+  var lookups int32
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    if method != "conversations.list" {
+      t.Errorf("unexpected Slack call %s", method)
+    }
+    atomic.AddInt32(&lookups, 1)
+    return map[string]interface{}{"ok": true, "channels": []map[string]interface{}{
+      {"id": "C1", "name": "random"},
+      {"id": "C2", "name": "standup"},
+    }}
+  })()
+
+  published := &Config{ApiToken: "xoxb-test", Standup: StandupConfig{Name: "standup", ChannelName: "standup"}}
+  CONFIG = NewConfigStore("", published)
+  request := CONFIG.Get().WithRequestId("req-1")
+  if got := CONFIG.WithChannelId(request); got.Standup.ChannelId != "C2" {
+    t.Fatalf("WithChannelId() channel id = %q, want C2", got.Standup.ChannelId)
+  }
+  if published.Standup.ChannelId != "" || request.Standup.ChannelId != "" {
+    t.Errorf("WithChannelId() changed the config it was given")
+  }
+  if got := CONFIG.Get().Standup.ChannelId; got != "C2" {
+    t.Errorf("config in use has channel id %q, want C2", got)
+  }
+
+  // later requests either have the id, or find it in the config in use without calling Slack
+  if got := CONFIG.WithChannelId(CONFIG.Get().WithRequestId("req-2")); got.Standup.ChannelId != "C2" {
+    t.Errorf("WithChannelId() channel id = %q, want C2", got.Standup.ChannelId)
+  }
+  if got := CONFIG.WithChannelId(request); got.Standup.ChannelId != "C2" {
+    t.Errorf("WithChannelId() from a stale config channel id = %q, want C2", got.Standup.ChannelId)
+  }
+  if lookups != 1 {
+    t.Errorf("conversations.list called %d times, want 1", lookups)
+  }
+}
+
+// gets the problems CheckReload found with reloading old as cfg
+func reloadProblems(old, cfg *Config) []string {
+  err := CheckReload(old, cfg)
+  if err == nil {
+    return nil
+  }
+  return err.(*ConfigError).Problems
+}
+
+func TestCheckReloadRestartOnlySettings(t *testing.T) {
+  // no session is open in the fake db
+  defer useFakeDB(t)()
+  old := &Config{Port: "8080", DatabaseUrl: "postgres://one", Standup: StandupConfig{Name: "standup", ChannelName: "standup", ChannelId: "C1"}}
+  tests := []struct {
+    name string
+    change func(cfg *Config)
+    want []string
+  }{
+    {"nothing", func(cfg *Config) {}, nil},
+    {"port", func(cfg *Config) { cfg.Port = "9090" }, []string{"port (PORT) can only be changed with a restart"}},
+    {"same port written differently", func(cfg *Config) { cfg.Port = ":8080" }, nil},
+    {"database", func(cfg *Config) { cfg.DatabaseUrl = "postgres://two" },
+      []string{"database_url (DATABASE_URL) can only be changed with a restart"}},
+    {"socket mode", func(cfg *Config) { cfg.SocketMode = true },
+      []string{"socket_mode (SOCKET_MODE) can only be changed with a restart"}},
+    {"standup without an open session", func(cfg *Config) {
+      cfg.Standup = StandupConfig{Name: "other", ChannelName: "other"}
+    }, nil},
+    {"everything", func(cfg *Config) {
+      cfg.Port, cfg.DatabaseUrl, cfg.SocketMode = "9090", "postgres://two", true
+    }, []string{"port (PORT) can only be changed with a restart",
+      "database_url (DATABASE_URL) can only be changed with a restart",
+      "socket_mode (SOCKET_MODE) can only be changed with a restart"}},
+  }
+  for _, test := range tests {
+    cfg := *old
+    test.change(&cfg)
+    if got := reloadProblems(old, &cfg); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: CheckReload() problems = %q, want %q", test.name, got, test.want)
+    }
+  }
+}
+
+func TestCheckReloadDuringSession(t *testing.T) {
+  requireDB(t)
+  old := sessionConfig()
+  StartSession(old, "C1", "1000.1", []string{"U1"})
+  tests := []struct {
+    name string
+    change func(cfg *Config)
+    want []string
+  }{
+    {"prompt", func(cfg *Config) { cfg.Standup.Prompt = "what did you do?" }, nil},
+    {"channel id looked up again", func(cfg *Config) { cfg.Standup.ChannelId = "" }, nil},
+    {"name", func(cfg *Config) { cfg.Standup.Name = "other" },
+      []string{"standup.name (STANDUP_NAME) cannot change while a checkin session is open"}},
+    {"channel name", func(cfg *Config) { cfg.Standup.ChannelName, cfg.Standup.ChannelId = "other", "" },
+      []string{"the standup channel cannot change while a checkin session is open"}},
+    {"channel id", func(cfg *Config) { cfg.Standup.ChannelId = "C2" },
+      []string{"the standup channel cannot change while a checkin session is open"}},
+  }
+  for _, test := range tests {
+    cfg := *old
+    test.change(&cfg)
+    if got := reloadProblems(old, &cfg); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: CheckReload() problems = %q, want %q", test.name, got, test.want)
+    }
+  }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+  "database/sql"
+  "fmt"
+  "sort"
+  "strings"
+)
+
+const ROLE_OWNER = "owner"
+const ROLE_ADMIN = "admin"
+const ROLE_PARTICIPANT = "participant"
+const ROLE_VIEWER = "viewer"
+
+// the role of Slack reminders when they are allowed to trigger sessions, which can't be assigned to anyone
+const ROLE_REMINDER = "reminder"
+
+// the role of users with no role stored for the standup
+const DEFAULT_ROLE = ROLE_PARTICIPANT
+
+// the user id Slack reminders are posted as
+const SLACKBOT_USER_ID = "USLACKBOT"
+
+const PERM_RUN_SESSIONS = "run_sessions"
+const PERM_CHECKIN = "checkin"
+const PERM_VIEW_REPORTS = "view_reports"
+const PERM_MANAGE_BLOCKERS = "manage_blockers"
+const PERM_VIEW_CONFIG = "view_config"
+const PERM_MANAGE_ROLES = "manage_roles"
+const PERM_MANAGE_ADMINS = "manage_admins"
+const PERM_VIEW_AUDIT = "view_audit"
+const PERM_MANAGE_STANDUP = "manage_standup"
+
+// the permissions granted by each role
+var ROLE_PERMISSIONS = map[string][]string{
+  ROLE_OWNER: {PERM_RUN_SESSIONS, PERM_CHECKIN, PERM_VIEW_REPORTS, PERM_MANAGE_BLOCKERS, PERM_VIEW_CONFIG, PERM_MANAGE_ROLES, PERM_MANAGE_ADMINS, PERM_VIEW_AUDIT, PERM_MANAGE_STANDUP},
+  ROLE_ADMIN: {PERM_RUN_SESSIONS, PERM_CHECKIN, PERM_VIEW_REPORTS, PERM_MANAGE_BLOCKERS, PERM_VIEW_CONFIG, PERM_MANAGE_ROLES, PERM_VIEW_AUDIT, PERM_MANAGE_STANDUP},
+  ROLE_PARTICIPANT: {PERM_CHECKIN},
+  ROLE_VIEWER: {PERM_VIEW_REPORTS},
+  ROLE_REMINDER: {PERM_RUN_SESSIONS},
+}
+
+// type to contain a user's role in the standup
+type RoleAssignment struct {
+  UserId string
+  Role string
+}
+
+// creates the roles table if it does not exist yet
+func RoleSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS roles (
+      standup TEXT NOT NULL,
+      user_id TEXT NOT NULL,
+      role TEXT NOT NULL,
+      PRIMARY KEY (standup, user_id)
+    );`); err != nil {
+    LOG.Error("Error creating roles table", "err", err)
+  }
+}
+
+// determines if the given role exists and can be assigned
+func IsValidRole(role string) bool {
+  _, ok := ROLE_PERMISSIONS[role]
+  return ok && role != ROLE_REMINDER
+}
+
+// gets the role of the given user in the standup
+// users in the configured admin users are always owners, and an empty user id has no role
+func GetRole(cfg *Config, userId string) string {
+  if userId == "" {
+    return ""
+  }
+  if cfg.IsConfiguredOwner(userId) {
+    return ROLE_OWNER
+  }
+  if userId == SLACKBOT_USER_ID {
+    // reminders can be set by anyone in the channel, so they only run sessions, and only when allowed
+    if cfg.AllowReminderTriggers {
+      return ROLE_REMINDER
+    }
+    return ""
+  }
+
+  var role string
+  err := DB.QueryRowContext(cfg.Context(), "SELECT role FROM roles WHERE standup = $1 AND user_id = $2;", cfg.Standup.Name, userId).Scan(&role)
+  if err == sql.ErrNoRows {
+    return DEFAULT_ROLE
+  }
+  if err != nil {
+    // fail closed rather than granting the default role
+    cfg.Log().Error("Error getting role", "err", err)
+    return ""
+  }
+  return role
+}
+
+// determines if the given user has the given permission in the standup
+func HasPermission(cfg *Config, userId, permission string) bool {
+  for _, granted := range ROLE_PERMISSIONS[GetRole(cfg, userId)] {
+    if granted == permission {
+      return true
+    }
+  }
+  return false
+}
+
+// sets the role of the target user on behalf of the actor, from the given source
+// owners can assign any role, while admins can only manage participants and viewers
+func SetRole(cfg *Config, actor, target, role, source string) error {
+  if !IsValidRole(role) {
+    return fmt.Errorf("unknown role %q, expected one of owner, admin, participant, viewer", role)
+  }
+  if !HasPermission(cfg, actor, PERM_MANAGE_ROLES) {
+    return fmt.Errorf("you are not allowed to manage roles")
+  }
+  current := GetRole(cfg, target)
+  isPrivileged := func(r string) bool { return r == ROLE_OWNER || r == ROLE_ADMIN }
+  if (isPrivileged(role) || isPrivileged(current)) && !HasPermission(cfg, actor, PERM_MANAGE_ADMINS) {
+    return fmt.Errorf("only owners can manage owners and admins")
+  }
+  if cfg.IsConfiguredOwner(target) {
+    return fmt.Errorf("<@%s> is an owner through admin_users (ADMIN_USERS), which can only be changed in the config", target)
+  }
+
+  if _, err := DB.ExecContext(cfg.Context(), `INSERT INTO roles (standup, user_id, role) VALUES ($1, $2, $3)
+    ON CONFLICT (standup, user_id) DO UPDATE SET role = $3;`, cfg.Standup.Name, target, role); err != nil {
+    cfg.Log().Error("Error setting role", "err", err)
+    return fmt.Errorf("could not set the role, try again later")
+  }
+  RecordAudit(cfg, actor, "set_role", source, fmt.Sprintf("%s %s -> %s", target, current, role))
+  return nil
+}
+
+// gets the users with a role stored for the standup, along with the configured owners
+func GetRoleAssignments(cfg *Config) (assignments []RoleAssignment, err error) {
+  for _, id := range cfg.AdminUsers {
+    if id != "" {
+      assignments = append(assignments, RoleAssignment{UserId: id, Role: ROLE_OWNER})
+    }
+  }
+  rows, err := DB.QueryContext(cfg.Context(), "SELECT user_id, role FROM roles WHERE standup = $1;", cfg.Standup.Name)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var assignment RoleAssignment
+    if err = rows.Scan(&assignment.UserId, &assignment.Role); err != nil {
+      return nil, err
+    }
+    assignments = append(assignments, assignment)
+  }
+  rank := map[string]int{ROLE_OWNER: 0, ROLE_ADMIN: 1, ROLE_PARTICIPANT: 2, ROLE_VIEWER: 3}
+  sort.SliceStable(assignments, func(i, j int) bool {
+    return rank[assignments[i].Role] < rank[assignments[j].Role]
+  })
+  return assignments, rows.Err()
+}
+
+// runs the roles command, listing the roles in the standup
+func RunRolesCommand(cfg *Config, req SlashRequest, args []string) string {
+  assignments, err := GetRoleAssignments(cfg)
+  if err != nil {
+    cfg.Log().Error("Error getting roles", "err", err)
+    return "Could not get the roles, try again later"
+  }
+  builder := strings.Builder{}
+  fmt.Fprintf(&builder, "Everyone else is a %s.", DEFAULT_ROLE)
+  for _, assignment := range assignments {
+    fmt.Fprintf(&builder, "\n<@%s>: %s", assignment.UserId, assignment.Role)
+  }
+  return builder.String()
+}
+
+// runs the role command, setting the role of a mentioned user
+func RunRoleCommand(cfg *Config, req SlashRequest, args []string) string {
+  mentions := ParseMentions(strings.Join(args, " "))
+  if len(args) != 2 || len(mentions) != 1 {
+    return "Usage: `role @user <owner|admin|participant|viewer>`"
+  }
+  role := strings.ToLower(args[1])
+  if err := SetRole(cfg, req.UserId, mentions[0], role, SOURCE_SLASH); err != nil {
+    return err.Error()
+  }
+  return fmt.Sprintf("<@%s> is now a %s", mentions[0], role)
+}
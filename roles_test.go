@@ -0,0 +1,69 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "testing"
+)
+
+func TestReminderTriggersOnlyRunSessions(t *testing.T) {
+  // Slackbot's role doesn't come from the db, so none is needed
+  if role := GetRole(&Config{}, SLACKBOT_USER_ID); role != "" {
+    t.Errorf("GetRole(USLACKBOT) with reminder triggers off = %q, want no role", role)
+  }
+  cfg := &Config{AllowReminderTriggers: true}
+  if role := GetRole(cfg, SLACKBOT_USER_ID); role != ROLE_REMINDER {
+    t.Errorf("GetRole(USLACKBOT) with reminder triggers on = %q, want %q", role, ROLE_REMINDER)
+  }
+  for permission, want := range map[string]bool{PERM_RUN_SESSIONS: true, PERM_CHECKIN: false, PERM_VIEW_CONFIG: false,
+    PERM_MANAGE_ROLES: false, PERM_MANAGE_STANDUP: false, PERM_VIEW_AUDIT: false} {
+    if got := HasPermission(cfg, SLACKBOT_USER_ID, permission); got != want {
+      t.Errorf("HasPermission(USLACKBOT, %s) = %t, want %t", permission, got, want)
+    }
+  }
+  if IsValidRole(ROLE_REMINDER) {
+    t.Errorf("IsValidRole(%q) = true, want it unassignable", ROLE_REMINDER)
+  }
+}
+
+func TestViewersCantCheckIn(t *testing.T) {
+  requireDB(t)
+  DM_CHANNELS = make(map[string]string)
+  var posted []string
+  _, restore := fakeSlackCalls(func(call int, r *http.Request) (int, string, error) {
+    params := slackParams(r)
+    switch SlackMethod(r) {
+    case "users.info":
+      return http.StatusOK, fmt.Sprintf(`{"ok":true,"user":{"id":%q,"real_name":"User %s"}}`, params["user"], params["user"]), nil
+    case "conversations.open":
+      return http.StatusOK, fmt.Sprintf(`{"ok":true,"channel":{"id":"D%s"}}`, params["users"]), nil
+    case "chat.postMessage":
+      posted = append(posted, params["channel"])
+      return http.StatusOK, `{"ok":true,"ts":"1000.2"}`, nil
+    }
+    return http.StatusOK, `{"ok":false,"error":"unknown_method"}`, nil
+  })
+  defer restore()
+  cfg := sessionConfig()
+  cfg.AdminUsers = []string{"UADMIN"}
+  if err := SetRole(cfg, "UADMIN", "U1", ROLE_VIEWER, SOURCE_SLASH); err != nil {
+    t.Fatalf("SetRole() = %v", err)
+  }
+  StartSession(cfg, "C1", "1000.1", []string{"U1"})
+
+  event := SlackEvent{Type: "message", User: "U1", Text: "Wrote tests"}
+  if err := ProcessEvent(cfg, SlackResponse{Event_id: "Ev1", Event: event}); err != nil {
+    t.Fatalf("ProcessEvent() = %v, want nil", err)
+  }
+  // the viewer is told why, and nothing is posted to the thread
+  if fmt.Sprint(posted) != "[DU1]" {
+    t.Errorf("posted to %v, want only a DM to U1", posted)
+  }
+  var responses int
+  if err := DB.QueryRow("SELECT COUNT(*) FROM responses;").Scan(&responses); err != nil {
+    t.Fatalf("counting responses: %v", err)
+  }
+  if responses != 0 {
+    t.Errorf("%d responses recorded, want none from a viewer", responses)
+  }
+}
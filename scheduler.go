@@ -0,0 +1,316 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const SCHEDULER_INTERVAL = 30 * time.Second
+
+// type to contain a job run by the scheduler
+// Next returns the first time after the given time the job should run
+type ScheduledJob struct {
+  Name string
+  Next func(after time.Time) time.Time
+  Run func()
+  nextRun time.Time
+  lastRun time.Time
+}
+
+// type to contain the state of a scheduled job for reporting
+type JobStatus struct {
+  Name string `json:"name"`
+  NextRun time.Time `json:"next_run"`
+  LastRun *time.Time `json:"last_run"`
+}
+
+// type to contain a run of a scheduled job that is due, by the time it was scheduled for
+type dueRun struct {
+  job *ScheduledJob
+  at time.Time
+}
+
+// type to record the runs of scheduled jobs made by any of the replicas sharing the db
+// runs are recorded by the time they were scheduled for, so a run made by one replica is seen by the others
+type RunLog interface {
+  // records the run of the job scheduled at the given time, returning false if it, or a later one, already was
+  Claim(name string, scheduledAt time.Time) (bool, error)
+  // determines if the run of the job scheduled at the given time, or a later one, was recorded
+  Ran(name string, scheduledAt time.Time) (bool, error)
+}
+
+// type to record the runs of scheduled jobs in the db
+type DBRunLog struct{}
+
+// type to run jobs at their scheduled times
+// when a leader check is set, due jobs are only run while it passes, so a single replica runs them
+// when a run log is set too, the other replicas keep a due run until it is recorded, so it isn't lost when the leader
+// stops before making it
+type Scheduler struct {
+  mtx sync.Mutex
+  jobs []*ScheduledJob
+  stop chan struct{}
+  wg sync.WaitGroup
+  isLeader func() bool
+  runLog RunLog
+}
+
+var SCHEDULER = &Scheduler{}
+
+// creates the table of scheduled runs if it does not exist yet
+func ScheduledRunSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS scheduled_runs (
+      name TEXT PRIMARY KEY,
+      scheduled_at TIMESTAMPTZ NOT NULL
+    );`); err != nil {
+    LOG.Error("Error creating scheduled runs table", "err", err)
+  }
+}
+
+func (DBRunLog) Claim(name string, scheduledAt time.Time) (bool, error) {
+  res, err := DB.Exec(`INSERT INTO scheduled_runs (name, scheduled_at) VALUES ($1, $2)
+    ON CONFLICT (name) DO UPDATE SET scheduled_at = $2 WHERE scheduled_runs.scheduled_at < $2;`, name, scheduledAt)
+  if err != nil {
+    return false, err
+  }
+  rowsAff, _ := res.RowsAffected()
+  return rowsAff == 1, nil
+}
+
+func (DBRunLog) Ran(name string, scheduledAt time.Time) (bool, error) {
+  var ran bool
+  err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM scheduled_runs WHERE name = $1 AND scheduled_at >= $2);",
+    name, scheduledAt).Scan(&ran)
+  return ran, err
+}
+
+// adds a job to the scheduler, computing its first run from now
+func (s *Scheduler) Add(job *ScheduledJob) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  job.nextRun = job.Next(time.Now())
+  s.jobs = append(s.jobs, job)
+  LOG.Info("Scheduled job", "job", job.Name, "next_run", job.nextRun)
+}
+
+// removes the jobs with the given name from the scheduler
+func (s *Scheduler) Remove(name string) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  jobs := s.jobs[:0]
+  for _, job := range s.jobs {
+    if job.Name != name {
+      jobs = append(jobs, job)
+    }
+  }
+  s.jobs = jobs
+}
+
+// sets the check deciding if this replica runs due jobs
+// without a run log, jobs skipped by other replicas still move to their next run, so a new leader does not run them
+func (s *Scheduler) SetLeaderCheck(isLeader func() bool) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  s.isLeader = isLeader
+}
+
+// sets the log the runs of jobs are recorded in, shared with the other replicas
+func (s *Scheduler) SetRunLog(runLog RunLog) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  s.runLog = runLog
+}
+
+// gets the run log, which is nil when runs are not recorded
+func (s *Scheduler) getRunLog() RunLog {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.runLog
+}
+
+// determines if this replica should run due jobs
+func (s *Scheduler) IsLeader() bool {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.isLeader == nil || s.isLeader()
+}
+
+// starts checking for due jobs in the background
+func (s *Scheduler) Start() {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  if s.stop != nil {
+    return
+  }
+  s.stop = make(chan struct{})
+  s.wg.Add(1)
+  go s.loop(s.stop)
+}
+
+// stops the scheduler, waiting for the jobs that are already running to finish
+func (s *Scheduler) Stop() {
+  s.mtx.Lock()
+  if s.stop != nil {
+    close(s.stop)
+    s.stop = nil
+  }
+  s.mtx.Unlock()
+  s.wg.Wait()
+}
+
+// determines if the scheduler has been started
+func (s *Scheduler) Running() bool {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.stop != nil
+}
+
+// gets the state of every scheduled job
+func (s *Scheduler) Status() []JobStatus {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  statuses := make([]JobStatus, 0, len(s.jobs))
+  for _, job := range s.jobs {
+    status := JobStatus{Name: job.Name, NextRun: job.nextRun}
+    if !job.lastRun.IsZero() {
+      lastRun := job.lastRun
+      status.LastRun = &lastRun
+    }
+    statuses = append(statuses, status)
+  }
+  return statuses
+}
+
+func (s *Scheduler) loop(stop chan struct{}) {
+  defer s.wg.Done()
+  ticker := time.NewTicker(SCHEDULER_INTERVAL)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case now := <-ticker.C:
+      s.runDue(now)
+    }
+  }
+}
+
+// runs the jobs due at the given time, unless another replica is the leader
+// a run is recorded in the run log before it is made, and is skipped when another replica already made it
+// runs that can't be recorded or checked stay due, and are tried again at the next check
+func (s *Scheduler) runDue(now time.Time) {
+  runLog := s.getRunLog()
+  for _, run := range s.due(now) {
+    if !s.IsLeader() {
+      if runLog != nil {
+        // kept due until the leader makes it, which is right away unless the leader stopped
+        ran, err := runLog.Ran(run.job.Name, run.at)
+        if err != nil {
+          LOG.Error("Error checking if the scheduled job ran", "job", run.job.Name, "err", err)
+        }
+        if !ran {
+          continue
+        }
+      }
+      LOG.Debug("Skipping scheduled job, another instance is the leader", "job", run.job.Name)
+      s.advance(run, now, false)
+      continue
+    }
+
+    if runLog != nil {
+      claimed, err := runLog.Claim(run.job.Name, run.at)
+      if err != nil {
+        LOG.Error("Error recording the scheduled job run", "job", run.job.Name, "err", err)
+        continue
+      }
+      if !claimed {
+        LOG.Info("Skipping scheduled job, another instance already ran it", "job", run.job.Name, "scheduled_at", run.at)
+        s.advance(run, now, false)
+        continue
+      }
+    }
+    s.advance(run, now, true)
+    LOG.Info("Running scheduled job", "job", run.job.Name, "scheduled_at", run.at)
+    run.job.Run()
+  }
+}
+
+// gets the runs due at the given time
+func (s *Scheduler) due(now time.Time) (runs []dueRun) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  for _, job := range s.jobs {
+    if !now.Before(job.nextRun) {
+      runs = append(runs, dueRun{job: job, at: job.nextRun})
+    }
+  }
+  return runs
+}
+
+// moves the job of a due run to its next run, noting when it last ran if it is run now
+func (s *Scheduler) advance(run dueRun, now time.Time, running bool) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  if !run.job.nextRun.Equal(run.at) {
+    return
+  }
+  if running {
+    run.job.lastRun = now
+  }
+  run.job.nextRun = run.job.Next(now)
+}
+
+// parses a weekly schedule such as "fri 16:00" in the display location
+// and returns a function giving the next matching time after a given time
+func ParseWeeklySchedule(schedule string) (func(after time.Time) time.Time, error) {
+  fields := strings.Fields(strings.ToLower(schedule))
+  if len(fields) != 2 {
+    return nil, fmt.Errorf("invalid weekly schedule %q, expected a day and time such as \"fri 16:00\"", schedule)
+  }
+
+  weekday := -1
+  for day := time.Sunday; day <= time.Saturday; day++ {
+    if strings.HasPrefix(strings.ToLower(day.String()), fields[0]) && len(fields[0]) >= 3 {
+      weekday = int(day)
+    }
+  }
+  if weekday < 0 {
+    return nil, fmt.Errorf("invalid day %q in weekly schedule", fields[0])
+  }
+
+  hour, minute, err := ParseClock(fields[1])
+  if err != nil {
+    return nil, err
+  }
+
+  loc := GetLocation()
+  return func(after time.Time) time.Time {
+    local := after.In(loc)
+    days := (weekday - int(local.Weekday()) + 7) % 7
+    next := time.Date(local.Year(), local.Month(), local.Day()+days, hour, minute, 0, 0, loc)
+    if !next.After(after) {
+      next = next.AddDate(0, 0, 7)
+    }
+    return next
+  }, nil
+}
+
+// parses a 24 hour HH:MM time
+func ParseClock(clock string) (hour, minute int, err error) {
+  parts := strings.Split(clock, ":")
+  if len(parts) != 2 {
+    return 0, 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+  }
+  hour, err = strconv.Atoi(parts[0])
+  if err != nil || hour < 0 || hour > 23 {
+    return 0, 0, fmt.Errorf("invalid hour in time %q", clock)
+  }
+  minute, err = strconv.Atoi(parts[1])
+  if err != nil || minute < 0 || minute > 59 {
+    return 0, 0, fmt.Errorf("invalid minute in time %q", clock)
+  }
+  return hour, minute, nil
+}
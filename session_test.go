@@ -0,0 +1,149 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+// type to record the messages posted to a fake Slack workspace
+type fakeWorkspace struct {
+  mtx sync.Mutex
+  members []string
+  posted []map[string]string
+}
+
+func (ws *fakeWorkspace) handle(method string, r *http.Request) interface{} {
+  params := slackParams(r)
+  switch method {
+  case "users.info":
+    return map[string]interface{}{"ok": true, "user": map[string]interface{}{"id": params["user"], "real_name": "User " + params["user"]}}
+  case "conversations.members":
+    return map[string]interface{}{"ok": true, "members": ws.members}
+  case "conversations.open":
+    return map[string]interface{}{"ok": true, "channel": map[string]string{"id": "D" + params["users"]}}
+  case "chat.postMessage":
+    ws.mtx.Lock()
+    defer ws.mtx.Unlock()
+    ws.posted = append(ws.posted, params)
+    return map[string]interface{}{"ok": true, "ts": fmt.Sprintf("%d.000100", time.Now().Unix())}
+  }
+  return map[string]interface{}{"ok": false, "error": "unknown_method"}
+}
+
+// counts the messages posted to the given channel that contain the given text
+func (ws *fakeWorkspace) count(channel, text string) (count int) {
+  ws.mtx.Lock()
+  defer ws.mtx.Unlock()
+  for _, params := range ws.posted {
+    if params["channel"] == channel && strings.Contains(params["text"], text) {
+      count++
+    }
+  }
+  return count
+}
+
+func sessionConfig() *Config {
+  return &Config{ApiToken: "xoxb-test", Standup: StandupConfig{Name: "standup", ChannelName: "standup", ChannelId: "C1",
+    Prompt: "time to check in", Reminder: "don't forget to check in"}}
+}
+
+func TestOpenCheckinClosesStaleSession(t *testing.T) {
+  requireDB(t)
+  DM_CHANNELS = make(map[string]string)
+  ws := &fakeWorkspace{members: []string{"U1", "U2"}}
+  defer fakeSlack(ws.handle)()
+  cfg := sessionConfig()
+  CONFIG = NewConfigStore("", cfg)
+
+  // a session whose close was missed the day before
+  PostThreadId("1000.1")
+  StartSession(cfg, "C1", "1000.1", []string{"U1"})
+  PostUsers([]string{"U1"})
+  if _, err := DB.Exec("UPDATE sessions SET opened_at = $1;", time.Now().Add(-STALE_SESSION_AFTER-time.Hour)); err != nil {
+    t.Fatalf("aging the session: %v", err)
+  }
+
+  if opened, err := OpenCheckin(cfg); !opened || err != nil {
+    t.Fatalf("OpenCheckin() with a stale session open = %t, %v, want true", opened, err)
+  }
+  if ws.count("C1", "Checkin is now closed") != 1 {
+    t.Errorf("stale session was not closed in its thread")
+  }
+  var open, closed int
+  DB.QueryRow("SELECT COUNT(*) FILTER (WHERE closed_at IS NULL), COUNT(*) FILTER (WHERE closed_at IS NOT NULL) FROM sessions;").Scan(&open, &closed)
+  if open != 1 || closed != 1 {
+    t.Errorf("%d open and %d closed sessions, want 1 of each", open, closed)
+  }
+  if ws.count("DU1", "time to check in") != 1 || ws.count("DU2", "time to check in") != 1 {
+    t.Errorf("new session did not prompt every member")
+  }
+
+  // the session just opened is not stale, so opening again does nothing
+  if opened, err := OpenCheckin(cfg); opened || err != nil {
+    t.Errorf("OpenCheckin() with a fresh session open = %t, %v, want false", opened, err)
+  }
+  if ws.count("DU1", "time to check in") != 1 {
+    t.Errorf("opening again prompted members again")
+  }
+}
+
+func TestRemindCheckinOncePerUser(t *testing.T) {
+  requireDB(t)
+  DM_CHANNELS = make(map[string]string)
+  ws := &fakeWorkspace{}
+  defer fakeSlack(ws.handle)()
+  cfg := sessionConfig()
+  CONFIG = NewConfigStore("", cfg)
+
+  PostThreadId("1000.1")
+  StartSession(cfg, "C1", "1000.1", []string{"U1", "U2"})
+  PostUsers([]string{"U1", "U2"})
+
+  // a duplicate trigger reminds nobody again
+  for i := 0; i < 2; i++ {
+    if reminded, err := RemindCheckin(cfg); !reminded || err != nil {
+      t.Fatalf("RemindCheckin() with a session open = %t, %v, want true", reminded, err)
+    }
+  }
+  for _, channel := range []string{"DU1", "DU2"} {
+    if count := ws.count(channel, "don't forget"); count != 1 {
+      t.Errorf("%d reminders sent to %s, want 1", count, channel)
+    }
+  }
+
+  // someone added to the session after the reminder is still reminded
+  PostUsers([]string{"U3"})
+  RemindCheckin(cfg)
+  if ws.count("DU3", "don't forget") != 1 || ws.count("DU1", "don't forget") != 1 {
+    t.Errorf("reminding again did not remind only the user not reminded yet")
+  }
+}
+
+func TestAwaitedUsersStoredAsGiven(t *testing.T) {
+  requireDB(t)
+  cfg := sessionConfig()
+  quoted := "U2'); DELETE FROM users; --"
+
+  PostUsers([]string{"U1", quoted})
+  if users := GetUsers(cfg, "", false); len(users) != 2 {
+    t.Fatalf("awaited users = %v, want U1 and %q", users, quoted)
+  }
+  if UpdateUser("U1' OR '1'='1") {
+    t.Errorf("UpdateUser() of an id matching nobody = true, want false")
+  }
+  if !UpdateUser(quoted) {
+    t.Errorf("UpdateUser(%q) = false, want true", quoted)
+  }
+  if users := GetUsers(cfg, "", false); len(users) != 1 || users[0] != "U1" {
+    t.Errorf("awaited users = %v, want only U1", users)
+  }
+
+  PostThreadId("1000.1'")
+  if threadId := GetThreadId(); threadId != "1000.1'" {
+    t.Errorf("GetThreadId() = %q, want the id posted", threadId)
+  }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+const SETTING_PROMPT = "prompt"
+const SETTING_REMINDER = "reminder"
+const SETTING_DIGEST_SCHEDULE = "digest_schedule"
+
+// creates the settings table if it does not exist yet
+// settings are changed by admins from Slack and override the config file and environment
+func SettingsSetup() {
+  if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS standup_settings (
+      standup TEXT NOT NULL,
+      key TEXT NOT NULL,
+      value TEXT NOT NULL,
+      updated_by TEXT NOT NULL,
+      updated_at TIMESTAMPTZ NOT NULL,
+      PRIMARY KEY (standup, key)
+    );`); err != nil {
+    LOG.Error("Error creating settings table", "err", err)
+  }
+}
+
+// gets the settings stored for the standup
+func GetSettings(standup string) map[string]string {
+  settings := make(map[string]string)
+  rows, err := DB.Query("SELECT key, value FROM standup_settings WHERE standup = $1;", standup)
+  if err != nil {
+    LOG.Error("Error getting settings", "err", err)
+    return settings
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var key, value string
+    if err = rows.Scan(&key, &value); err != nil {
+      LOG.Error("Error converting setting", "err", err)
+      continue
+    }
+    settings[key] = value
+  }
+  return settings
+}
+
+// applies the settings stored for the standup on top of a validated config, then validates it again
+// the config must be validated first so the standup name is known
+func ApplySettings(cfg *Config) error {
+  for key, value := range GetSettings(cfg.Standup.Name) {
+    applySetting(cfg, key, value)
+  }
+  return cfg.Validate()
+}
+
+func applySetting(cfg *Config, key, value string) {
+  switch key {
+  case SETTING_PROMPT:
+    cfg.Standup.Prompt = value
+  case SETTING_REMINDER:
+    cfg.Standup.Reminder = value
+  case SETTING_DIGEST_SCHEDULE:
+    cfg.Digest.Schedule = value
+  }
+}
+
+// checks the value of a setting before it is saved
+func ValidateSetting(key, value string) error {
+  switch key {
+  case SETTING_PROMPT, SETTING_REMINDER:
+    return nil
+  case SETTING_DIGEST_SCHEDULE:
+    if value == "" {
+      return nil
+    }
+    _, err := ParseWeeklySchedule(value)
+    return err
+  }
+  return fmt.Errorf("unknown setting %s", key)
+}
+
+// saves the settings for the standup and applies them to the config in use
+// an empty value removes a setting, so the config file or environment value is used again
+func SaveSettings(cfg *Config, actor string, settings map[string]string) error {
+  keys := make([]string, 0, len(settings))
+  for key, value := range settings {
+    settings[key] = strings.TrimSpace(value)
+    if err := ValidateSetting(key, settings[key]); err != nil {
+      return err
+    }
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+
+  for _, key := range keys {
+    value := settings[key]
+    var err error
+    if value == "" {
+      _, err = DB.ExecContext(cfg.Context(), "DELETE FROM standup_settings WHERE standup = $1 AND key = $2;", cfg.Standup.Name, key)
+    } else {
+      _, err = DB.ExecContext(cfg.Context(), `INSERT INTO standup_settings (standup, key, value, updated_by, updated_at) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (standup, key) DO UPDATE SET value = $3, updated_by = $4, updated_at = $5;`,
+        cfg.Standup.Name, key, value, actor, time.Now())
+    }
+    if err != nil {
+      cfg.Log().Error("Error saving setting", "err", err)
+      return fmt.Errorf("could not save the settings, try again later")
+    }
+    RecordAudit(cfg, actor, "set_setting", SOURCE_HOME, fmt.Sprintf("%s = %q", key, value))
+  }
+
+  // the config is reloaded rather than patched so a removed setting falls back to the file
+  if err := CONFIG.Reload(); err != nil {
+    cfg.Log().Error("Error applying settings", "err", err)
+    return fmt.Errorf("the settings were saved, but could not be applied: %s", err)
+  }
+  return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "strings"
+  "testing"
+)
+
+// opens the db in TEST_DATABASE_URL with every table created and emptied, skipping the test when it isn't set
+// the db is emptied before each test, so it must not be one used for anything else
+func requireDB(t *testing.T) {
+  t.Helper()
+  dbUrl := os.Getenv("TEST_DATABASE_URL")
+  if dbUrl == "" {
+    t.Skip("TEST_DATABASE_URL is not set")
+  }
+  if DB == nil {
+    var err error
+    if DB, err = sql.Open(METRICS_DB_DRIVER, dbUrl); err != nil {
+      t.Fatalf("opening the test db: %v", err)
+    }
+  }
+  if err := DB.Ping(); err != nil {
+    t.Fatalf("connecting to the test db: %v", err)
+  }
+  SchemaSetup()
+  DBSetup()
+  rows, err := DB.Query("SELECT tablename FROM pg_tables WHERE schemaname = current_schema();")
+  if err != nil {
+    t.Fatalf("listing the test db tables: %v", err)
+  }
+  var tables []string
+  for rows.Next() {
+    var table string
+    rows.Scan(&table)
+    tables = append(tables, table)
+  }
+  rows.Close()
+  if _, err = DB.Exec(fmt.Sprintf("TRUNCATE %s RESTART IDENTITY;", strings.Join(tables, ", "))); err != nil {
+    t.Fatalf("emptying the test db: %v", err)
+  }
+}
+
+// type for an http transport made of a function
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+  return f(r)
+}
+
+// sends the Slack API calls made from here on to the given handler, by method, until restore is called
+// the value returned by the handler is sent back as JSON
+func fakeSlack(handler func(method string, r *http.Request) interface{}) (restore func()) {
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(handler(strings.TrimPrefix(r.URL.Path, "/api/"), r))
+  }))
+  target, _ := url.Parse(server.URL)
+  transport := http.DefaultTransport
+  http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+    if r.URL.Host == "slack.com" {
+      r = r.Clone(r.Context())
+      r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+    }
+    return transport.RoundTrip(r)
+  })
+  return func() {
+    http.DefaultTransport = transport
+    server.Close()
+  }
+}
+
+// gets the params of a Slack API call, from its query or JSON body
+func slackParams(r *http.Request) map[string]string {
+  params := make(map[string]string)
+  json.NewDecoder(r.Body).Decode(&params)
+  for key := range r.URL.Query() {
+    params[key] = r.URL.Query().Get(key)
+  }
+  return params
+}
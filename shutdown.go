@@ -0,0 +1,85 @@
+package main
+
+import (
+  "context"
+  "net/http"
+  "os"
+  "os/signal"
+  "syscall"
+  "time"
+)
+
+// how long in-flight requests, jobs and DMs have to finish once a shutdown starts
+var SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// how long the work still running after SHUTDOWN_TIMEOUT has to give up, once its Slack and db calls are cancelled
+var SHUTDOWN_GRACE = 5 * time.Second
+
+// how long a Slack API call can take, reading its response included
+const SLACK_REQUEST_TIMEOUT = 15 * time.Second
+
+// how long a db query can take when its context has no deadline of its own
+const DB_QUERY_TIMEOUT = 10 * time.Second
+
+// the context every request, job and scheduled run derives from
+// it is only cancelled when work is still running after SHUTDOWN_TIMEOUT, to abort its Slack and db calls
+var BASE_CONTEXT, cancelBaseContext = context.WithCancel(context.Background())
+
+// waits for SIGINT or SIGTERM, then shuts down gracefully
+// a second signal exits right away
+func WaitForShutdown(server *http.Server) {
+  signals := make(chan os.Signal, 2)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+  sig := <-signals
+  LOG.Info("Shutting down", "signal", sig.String(), "timeout", SHUTDOWN_TIMEOUT)
+  go func() {
+    sig := <-signals
+    LOG.Warn("Exiting without finishing the shutdown", "signal", sig.String())
+    os.Exit(1)
+  }()
+  Shutdown(server)
+}
+
+// stops taking in events and commands, lets the work already started finish, then closes the db
+// work still running after SHUTDOWN_TIMEOUT has its Slack and db calls cancelled
+func Shutdown(server *http.Server) {
+  ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+  defer cancel()
+
+  // nothing new comes in from here on, while the handlers already running finish
+  // the server is waited on without a deadline, as handlers still running at the timeout are cancelled below
+  // rather than left running while the db is closed
+  drained := make(chan struct{})
+  go func() {
+    SOCKET.Stop()
+    if err := server.Shutdown(context.Background()); err != nil {
+      LOG.Warn("Error stopping the server", "err", err)
+    }
+    SCHEDULER.Stop()
+    QUEUE.Stop()
+    close(drained)
+  }()
+
+  select {
+  case <-drained:
+    LOG.Info("Finished running requests and jobs")
+  case <-ctx.Done():
+    LOG.Warn("Cancelling the requests and jobs still running", "grace", SHUTDOWN_GRACE)
+    cancelBaseContext()
+    select {
+    case <-drained:
+    case <-time.After(SHUTDOWN_GRACE):
+      LOG.Error("Requests and jobs did not stop after being cancelled")
+    }
+  }
+
+  // the lease is given up so another replica takes over the schedule right away
+  if LEADER != nil {
+    LEADER.Stop()
+  }
+  if err := DB.Close(); err != nil {
+    LOG.Error("Error closing db", "err", err)
+  }
+  TRACER.Stop()
+  LOG.Info("Shut down")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+  "context"
+  "database/sql"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+// starts a server running the given handler with a fake db in DB, returning it once a request is running in it
+// the request is answered once the handler returns
+func runningRequest(t *testing.T, handler func(cfg *Config)) (server *httptest.Server, done chan struct{}) {
+  db, err := sql.Open(FAKE_DB_DRIVER, "")
+  if err != nil {
+    t.Fatalf("opening the fake db: %v", err)
+  }
+  DB = db
+  started, done := make(chan struct{}), make(chan struct{})
+  server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    close(started)
+    handler(&Config{})
+  }))
+  go func() {
+    defer close(done)
+    if resp, err := http.Get(server.URL); err == nil {
+      resp.Body.Close()
+    }
+  }()
+  <-started
+  return server, done
+}
+
+// restores the shutdown timeouts and base context changed by a test
+func restoreShutdown(timeout, grace time.Duration) {
+  SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE = timeout, grace
+  BASE_CONTEXT, cancelBaseContext = context.WithCancel(context.Background())
+  DB = nil
+}
+
+func TestShutdownWaitsForRequestsBeforeClosingTheDB(t *testing.T) {
+  defer restoreShutdown(SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE)
+  release := make(chan struct{})
+  var queryErr error
+  server, done := runningRequest(t, func(cfg *Config) {
+    <-release
+    _, queryErr = DB.ExecContext(cfg.Context(), "UPDATE users SET name = $1;", "someone")
+  })
+
+  shutdown := make(chan struct{})
+  go func() {
+    Shutdown(server.Config)
+    close(shutdown)
+  }()
+  select {
+  case <-shutdown:
+    t.Fatalf("Shutdown() returned while a request was running")
+  case <-time.After(100 * time.Millisecond):
+  }
+  close(release)
+  <-shutdown
+  <-done
+
+  if queryErr != nil {
+    t.Errorf("query of the running request = %v, want it run before the db is closed", queryErr)
+  }
+  if err := DB.Ping(); err == nil {
+    t.Errorf("db still open after Shutdown()")
+  }
+  if BASE_CONTEXT.Err() != nil {
+    t.Errorf("base context cancelled although the request finished in time")
+  }
+}
+
+func TestShutdownCancelsRequestsAfterTheTimeout(t *testing.T) {
+  defer restoreShutdown(SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE)
+  SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE = 50*time.Millisecond, 5*time.Second
+  cancelled := make(chan struct{})
+  server, done := runningRequest(t, func(cfg *Config) {
+    <-cfg.Context().Done()
+    close(cancelled)
+  })
+
+  start := time.Now()
+  Shutdown(server.Config)
+  <-done
+  select {
+  case <-cancelled:
+  default:
+    t.Errorf("request was not cancelled")
+  }
+  if err := DB.Ping(); err == nil {
+    t.Errorf("db still open after Shutdown()")
+  }
+  // the request gives up as soon as it is cancelled, so the grace period isn't waited out
+  if elapsed := time.Since(start); elapsed >= SHUTDOWN_GRACE {
+    t.Errorf("Shutdown() took %s, want it to return once the cancelled request stopped", elapsed)
+  }
+}
+
+func TestShutdownGivesUpAfterTheGrace(t *testing.T) {
+  defer restoreShutdown(SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE)
+  SHUTDOWN_TIMEOUT, SHUTDOWN_GRACE = 50*time.Millisecond, 50*time.Millisecond
+  release := make(chan struct{})
+  defer close(release)
+  finished := make(chan struct{})
+  server, _ := runningRequest(t, func(cfg *Config) {
+    // ignores being cancelled
+    <-release
+    close(finished)
+  })
+
+  start := time.Now()
+  Shutdown(server.Config)
+  if elapsed := time.Since(start); elapsed < SHUTDOWN_TIMEOUT+SHUTDOWN_GRACE || elapsed > time.Second {
+    t.Errorf("Shutdown() took %s, want it to give up after the timeout and grace", elapsed)
+  }
+  select {
+  case <-finished:
+    t.Errorf("request finished before Shutdown() gave up on it")
+  default:
+  }
+  if BASE_CONTEXT.Err() == nil {
+    t.Errorf("base context not cancelled at the timeout")
+  }
+  if err := DB.Ping(); err == nil {
+    t.Errorf("db still open after Shutdown() gave up")
+  }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "strconv"
+  "time"
+)
+
+const SLACK_SIGNATURE_HEADER = "X-Slack-Signature"
+const SLACK_TIMESTAMP_HEADER = "X-Slack-Request-Timestamp"
+
+// the version of the signature scheme Slack signs requests with
+const SLACK_SIGNATURE_VERSION = "v0"
+
+// how far the timestamp of a signed request can be from now, so a captured request can't be replayed later
+const SLACK_SIGNATURE_MAX_AGE = 5 * time.Minute
+
+// the largest request body read to check its signature
+const SLACK_MAX_BODY_BYTES = 1 << 20
+
+// computes the signature Slack sends for a request with the given timestamp and body
+func SlackSignature(secret, timestamp string, body []byte) string {
+  mac := hmac.New(sha256.New, []byte(secret))
+  fmt.Fprintf(mac, "%s:%s:", SLACK_SIGNATURE_VERSION, timestamp)
+  mac.Write(body)
+  return SLACK_SIGNATURE_VERSION + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checks that the request was signed by Slack with the signing secret, less than SLACK_SIGNATURE_MAX_AGE from now
+// always fails when no signing secret is set
+func VerifySlackSignature(secret string, header http.Header, body []byte, now time.Time) error {
+  if secret == "" {
+    return fmt.Errorf("no signing secret is set")
+  }
+  timestamp := header.Get(SLACK_TIMESTAMP_HEADER)
+  signature := header.Get(SLACK_SIGNATURE_HEADER)
+  if timestamp == "" || signature == "" {
+    return fmt.Errorf("missing %s or %s header", SLACK_SIGNATURE_HEADER, SLACK_TIMESTAMP_HEADER)
+  }
+  seconds, err := strconv.ParseInt(timestamp, 10, 64)
+  if err != nil {
+    return fmt.Errorf("invalid timestamp %q", timestamp)
+  }
+  if age := now.Sub(time.Unix(seconds, 0)); age > SLACK_SIGNATURE_MAX_AGE || age < -SLACK_SIGNATURE_MAX_AGE {
+    return fmt.Errorf("timestamp is %s from now", age.Round(time.Second))
+  }
+  if !hmac.Equal([]byte(signature), []byte(SlackSignature(secret, timestamp, body))) {
+    return fmt.Errorf("signature does not match")
+  }
+  return nil
+}
+
+// wraps a handler for requests sent by Slack, rejecting those that aren't signed with the signing secret
+// before the handler runs, the body being put back for it to read
+func WithSlackSignature(handler ConfigHandlerFunc) ConfigHandlerFunc {
+  return func(cfg *Config, w http.ResponseWriter, r *http.Request) {
+    body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, SLACK_MAX_BODY_BYTES))
+    if err != nil {
+      http.Error(w, "Invalid request body", http.StatusBadRequest)
+      return
+    }
+    if err = VerifySlackSignature(cfg.SigningSecret, r.Header, body, time.Now()); err != nil {
+      cfg.Log().Warn("Rejected request not signed by Slack", "path", r.URL.Path, "err", err)
+      http.Error(w, "Invalid signature", http.StatusUnauthorized)
+      return
+    }
+    r.Body = ioutil.NopCloser(bytes.NewReader(body))
+    handler(cfg, w, r)
+  }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+)
+
+// the example request from Slack's documentation on verifying requests
+const EXAMPLE_SIGNING_SECRET = "8f742231b10e8888abcd99yyyzzz85a5"
+const EXAMPLE_TIMESTAMP = "1531420618"
+const EXAMPLE_BODY = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+const EXAMPLE_SIGNATURE = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+
+func signedHeader(secret string, timestamp time.Time, body string) http.Header {
+  header := http.Header{}
+  ts := strconv.FormatInt(timestamp.Unix(), 10)
+  header.Set(SLACK_TIMESTAMP_HEADER, ts)
+  header.Set(SLACK_SIGNATURE_HEADER, SlackSignature(secret, ts, []byte(body)))
+  return header
+}
+
+func TestSlackSignatureExample(t *testing.T) {
+  if got := SlackSignature(EXAMPLE_SIGNING_SECRET, EXAMPLE_TIMESTAMP, []byte(EXAMPLE_BODY)); got != EXAMPLE_SIGNATURE {
+    t.Fatalf("SlackSignature() = %q, want %q", got, EXAMPLE_SIGNATURE)
+  }
+  header := http.Header{}
+  header.Set(SLACK_TIMESTAMP_HEADER, EXAMPLE_TIMESTAMP)
+  header.Set(SLACK_SIGNATURE_HEADER, EXAMPLE_SIGNATURE)
+  if err := VerifySlackSignature(EXAMPLE_SIGNING_SECRET, header, []byte(EXAMPLE_BODY), time.Unix(1531420618, 0)); err != nil {
+    t.Fatalf("VerifySlackSignature() = %v, want nil", err)
+  }
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+  now := time.Now()
+  body := "user_id=U1&text=open"
+  tests := []struct {
+    name string
+    secret string
+    header http.Header
+    body string
+    ok bool
+  }{
+    {"valid", "secret", signedHeader("secret", now, body), body, true},
+    {"a little early", "secret", signedHeader("secret", now.Add(time.Minute), body), body, true},
+    {"almost too old", "secret", signedHeader("secret", now.Add(-SLACK_SIGNATURE_MAX_AGE+time.Second), body), body, true},
+    {"too old", "secret", signedHeader("secret", now.Add(-SLACK_SIGNATURE_MAX_AGE-time.Second), body), body, false},
+    {"too far ahead", "secret", signedHeader("secret", now.Add(SLACK_SIGNATURE_MAX_AGE+time.Second), body), body, false},
+    {"other secret", "secret", signedHeader("other", now, body), body, false},
+    {"tampered body", "secret", signedHeader("secret", now, body), "user_id=U2&text=open", false},
+    {"no secret set", "", signedHeader("", now, body), body, false},
+    {"no headers", "secret", http.Header{}, body, false},
+    {"invalid timestamp", "secret", http.Header{SLACK_TIMESTAMP_HEADER: {"yesterday"}, SLACK_SIGNATURE_HEADER: {"v0=00"}}, body, false},
+  }
+  for _, test := range tests {
+    err := VerifySlackSignature(test.secret, test.header, []byte(test.body), now)
+    if test.ok && err != nil {
+      t.Errorf("%s: VerifySlackSignature() = %v, want nil", test.name, err)
+    } else if !test.ok && err == nil {
+      t.Errorf("%s: VerifySlackSignature() = nil, want an error", test.name)
+    }
+  }
+}
+
+func TestRouterRejectsUnsignedSlackRequests(t *testing.T) {
+  CONFIG = NewConfigStore("", &Config{SigningSecret: "secret"})
+  router := NewRouter()
+  body := "user_id=U1&command=%2Fstandup&text=open"
+  // the handlers would need a db, so reaching any of them fails the test
+  for _, path := range []string{"/", "/standup", "/interactions", "/checkin", "/remind", "/close", "/report",
+    "/digest", "/blockers", "/followups"} {
+    for name, header := range map[string]http.Header{
+      "unsigned": {},
+      "wrong secret": signedHeader("other", time.Now(), body),
+      "replayed": signedHeader("secret", time.Now().Add(-time.Hour), body),
+    } {
+      r := httptest.NewRequest("POST", path, strings.NewReader(body))
+      r.Header = header
+      r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+      w := httptest.NewRecorder()
+      router.ServeHTTP(w, r)
+      if w.Code != http.StatusUnauthorized {
+        t.Errorf("%s request to %s: status %d, want %d", name, path, w.Code, http.StatusUnauthorized)
+      }
+    }
+  }
+}
+
+func TestRouterAcceptsSignedSlackRequests(t *testing.T) {
+  CONFIG = NewConfigStore("", &Config{SigningSecret: "secret"})
+  router := NewRouter()
+  body := `{"type":"url_verification","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`
+  r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+  r.Header = signedHeader("secret", time.Now(), body)
+  w := httptest.NewRecorder()
+  router.ServeHTTP(w, r)
+  if w.Code != http.StatusOK || w.Body.String() != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+    t.Fatalf("signed url verification: status %d body %q, want the challenge", w.Code, w.Body.String())
+  }
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/gorilla/websocket"
+)
+
+// the delay before reconnecting after the connection drops, doubled on every failure up to SOCKET_MAX_RECONNECT_DELAY
+const SOCKET_RECONNECT_DELAY = time.Second
+const SOCKET_MAX_RECONNECT_DELAY = time.Minute
+
+// Slack pings the connection every few seconds, so a connection silent for this long has dropped
+const SOCKET_IDLE_TIMEOUT = 2 * time.Minute
+
+const SOCKET_DIAL_TIMEOUT = 10 * time.Second
+const SOCKET_WRITE_TIMEOUT = 10 * time.Second
+
+// the largest message read, well above anything Slack sends
+const SOCKET_MAX_MESSAGE_SIZE = 16 << 20
+
+var SOCKET_DIALER = &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: SOCKET_DIAL_TIMEOUT}
+
+// type to contain a Socket Mode envelope, wrapping an event, slash command or interaction
+type SocketEnvelope struct {
+  EnvelopeId string `json:"envelope_id"`
+  Type string `json:"type"`
+  Payload json.RawMessage `json:"payload"`
+  Reason string `json:"reason"`
+  RetryAttempt int `json:"retry_attempt"`
+  RetryReason string `json:"retry_reason"`
+}
+
+// type to marshal the acknowledgement of an envelope into
+type SocketAck struct {
+  EnvelopeId string `json:"envelope_id"`
+  Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// type to contain the response of apps.connections.open
+type SocketConnection struct {
+  Ok bool `json:"ok"`
+  Url string `json:"url"`
+  Error string `json:"error"`
+}
+
+// the handlers slash commands received over Socket Mode are given to, by command name
+// these are the handlers of the endpoints each command is set up with over HTTP
+var SOCKET_COMMAND_HANDLERS map[string]ConfigHandlerFunc
+
+func init() {
+  SOCKET_COMMAND_HANDLERS = map[string]ConfigHandlerFunc{
+    "/standup": StandupCommandHandler,
+    "/checkin": HandleCheckin,
+    "/remindcheckin": RemindAwaiting,
+    "/endcheckin": CloseCheckinHandler,
+    "/checkinreport": ReportHandler,
+    "/checkindigest": DigestPreviewHandler,
+    "/blockers": BlockersHandler,
+    "/followups": FollowupsHandler,
+  }
+}
+
+// type to receive events, slash commands and interactions from Slack over a websocket,
+// for deployments that cannot expose a public url
+type SocketModeClient struct {
+  mtx sync.Mutex
+  stop chan struct{}
+  conn *socketConn
+  connected bool
+  connectedAt time.Time
+  reconnects int
+  handlers sync.WaitGroup
+  // handles the envelopes received, HandleEnvelope when nil
+  handle func(cfg *Config, envelope SocketEnvelope) json.RawMessage
+}
+
+var SOCKET = &SocketModeClient{}
+
+// type to contain a Socket Mode connection
+// messages are read from a single goroutine, while acks are written from the goroutines handling each envelope
+type socketConn struct {
+  ws *websocket.Conn
+  writeMtx sync.Mutex
+}
+
+// connects to the given Socket Mode url
+// pings from Slack keep the connection from going idle, and are answered while reading
+func dialSocket(wsUrl string) (*socketConn, error) {
+  ws, _, err := SOCKET_DIALER.Dial(wsUrl, nil)
+  if err != nil {
+    return nil, err
+  }
+  ws.SetReadLimit(SOCKET_MAX_MESSAGE_SIZE)
+  ws.SetPingHandler(func(data string) error {
+    ws.SetReadDeadline(time.Now().Add(SOCKET_IDLE_TIMEOUT))
+    err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(SOCKET_WRITE_TIMEOUT))
+    if err == websocket.ErrCloseSent {
+      return nil
+    }
+    return err
+  })
+  return &socketConn{ws: ws}, nil
+}
+
+// reads the next message, failing when nothing, pings included, came for SOCKET_IDLE_TIMEOUT
+func (c *socketConn) ReadMessage() ([]byte, error) {
+  c.ws.SetReadDeadline(time.Now().Add(SOCKET_IDLE_TIMEOUT))
+  _, message, err := c.ws.ReadMessage()
+  return message, err
+}
+
+// sends the given text message
+func (c *socketConn) WriteText(message []byte) error {
+  c.writeMtx.Lock()
+  defer c.writeMtx.Unlock()
+  c.ws.SetWriteDeadline(time.Now().Add(SOCKET_WRITE_TIMEOUT))
+  return c.ws.WriteMessage(websocket.TextMessage, message)
+}
+
+// sends a close message and closes the connection
+func (c *socketConn) Close() error {
+  c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+    time.Now().Add(SOCKET_WRITE_TIMEOUT))
+  return c.ws.Close()
+}
+
+// gets a websocket url for the app from apps.connections.open, using the app-level token
+func OpenSocketConnection(cfg *Config) (string, error) {
+  req, err := http.NewRequest("POST", fmt.Sprint(SERVICE_URL, "apps.connections.open"), nil)
+  if err != nil {
+    return "", err
+  }
+  req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cfg.AppToken))
+  req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+  res, err := DoRequest(cfg, req)
+  if err != nil {
+    return "", err
+  }
+  defer res.Body.Close()
+
+  var body SocketConnection
+  if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+    SLACK_API_REQUESTS.WithLabelValues("apps.connections.open", "invalid_response").Inc()
+    return "", err
+  }
+  SLACK_API_REQUESTS.WithLabelValues("apps.connections.open", SlackResult(body.Ok, body.Error)).Inc()
+  if !body.Ok {
+    return "", fmt.Errorf("apps.connections.open failed: %s", body.Error)
+  }
+  return body.Url, nil
+}
+
+// connects in the background, reconnecting with a backoff whenever the connection drops
+// the url to connect to is fetched again for every connection, as Slack only lets each one be used once
+func (s *SocketModeClient) Start() {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  if s.stop != nil {
+    return
+  }
+  s.stop = make(chan struct{})
+  go s.run(s.stop, func() (string, error) {
+    return OpenSocketConnection(CONFIG.Get())
+  })
+}
+
+// disconnects and stops reconnecting, then waits for the envelopes already received to be handled
+// envelopes whose ack could no longer be sent are delivered again by Slack, and deduplicated like retried events
+func (s *SocketModeClient) Stop() {
+  s.mtx.Lock()
+  if s.stop != nil {
+    close(s.stop)
+    s.stop = nil
+    if s.conn != nil {
+      s.conn.Close()
+    }
+  }
+  s.mtx.Unlock()
+  s.handlers.Wait()
+}
+
+// determines if the client is currently connected
+func (s *SocketModeClient) Connected() bool {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.connected
+}
+
+// gets the time the current connection was made, and how many times the client reconnected
+func (s *SocketModeClient) Stats() (connectedAt time.Time, reconnects int) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+  return s.connectedAt, s.reconnects
+}
+
+func (s *SocketModeClient) run(stop chan struct{}, getUrl func() (string, error)) {
+  delay := SOCKET_RECONNECT_DELAY
+  for {
+    started := time.Now()
+    err := s.connect(stop, getUrl)
+
+    select {
+    case <-stop:
+      return
+    default:
+    }
+
+    // a connection that stayed up for a while was healthy, so the backoff starts over
+    if time.Since(started) > SOCKET_MAX_RECONNECT_DELAY {
+      delay = SOCKET_RECONNECT_DELAY
+    }
+    // without an error, Slack asked for a new connection, which is made right away
+    if err != nil {
+      LOG.Error("Error in socket mode connection", "err", err, "reconnect_in", delay)
+      select {
+      case <-stop:
+        return
+      case <-time.After(delay):
+      }
+      delay *= 2
+      if delay > SOCKET_MAX_RECONNECT_DELAY {
+        delay = SOCKET_MAX_RECONNECT_DELAY
+      }
+    }
+
+    s.mtx.Lock()
+    s.reconnects++
+    s.mtx.Unlock()
+  }
+}
+
+// makes a single connection and handles its envelopes until it drops or Slack asks for a new one
+func (s *SocketModeClient) connect(stop chan struct{}, getUrl func() (string, error)) error {
+  wsUrl, err := getUrl()
+  if err != nil {
+    return err
+  }
+  conn, err := dialSocket(wsUrl)
+  if err != nil {
+    return err
+  }
+
+  s.mtx.Lock()
+  if s.stop != stop {
+    // stopped while connecting
+    s.mtx.Unlock()
+    conn.Close()
+    return nil
+  }
+  s.conn = conn
+  s.mtx.Unlock()
+
+  defer func() {
+    s.mtx.Lock()
+    s.conn = nil
+    s.connected = false
+    s.mtx.Unlock()
+    conn.Close()
+  }()
+
+  for {
+    message, err := conn.ReadMessage()
+    if err != nil {
+      return err
+    }
+    var envelope SocketEnvelope
+    if err = json.Unmarshal(message, &envelope); err != nil {
+      LOG.Error("Error parsing socket mode envelope", "err", err)
+      continue
+    }
+
+    switch envelope.Type {
+    case "hello":
+      LOG.Info("Socket mode connected")
+      s.mtx.Lock()
+      s.connected = true
+      s.connectedAt = time.Now()
+      s.mtx.Unlock()
+    case "disconnect":
+      // sent before Slack refreshes the connection
+      LOG.Info("Socket mode disconnect requested", "reason", envelope.Reason)
+      return nil
+    default:
+      // events are acknowledged right away as they are over HTTP, so Slack doesn't retry them while they are handled,
+      // while slash commands and interactions are acknowledged with their response
+      ackFirst := envelope.Type == "events_api"
+      if ackFirst {
+        ackEnvelope(conn, envelope.EnvelopeId, nil)
+      }
+      // envelopes are handled alongside each other, as slash commands can take a moment to reply
+      s.handlers.Add(1)
+      go func() {
+        defer s.handlers.Done()
+        cfg := CONFIG.Get().WithRequestId(NewRequestId())
+        cfg.Log().Debug("Received socket mode envelope", "envelope_id", envelope.EnvelopeId, "type", envelope.Type)
+        cfg, span := cfg.StartSpan(fmt.Sprint("socket ", envelope.Type), SPAN_KIND_SERVER, "socket.envelope_id", envelope.EnvelopeId)
+        handle := s.handle
+        if handle == nil {
+          handle = HandleEnvelope
+        }
+        payload := handle(cfg, envelope)
+        span.End()
+        if !ackFirst {
+          ackEnvelope(conn, envelope.EnvelopeId, payload)
+        }
+      }()
+    }
+  }
+}
+
+// acknowledges the envelope with the given id, with the payload to respond with if any
+func ackEnvelope(conn *socketConn, envelopeId string, payload json.RawMessage) {
+  if envelopeId == "" {
+    return
+  }
+  ack, _ := json.Marshal(SocketAck{EnvelopeId: envelopeId, Payload: payload})
+  if err := conn.WriteText(ack); err != nil {
+    LOG.Error("Error acknowledging socket mode envelope", "err", err)
+  }
+}
+
+// hands the envelope to the handler its payload would be sent to over HTTP
+// returns the payload to acknowledge the envelope with, which is the handler's response for slash commands
+// and interactions, or nil when there is nothing to respond with
+func HandleEnvelope(cfg *Config, envelope SocketEnvelope) json.RawMessage {
+  switch envelope.Type {
+  case "events_api":
+    r, err := http.NewRequest("POST", "/", bytes.NewReader(envelope.Payload))
+    if err != nil {
+      cfg.Log().Error("Error building socket mode event request", "err", err)
+      return nil
+    }
+    if envelope.RetryAttempt > 0 {
+      r.Header.Set("X-Slack-Retry-Num", strconv.Itoa(envelope.RetryAttempt))
+      r.Header.Set("X-Slack-Retry-Reason", envelope.RetryReason)
+    }
+    // events were acknowledged when they were received, so what the handler writes is dropped
+    HandleCallback(cfg, &socketResponseWriter{}, r)
+    return nil
+  case "slash_commands":
+    var fields map[string]interface{}
+    if err := json.Unmarshal(envelope.Payload, &fields); err != nil {
+      cfg.Log().Error("Error parsing socket mode slash command", "err", err)
+      return nil
+    }
+    form := url.Values{}
+    for key, value := range fields {
+      if text, ok := value.(string); ok {
+        form.Set(key, text)
+      }
+    }
+    handler, ok := SOCKET_COMMAND_HANDLERS[form.Get("command")]
+    if !ok {
+      cfg.Log().Warn("Unknown socket mode slash command", "command", form.Get("command"))
+      return nil
+    }
+    return recordSocketResponse(cfg, handler, form)
+  case "interactive":
+    form := url.Values{}
+    form.Set("payload", string(envelope.Payload))
+    return recordSocketResponse(cfg, InteractionsHandler, form)
+  default:
+    cfg.Log().Warn("Unknown socket mode envelope", "type", envelope.Type)
+    return nil
+  }
+}
+
+// type to collect what a handler writes in response to an envelope
+type socketResponseWriter struct {
+  header http.Header
+  status int
+  body bytes.Buffer
+}
+
+func (w *socketResponseWriter) Header() http.Header {
+  if w.header == nil {
+    w.header = make(http.Header)
+  }
+  return w.header
+}
+
+func (w *socketResponseWriter) WriteHeader(status int) {
+  if w.status == 0 {
+    w.status = status
+  }
+}
+
+func (w *socketResponseWriter) Write(data []byte) (int, error) {
+  w.WriteHeader(http.StatusOK)
+  return w.body.Write(data)
+}
+
+// runs the handler with the given form as the request body, and turns what it writes into an ack payload
+// JSON responses are passed on as they are, and plain text is sent as the text of an ephemeral message
+func recordSocketResponse(cfg *Config, handler ConfigHandlerFunc, form url.Values) json.RawMessage {
+  r, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+  if err != nil {
+    cfg.Log().Error("Error building socket mode request", "err", err)
+    return nil
+  }
+  r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  w := &socketResponseWriter{}
+  handler(cfg, w, r)
+
+  body := bytes.TrimSpace(w.body.Bytes())
+  if w.status != 0 && w.status != http.StatusOK {
+    cfg.Log().Warn("Socket mode handler failed", "status", w.status, "body", string(body))
+    return nil
+  }
+  if len(body) == 0 {
+    return nil
+  }
+  if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") && json.Valid(body) {
+    return body
+  }
+  payload, _ := json.Marshal(SlashResponse{ResponseType: "ephemeral", Text: string(body)})
+  return payload
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/gorilla/websocket"
+)
+
+// type to stand in for Slack's Socket Mode websocket server in tests
+// every connection made to it is handed over on conns
+type socketStandIn struct {
+  server *httptest.Server
+  conns chan *standInConn
+}
+
+// type to contain a connection accepted by the stand-in, as seen from the server
+type standInConn struct {
+  ws *websocket.Conn
+  acceptedAt time.Time
+}
+
+func newSocketStandIn(t *testing.T) *socketStandIn {
+  standIn := &socketStandIn{conns: make(chan *standInConn, 10)}
+  upgrader := websocket.Upgrader{}
+  standIn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    ws, err := upgrader.Upgrade(w, r, nil)
+    if err != nil {
+      t.Errorf("upgrading the websocket connection: %v", err)
+      return
+    }
+    standIn.conns <- &standInConn{ws: ws, acceptedAt: time.Now()}
+  }))
+  return standIn
+}
+
+// gets the url to connect to, as apps.connections.open would return it
+func (s *socketStandIn) Url() string {
+  return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/link/?ticket=test"
+}
+
+func (s *socketStandIn) Close() {
+  s.server.Close()
+}
+
+// waits for the client to connect
+func (s *socketStandIn) accept(t *testing.T, timeout time.Duration) *standInConn {
+  t.Helper()
+  select {
+  case conn := <-s.conns:
+    return conn
+  case <-time.After(timeout):
+    t.Fatalf("client did not connect within %s", timeout)
+    return nil
+  }
+}
+
+// sends the value as a JSON text message
+func (c *standInConn) send(t *testing.T, value interface{}) {
+  t.Helper()
+  if err := c.ws.WriteJSON(value); err != nil {
+    t.Fatalf("sending to the client: %v", err)
+  }
+}
+
+// reads the next ack the client sends
+func (c *standInConn) readAck(t *testing.T, timeout time.Duration) SocketAck {
+  t.Helper()
+  c.ws.SetReadDeadline(time.Now().Add(timeout))
+  defer c.ws.SetReadDeadline(time.Time{})
+  _, message, err := c.ws.ReadMessage()
+  if err != nil {
+    t.Fatalf("reading an ack: %v", err)
+  }
+  var ack SocketAck
+  if err = json.Unmarshal(message, &ack); err != nil {
+    t.Fatalf("parsing ack %s: %v", message, err)
+  }
+  return ack
+}
+
+// starts a Socket Mode client connecting to the stand-in, with envelopes given to the given handler
+func startSocketClient(t *testing.T, standIn *socketStandIn, handle func(cfg *Config, envelope SocketEnvelope) json.RawMessage) (*SocketModeClient, func()) {
+  restore := fakeSlack(func(method string, r *http.Request) interface{} {
+    if method != "apps.connections.open" {
+      t.Errorf("unexpected Slack call %s", method)
+    }
+    return map[string]interface{}{"ok": true, "url": standIn.Url()}
+  })
+  CONFIG = NewConfigStore("", &Config{AppToken: "xapp-test", SocketMode: true})
+  client := &SocketModeClient{handle: handle}
+  client.Start()
+  return client, func() {
+    client.Stop()
+    restore()
+  }
+}
+
+// waits up to the given time for the condition to hold
+func eventually(timeout time.Duration, condition func() bool) bool {
+  deadline := time.Now().Add(timeout)
+  for !condition() {
+    if time.Now().After(deadline) {
+      return false
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  return true
+}
+
+func TestSocketModeHello(t *testing.T) {
+  standIn := newSocketStandIn(t)
+  defer standIn.Close()
+  client, stop := startSocketClient(t, standIn, nil)
+  defer stop()
+
+  conn := standIn.accept(t, 5*time.Second)
+  if client.Connected() {
+    t.Errorf("client connected before hello")
+  }
+  conn.send(t, map[string]interface{}{"type": "hello", "num_connections": 1})
+  if !eventually(time.Second, client.Connected) {
+    t.Fatalf("client not connected after hello")
+  }
+}
+
+func TestSocketModeAcks(t *testing.T) {
+  standIn := newSocketStandIn(t)
+  defer standIn.Close()
+  release := make(chan struct{})
+  handled := make(chan string, 10)
+  _, stop := startSocketClient(t, standIn, func(cfg *Config, envelope SocketEnvelope) json.RawMessage {
+    <-release
+    handled <- envelope.EnvelopeId
+    if envelope.Type == "slash_commands" {
+      return json.RawMessage(`{"response_type":"ephemeral","text":"done"}`)
+    }
+    return nil
+  })
+  defer stop()
+  defer close(release)
+
+  conn := standIn.accept(t, 5*time.Second)
+  conn.send(t, map[string]interface{}{"type": "hello"})
+
+  // an event is acknowledged while it is still being handled
+  conn.send(t, SocketEnvelope{EnvelopeId: "env-event", Type: "events_api", Payload: json.RawMessage(`{"type":"event_callback"}`)})
+  if ack := conn.readAck(t, time.Second); ack.EnvelopeId != "env-event" || len(ack.Payload) != 0 {
+    t.Fatalf("event ack = %+v, want env-event without a payload", ack)
+  }
+  select {
+  case id := <-handled:
+    t.Fatalf("%s handled before it was released", id)
+  default:
+  }
+
+  // a slash command is acknowledged with its response, once it is handled
+  conn.send(t, SocketEnvelope{EnvelopeId: "env-command", Type: "slash_commands", Payload: json.RawMessage(`{"command":"/standup"}`)})
+  release <- struct{}{}
+  release <- struct{}{}
+  ack := conn.readAck(t, time.Second)
+  if ack.EnvelopeId != "env-command" {
+    t.Fatalf("slash command ack = %+v, want env-command", ack)
+  }
+  var response SlashResponse
+  if err := json.Unmarshal(ack.Payload, &response); err != nil || response.Text != "done" {
+    t.Errorf("slash command ack payload = %s, want the handler's response", ack.Payload)
+  }
+}
+
+func TestSocketModeDisconnect(t *testing.T) {
+  standIn := newSocketStandIn(t)
+  defer standIn.Close()
+  client, stop := startSocketClient(t, standIn, nil)
+  defer stop()
+
+  conn := standIn.accept(t, 5*time.Second)
+  conn.send(t, map[string]interface{}{"type": "hello"})
+  conn.send(t, map[string]interface{}{"type": "disconnect", "reason": "refresh_requested"})
+
+  // a requested disconnect is followed by a new connection right away, without backing off
+  next := standIn.accept(t, SOCKET_RECONNECT_DELAY/2)
+  if gap := next.acceptedAt.Sub(conn.acceptedAt); gap >= SOCKET_RECONNECT_DELAY {
+    t.Errorf("reconnected %s after the disconnect, want right away", gap)
+  }
+  next.send(t, map[string]interface{}{"type": "hello"})
+  if !eventually(time.Second, client.Connected) {
+    t.Fatalf("client not connected after the new hello")
+  }
+  if _, reconnects := client.Stats(); reconnects != 1 {
+    t.Errorf("reconnects = %d, want 1", reconnects)
+  }
+}
+
+func TestSocketModeReconnectBackoff(t *testing.T) {
+  standIn := newSocketStandIn(t)
+  defer standIn.Close()
+  client, stop := startSocketClient(t, standIn, nil)
+  defer stop()
+
+  // each dropped connection is made again after twice the delay of the last
+  conn := standIn.accept(t, 5*time.Second)
+  for _, delay := range []time.Duration{SOCKET_RECONNECT_DELAY, 2 * SOCKET_RECONNECT_DELAY} {
+    // dropped without a close message, as when the network fails
+    conn.ws.Close()
+    next := standIn.accept(t, delay+time.Second)
+    if gap := next.acceptedAt.Sub(conn.acceptedAt); gap < delay || gap > delay+time.Second {
+      t.Errorf("reconnected %s after the connection dropped, want about %s", gap, delay)
+    }
+    conn = next
+  }
+  if client.Connected() {
+    t.Errorf("client connected before hello")
+  }
+  if _, reconnects := client.Stats(); reconnects != 2 {
+    t.Errorf("reconnects = %d, want 2", reconnects)
+  }
+}
+
+func TestSocketModePings(t *testing.T) {
+  standIn := newSocketStandIn(t)
+  defer standIn.Close()
+  _, stop := startSocketClient(t, standIn, nil)
+  defer stop()
+
+  conn := standIn.accept(t, 5*time.Second)
+  pong := make(chan string, 1)
+  conn.ws.SetPongHandler(func(data string) error {
+    pong <- data
+    return nil
+  })
+  if err := conn.ws.WriteControl(websocket.PingMessage, []byte("ping-1"), time.Now().Add(time.Second)); err != nil {
+    t.Fatalf("pinging the client: %v", err)
+  }
+  // pongs are handled while reading
+  go conn.ws.ReadMessage()
+  select {
+  case data := <-pong:
+    if data != "ping-1" {
+      t.Errorf("pong = %q, want the ping's data", data)
+    }
+  case <-time.After(time.Second):
+    t.Errorf("client did not answer the ping")
+  }
+}
+
+func TestHandleEnvelopeSlashCommand(t *testing.T) {
+  defer useFakeDB(t)()
+  cfg := &Config{Standup: StandupConfig{Name: "standup"}}
+  payload := json.RawMessage(`{"command":"/standup","text":"help","user_id":"U1"}`)
+
+  ack := HandleEnvelope(cfg, SocketEnvelope{EnvelopeId: "env-1", Type: "slash_commands", Payload: payload})
+  var response SlashResponse
+  if err := json.Unmarshal(ack, &response); err != nil || response.ResponseType != "ephemeral" ||
+    !strings.Contains(response.Text, "help") {
+    t.Errorf("HandleEnvelope() of /standup help = %s, want the help as an ephemeral message", ack)
+  }
+
+  // a handler rejecting the request isn't answered
+  payload = json.RawMessage(`{"command":"/standup","text":"help","response_url":"https://example.com/hook"}`)
+  if ack = HandleEnvelope(cfg, SocketEnvelope{EnvelopeId: "env-2", Type: "slash_commands", Payload: payload}); ack != nil {
+    t.Errorf("HandleEnvelope() of a rejected slash command = %s, want nil", ack)
+  }
+  payload = json.RawMessage(`{"command":"/unknown"}`)
+  if ack = HandleEnvelope(cfg, SocketEnvelope{EnvelopeId: "env-3", Type: "slash_commands", Payload: payload}); ack != nil {
+    t.Errorf("HandleEnvelope() of an unknown slash command = %s, want nil", ack)
+  }
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "runtime"
+  "time"
+)
+
+// set at build time with -ldflags "-X main.BUILD_VERSION=... -X main.BUILD_COMMIT=..."
+var BUILD_VERSION = "dev"
+var BUILD_COMMIT = ""
+
+var STARTED_AT = time.Now()
+
+const REDACTED = "[redacted]"
+
+// type to contain build info for the status endpoint
+type BuildStatus struct {
+  Version string `json:"version"`
+  Commit string `json:"commit"`
+  GoVersion string `json:"go_version"`
+  StartedAt time.Time `json:"started_at"`
+  Uptime string `json:"uptime"`
+}
+
+// type to contain the current session for the status endpoint
+type SessionStatus struct {
+  Open bool `json:"open"`
+  SessionId int64 `json:"session_id"`
+  ThreadId string `json:"thread_id"`
+  PendingUsers []string `json:"pending_users"`
+}
+
+// type to contain the scheduler state for the status endpoint
+type SchedulerStatus struct {
+  Running bool `json:"running"`
+  Instance string `json:"instance"`
+  Leader bool `json:"leader"`
+  Jobs []JobStatus `json:"jobs"`
+}
+
+// type to contain the job queue state for the status endpoint
+type QueueStatus struct {
+  Running bool `json:"running"`
+  Jobs map[string]int `json:"jobs"`
+}
+
+// type to contain the Socket Mode connection state for the status endpoint
+type SocketStatus struct {
+  Enabled bool `json:"enabled"`
+  Connected bool `json:"connected"`
+  ConnectedAt *time.Time `json:"connected_at"`
+  Reconnects int `json:"reconnects"`
+}
+
+// type to contain the response of the status endpoint
+type Status struct {
+  Build BuildStatus `json:"build"`
+  Config map[string]interface{} `json:"config"`
+  Session SessionStatus `json:"session"`
+  Scheduler SchedulerStatus `json:"scheduler"`
+  Queue QueueStatus `json:"queue"`
+  Socket SocketStatus `json:"socket"`
+}
+
+// hides the value of a secret, only showing whether it is set
+func RedactSecret(secret string) string {
+  if secret == "" {
+    return ""
+  }
+  return REDACTED
+}
+
+// gets the current configuration with secrets redacted
+func GetRedactedConfig(cfg *Config) map[string]interface{} {
+  path, loadedAt := CONFIG.Source()
+  return map[string]interface{}{
+    "CONFIG_FILE": path,
+    "CONFIG_LOADED_AT": loadedAt,
+    "API_TOKEN": RedactSecret(cfg.ApiToken),
+    "API_AUTH_TOKEN": RedactSecret(cfg.ApiAuthToken),
+    "APP_TOKEN": RedactSecret(cfg.AppToken),
+    "SIGNING_SECRET": RedactSecret(cfg.SigningSecret),
+    "SOCKET_MODE": cfg.SocketMode,
+    "DATABASE_URL": RedactSecret(cfg.DatabaseUrl),
+    "STANDUP_NAME": cfg.Standup.Name,
+    "MAIN_CHANNEL_NAME": cfg.Standup.ChannelName,
+    "MAIN_CHANNEL_ID": cfg.Standup.ChannelId,
+    "PROMPT_NEW_MEMBERS": cfg.Standup.PromptNewMembers,
+    "ADMIN_USERS": cfg.AdminUsers,
+    "ALLOW_REMINDER_TRIGGERS": cfg.AllowReminderTriggers,
+    "OPEN_CHECKIN_STR": cfg.Standup.OpenMention,
+    "CLOSE_CHECKIN_STR": cfg.Standup.CloseMention,
+    "REMIND_CHECKIN_STR": cfg.Standup.RemindMention,
+    "DIGEST_SCHEDULE": cfg.Digest.Schedule,
+    "DIGEST_TARGET": cfg.Digest.Target,
+    "DIGEST_MANAGERS": cfg.Digest.Managers,
+    "BLOCKER_CHANNEL_ID": cfg.Blockers.ChannelId,
+    "BLOCKER_OWNER": cfg.Blockers.Owner,
+    "BLOCKER_PREFIX": cfg.BlockerDetector().Prefix,
+    "BLOCKER_KEYWORDS": cfg.BlockerDetector().Keywords,
+    "CHECKIN_PROMPT": cfg.Standup.Prompt,
+    "CHECKIN_REMINDER": cfg.Standup.Reminder,
+    "LOG_LEVEL": cfg.Logging.Level,
+    "LOG_FORMAT": cfg.Logging.Format,
+    "LOG_REDACT_TEXT": cfg.Logging.RedactText,
+    "OTEL_EXPORTER_OTLP_ENDPOINT": cfg.Tracing.Endpoint,
+    "OTEL_SERVICE_NAME": cfg.Tracing.ServiceName,
+  }
+}
+
+// gets the current status of the bot
+func GetStatus(cfg *Config) Status {
+  threadId := GetThreadId()
+  session := SessionStatus{Open: threadId != "", ThreadId: threadId, PendingUsers: []string{}}
+  if session.Open {
+    session.SessionId = GetCurrentSessionId(cfg)
+    session.PendingUsers = append(session.PendingUsers, GetUsers(cfg, "", false)...)
+  }
+  socket := SocketStatus{Enabled: cfg.SocketMode, Connected: SOCKET.Connected()}
+  connectedAt, reconnects := SOCKET.Stats()
+  socket.Reconnects = reconnects
+  if socket.Connected {
+    socket.ConnectedAt = &connectedAt
+  }
+
+  return Status{
+    Build: BuildStatus{
+      Version: BUILD_VERSION,
+      Commit: BUILD_COMMIT,
+      GoVersion: runtime.Version(),
+      StartedAt: STARTED_AT,
+      Uptime: time.Since(STARTED_AT).Round(time.Second).String(),
+    },
+    Config: GetRedactedConfig(cfg),
+    Session: session,
+    Scheduler: SchedulerStatus{
+      Running: SCHEDULER.Running(),
+      Instance: LEADER.Id(),
+      Leader: SCHEDULER.IsLeader(),
+      Jobs: SCHEDULER.Status(),
+    },
+    Queue: QueueStatus{Running: QUEUE.Running(), Jobs: GetQueueCounts()},
+    Socket: socket,
+  }
+}
+
+// the handler for the /api/status endpoint
+// responds with the status of the bot as JSON, with secrets redacted
+func StatusApiHandler(cfg *Config, w http.ResponseWriter, r *http.Request) {
+  if !IsApiAuthorized(cfg, r) {
+    http.Error(w, "unauthorized", http.StatusUnauthorized)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  if err := json.NewEncoder(w).Encode(GetStatus(cfg)); err != nil {
+    cfg.Log().Error("Error writing status", "err", err)
+  }
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+  "go.opentelemetry.io/otel/propagation"
+  "go.opentelemetry.io/otel/sdk/resource"
+  sdktrace "go.opentelemetry.io/otel/sdk/trace"
+  "go.opentelemetry.io/otel/trace"
+)
+
+// the span kinds
+const SPAN_KIND_INTERNAL = trace.SpanKindInternal
+const SPAN_KIND_SERVER = trace.SpanKindServer
+const SPAN_KIND_CLIENT = trace.SpanKindClient
+
+// the W3C trace context header, read from inbound requests to continue the caller's trace
+const TRACEPARENT_HEADER = "traceparent"
+
+const TRACE_SERVICE_NAME = "checkin"
+
+// how often finished spans are sent to the collector
+const TRACE_FLUSH_INTERVAL = 5 * time.Second
+
+// how many finished spans are sent at once, a full batch being sent without waiting for TRACE_FLUSH_INTERVAL
+const TRACE_BATCH_SIZE = 512
+
+// how many finished spans are kept while the collector cannot be reached, after which new ones are dropped
+const TRACE_MAX_QUEUED = 4096
+
+// how long sending a batch of spans can take
+const TRACE_EXPORT_TIMEOUT = 10 * time.Second
+
+// the propagator reading and writing traceparent headers
+var PROPAGATOR = propagation.TraceContext{}
+
+// type to contain the ids identifying a span within its trace
+type SpanContext = trace.SpanContext
+
+// type to contain a timed operation in a trace, taking its attributes as key value pairs like the logger
+// a nil span is a no-op, which is what StartSpan returns when tracing is off
+type Span struct {
+  span trace.Span
+}
+
+// type to hold the OpenTelemetry tracer provider sending spans to the configured collector
+type Tracer struct {
+  mtx sync.Mutex
+  endpoint string
+  serviceName string
+  provider *sdktrace.TracerProvider
+}
+
+var TRACER = &Tracer{}
+
+// applies the tracing settings of the config, tracing being off without an endpoint
+// the spans of the previous settings are sent before they are replaced
+func ConfigureTracing(cfg *Config) {
+  endpoint := strings.TrimSuffix(cfg.Tracing.Endpoint, "/")
+  TRACER.mtx.Lock()
+  if endpoint == TRACER.endpoint && cfg.Tracing.ServiceName == TRACER.serviceName {
+    TRACER.mtx.Unlock()
+    return
+  }
+  TRACER.mtx.Unlock()
+
+  var provider *sdktrace.TracerProvider
+  if endpoint != "" {
+    exporter, err := newExporter(endpoint)
+    if err != nil {
+      LOG.Error("Error creating the trace exporter, tracing is off", "err", err)
+    } else {
+      provider = sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter,
+          sdktrace.WithBatchTimeout(TRACE_FLUSH_INTERVAL),
+          sdktrace.WithMaxExportBatchSize(TRACE_BATCH_SIZE),
+          sdktrace.WithMaxQueueSize(TRACE_MAX_QUEUED),
+          sdktrace.WithExportTimeout(TRACE_EXPORT_TIMEOUT)),
+        sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.Tracing.ServiceName))),
+      )
+    }
+  }
+  TRACER.use(endpoint, cfg.Tracing.ServiceName, provider)
+}
+
+// creates an exporter sending spans to the collector at the endpoint over OTLP/HTTP
+func newExporter(endpoint string) (sdktrace.SpanExporter, error) {
+  u, err := url.Parse(endpoint)
+  if err != nil {
+    return nil, err
+  }
+  opts := []otlptracehttp.Option{
+    otlptracehttp.WithEndpoint(u.Host),
+    otlptracehttp.WithURLPath(fmt.Sprint(u.Path, "/v1/traces")),
+    otlptracehttp.WithTimeout(TRACE_EXPORT_TIMEOUT),
+  }
+  if u.Scheme == "http" {
+    opts = append(opts, otlptracehttp.WithInsecure())
+  }
+  // not derived from BASE_CONTEXT, so the last spans are still sent once a shutdown has cancelled it
+  return otlptracehttp.New(context.Background(), opts...)
+}
+
+// replaces the tracer provider, shutting down the previous one so its spans are sent
+func (t *Tracer) use(endpoint, serviceName string, provider *sdktrace.TracerProvider) {
+  t.mtx.Lock()
+  previous := t.provider
+  t.endpoint, t.serviceName, t.provider = endpoint, serviceName, provider
+  t.mtx.Unlock()
+  if previous != nil {
+    shutdownProvider(previous)
+  }
+}
+
+func shutdownProvider(provider *sdktrace.TracerProvider) {
+  ctx, cancel := context.WithTimeout(context.Background(), TRACE_EXPORT_TIMEOUT)
+  defer cancel()
+  if err := provider.Shutdown(ctx); err != nil {
+    LOG.Error("Error sending the last spans", "err", err)
+  }
+}
+
+// gets the tracer to start spans with, or nil if tracing is off
+func (t *Tracer) tracer() trace.Tracer {
+  t.mtx.Lock()
+  defer t.mtx.Unlock()
+  if t.provider == nil {
+    return nil
+  }
+  return t.provider.Tracer(TRACE_SERVICE_NAME)
+}
+
+// determines if spans are being recorded
+func (t *Tracer) Enabled() bool {
+  return t.tracer() != nil
+}
+
+// sends the spans finished so far
+func (t *Tracer) Flush() {
+  t.mtx.Lock()
+  provider := t.provider
+  t.mtx.Unlock()
+  if provider == nil {
+    return
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), TRACE_EXPORT_TIMEOUT)
+  defer cancel()
+  if err := provider.ForceFlush(ctx); err != nil {
+    LOG.Error("Error exporting spans", "err", err)
+  }
+}
+
+// stops tracing, sending the spans still queued first
+func (t *Tracer) Stop() {
+  t.use("", "", nil)
+}
+
+// converts key value pairs to span attributes
+func spanAttributes(attrs []interface{}) []attribute.KeyValue {
+  converted := make([]attribute.KeyValue, 0, len(attrs)/2)
+  for i := 0; i+1 < len(attrs); i += 2 {
+    key, ok := attrs[i].(string)
+    if !ok {
+      continue
+    }
+    switch v := plainValue(attrs[i+1]).(type) {
+    case bool:
+      converted = append(converted, attribute.Bool(key, v))
+    case int:
+      converted = append(converted, attribute.Int(key, v))
+    case int64:
+      converted = append(converted, attribute.Int64(key, v))
+    case float64:
+      converted = append(converted, attribute.Float64(key, v))
+    default:
+      converted = append(converted, attribute.String(key, fmt.Sprint(v)))
+    }
+  }
+  return converted
+}
+
+// formats the span context as a W3C traceparent header value, or "" if it is invalid
+func Traceparent(sc SpanContext) string {
+  carrier := propagation.MapCarrier{}
+  PROPAGATOR.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+  return carrier.Get(TRACEPARENT_HEADER)
+}
+
+// parses a W3C traceparent header value, returning an invalid span context if it cannot be parsed
+func ParseTraceparent(value string) SpanContext {
+  ctx := PROPAGATOR.Extract(context.Background(), propagation.MapCarrier{TRACEPARENT_HEADER: strings.TrimSpace(value)})
+  return trace.SpanContextFromContext(ctx)
+}
+
+// adds the span context to the context, so spans started with it are its children
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+  if !sc.IsValid() {
+    return ctx
+  }
+  return trace.ContextWithSpanContext(ctx, sc)
+}
+
+// gets the span context of the context, or an invalid one if it has none
+func SpanFromContext(ctx context.Context) SpanContext {
+  return trace.SpanContextFromContext(ctx)
+}
+
+// starts a span as a child of the span of the context, or as the root of a new trace
+// returns the context to start its children with, and a nil span if tracing is off
+func StartSpan(ctx context.Context, name string, kind trace.SpanKind, attrs ...interface{}) (context.Context, *Span) {
+  tracer := TRACER.tracer()
+  if tracer == nil {
+    return ctx, nil
+  }
+  if requestId := RequestIdFromContext(ctx); requestId != "" {
+    attrs = append(attrs, "request_id", requestId)
+  }
+  ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(spanAttributes(attrs)...))
+  return ctx, &Span{span: span}
+}
+
+// gets the ids of the span
+func (s *Span) Context() SpanContext {
+  if s == nil {
+    return SpanContext{}
+  }
+  return s.span.SpanContext()
+}
+
+// adds key value pairs to the span
+func (s *Span) SetAttributes(attrs ...interface{}) {
+  if s == nil {
+    return
+  }
+  s.span.SetAttributes(spanAttributes(attrs)...)
+}
+
+// marks the span as failed with the error, when there is one
+func (s *Span) SetError(err error) {
+  if s == nil || err == nil {
+    return
+  }
+  s.span.SetStatus(codes.Error, err.Error())
+}
+
+// ends the span, queueing it to be sent
+func (s *Span) End() {
+  if s == nil {
+    return
+  }
+  s.span.End()
+}
+
+// starts a span as a child of the span the config was made for, tagged with the request id of the config
+// returns a copy of the config to make the calls within the span with, as WithRequestId does
+func (cfg *Config) StartSpan(name string, kind trace.SpanKind, attrs ...interface{}) (*Config, *Span) {
+  _, span := StartSpan(cfg.Context(), name, kind, attrs...)
+  if span == nil {
+    return cfg, nil
+  }
+  return cfg.WithSpan(span.Context()), span
+}
+
+// creates a copy of the config whose calls are made within the given span
+// the copy shares everything else with the config, so it must not be changed
+func (cfg *Config) WithSpan(sc SpanContext) *Config {
+  spanCfg := *cfg
+  spanCfg.spanContext = sc
+  return &spanCfg
+}
+
+// gets the span the config was made for, which is invalid when it was made outside of a trace
+func (cfg *Config) SpanContext() SpanContext {
+  if cfg == nil {
+    return SpanContext{}
+  }
+  return cfg.spanContext
+}
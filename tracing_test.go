@@ -0,0 +1,150 @@
+package main
+
+import (
+  "database/sql"
+  "net/http"
+  "net/http/httptest"
+  "sync"
+  "testing"
+
+  sdktrace "go.opentelemetry.io/otel/sdk/trace"
+  "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// records the spans ended until the returned func is called, in place of sending them to a collector
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+  recorder := tracetest.NewSpanRecorder()
+  TRACER.use("recorder", "checkin-test", sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+  return recorder, TRACER.Stop
+}
+
+// gets the ended span with the given name
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+  t.Helper()
+  ended := recorder.Ended()
+  for _, span := range ended {
+    if span.Name() == name {
+      return span
+    }
+  }
+  names := make([]string, 0, len(ended))
+  for _, span := range ended {
+    names = append(names, span.Name())
+  }
+  t.Fatalf("no %q span was ended, got %v", name, names)
+  return nil
+}
+
+// gets the string value of the attribute of the span
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) string {
+  for _, attr := range span.Attributes() {
+    if string(attr.Key) == key {
+      return attr.Value.Emit()
+    }
+  }
+  return ""
+}
+
+func TestRequestTraced(t *testing.T) {
+  recorder, stop := recordSpans()
+  defer stop()
+  defer fakeSlack(func(method string, r *http.Request) interface{} {
+    return map[string]interface{}{"ok": true, "ts": "1000.1"}
+  })()
+  db := DB
+  defer func() { DB = db }()
+  var err error
+  if DB, err = sql.Open(FAKE_DB_DRIVER, ""); err != nil {
+    t.Fatalf("opening the fake db: %v", err)
+  }
+  CONFIG = NewConfigStore("", &Config{ApiToken: "xoxb-test"})
+
+  handler := WithConfig(func(cfg *Config, w http.ResponseWriter, r *http.Request) {
+    rows, err := DB.QueryContext(cfg.Context(), "SELECT user_id FROM participants;")
+    if err != nil {
+      t.Errorf("querying the fake db: %v", err)
+    } else {
+      rows.Close()
+    }
+    SendMessage(cfg, "hi", "C1", "")
+  })
+  caller := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+  r := httptest.NewRequest("POST", "/traced", nil)
+  r.Header.Set(TRACEPARENT_HEADER, caller)
+  handler(httptest.NewRecorder(), r)
+
+  request := endedSpan(t, recorder, "POST /traced")
+  if request.SpanKind() != SPAN_KIND_SERVER || request.SpanContext().TraceID().String() != "0af7651916cd43dd8448eb211c80319c" ||
+    request.Parent().SpanID().String() != "b7ad6b7169203331" {
+    t.Errorf("request span = %v %v with parent %v, want a server span continuing the caller's trace",
+      request.SpanKind(), request.SpanContext().TraceID(), request.Parent().SpanID())
+  }
+  for _, name := range []string{"slack chat.postMessage", "db prepare", "db select"} {
+    span := endedSpan(t, recorder, name)
+    if span.SpanKind() != SPAN_KIND_CLIENT || span.SpanContext().TraceID() != request.SpanContext().TraceID() ||
+      span.Parent().SpanID() != request.SpanContext().SpanID() {
+      t.Errorf("%s span = %v with parent %v, want a client span whose parent is the request span %v", name,
+        span.SpanKind(), span.Parent().SpanID(), request.SpanContext().SpanID())
+    }
+  }
+  if statement := spanAttribute(endedSpan(t, recorder, "db select"), "db.statement"); statement != "SELECT user_id FROM participants;" {
+    t.Errorf("db.statement = %q, want the query run", statement)
+  }
+  if method := spanAttribute(endedSpan(t, recorder, "slack chat.postMessage"), "slack.method"); method != "chat.postMessage" {
+    t.Errorf("slack.method = %q, want chat.postMessage", method)
+  }
+}
+
+func TestTraceparent(t *testing.T) {
+  caller := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+  sc := ParseTraceparent(caller)
+  if !sc.IsValid() {
+    t.Fatalf("ParseTraceparent(%q) is invalid", caller)
+  }
+  if got := Traceparent(sc); got != caller {
+    t.Errorf("Traceparent() = %q, want %q", got, caller)
+  }
+  for _, value := range []string{"", "00-0af7651916cd43dd8448eb211c80319c", "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+    "00-00000000000000000000000000000000-b7ad6b7169203331-01"} {
+    if ParseTraceparent(value).IsValid() {
+      t.Errorf("ParseTraceparent(%q) is valid, want invalid", value)
+    }
+  }
+  if got := Traceparent(SpanContext{}); got != "" {
+    t.Errorf("Traceparent() of an invalid span context = %q, want empty", got)
+  }
+}
+
+func TestSpansExported(t *testing.T) {
+  var mtx sync.Mutex
+  var contentTypes []string
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/v1/traces" || r.Method != "POST" {
+      http.NotFound(w, r)
+      return
+    }
+    mtx.Lock()
+    defer mtx.Unlock()
+    contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+  }))
+  defer server.Close()
+  ConfigureTracing(&Config{Tracing: TraceConfig{Endpoint: server.URL, ServiceName: "checkin-test"}})
+  defer ConfigureTracing(&Config{})
+
+  _, span := (&Config{}).StartSpan("exported", SPAN_KIND_INTERNAL)
+  if span == nil {
+    t.Fatalf("StartSpan() with an endpoint set = nil, want a span")
+  }
+  span.End()
+  // stopping sends the spans still queued
+  TRACER.Stop()
+
+  mtx.Lock()
+  defer mtx.Unlock()
+  if len(contentTypes) != 1 || contentTypes[0] != "application/x-protobuf" {
+    t.Errorf("collector got exports with content types %v, want one OTLP/HTTP protobuf export", contentTypes)
+  }
+  if _, span = (&Config{}).StartSpan("after stopping", SPAN_KIND_INTERNAL); span != nil {
+    t.Errorf("StartSpan() after stopping = %v, want nil", span)
+  }
+}